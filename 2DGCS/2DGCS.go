@@ -3,49 +3,28 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/csv"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"math"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
 	"time"
 
-	"github.com/StefanSchroeder/Golang-Ellipsoid/ellipsoid"
-	"github.com/gocarina/gocsv"
 	"github.com/kr/pretty"
 	"github.com/nfnt/resize"
 	"github.com/nomnom-ray/fauxgl"
-	"googlemaps.github.io/maps"
+	"github.com/nomnom-ray/golang/site"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
-const (
-	windWidth        = 600 //1280.0
-	windHeight       = 600 //720.0
-	degRadConversion = math.Pi / 180
-	imageAspectRatio = windWidth / windHeight
-
-	//south-east to north-west
-	//lat goes south north; long east west
-	latEnd, lngEnd      = 43.45245, -80.49600
-	latStart, lngStart  = 43.45135, -80.49400
-	sampleResolutionLat = 0.00001 //degrees
-	sampleResolutionLng = 0.00001 //degrees
-
-	scale = 4     // optional supersampling
-	fovy  = 90.0  // vertical field of view in degrees
-	near  = 0.001 // near clipping plane
-	far   = 10.0  // far clipping plane
-)
+//area, resolution, image size and camera are in site.SceneConfig
 
 var (
 	eye        = fauxgl.V(0, 0, 0)                  // camera position
 	center     = fauxgl.V(0, 0, 1)                  // view center position
 	up         = fauxgl.V(0, 1, 0)                  // up vector
-	light      = fauxgl.V(0.75, 0.5, 1).Normalize() // light direction
+	light      = fauxgl.V(0.5, -1, 0.5).Normalize() // towards the light in the model (x north, y down, z west): from the north-west and above
 	color      = fauxgl.HexColor("#ffb5b5")         // object color
 	background = fauxgl.HexColor("#FFF8E3")         // background color
 	pickedX    = 500
@@ -59,235 +38,147 @@ var (
 // convert Google maps data to normalized 3D model
 // create a 2D image of the 3D model
 func main() {
-
-	//web client to get vectors; costs money and slow;
-	//client will not run as long as resultRawModel.csv in folder
-	_, err := os.Stat("resultVectorModel.csv")
-	if err != nil {
-		if os.IsNotExist(err) {
-			compositeVector, primitiveIndex := getMapVector(scanner())
-			primitiveIndexDecoder(compositeVector, primitiveIndex)
-		} else {
-			log.Fatalf("fatal error: %s", err)
+	//subcommands; without one the terrain is downloaded, built and picked from
+	commands := map[string]func(args []string){
+		"cache":       cacheCommand,
+		"triangulate": triangulateCommand,
+		"export":      exportCommand,
+		"scene":       sceneCommand,
+		"pose":        poseCommand,
+		"bake":        bakeCommand,
+		"project":     projectCommand,
+		"render":      renderCommand,
+		"overlay":     overlayCommand,
+		"ortho":       orthoCommand,
+	}
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
 		}
 	}
 
-	// create a cartesian model with GCS as units
-	maxVert := getModel()
-
-	//find camera location in GCS
-	cameraLatitude := 43.4515683
-	cameraLongtitude := -80.4959493
-	cameraElevation := 0.000025
-	cameraLocation := &mapVector{
-		VertX:      0,
-		VertY:      0,
-		VertZ:      0,
-		Latitude:   cameraLatitude,
-		Longtitude: cameraLongtitude,
-		Elevation:  cameraElevation,
+	demFiles := flag.String("dem", "",
+		"comma separated .hgt/GeoTIFF elevation files for offline use; Google Maps is used when empty")
+	options := fetchOptions{}
+	flag.IntVar(&options.batchSize, "batch", 256, "locations per elevation request")
+	flag.IntVar(&options.workers, "workers", 4, "elevation requests in flight")
+	flag.Float64Var(&options.qps, "qps", 10, "elevation requests per second; 0 is unlimited")
+	flag.IntVar(&options.retries, "retries", 5, "retries of an elevation request failed by the network, a rate limit or a server error")
+	flag.DurationVar(&options.backoff, "backoff", time.Second, "wait before the first retry, doubled on every retry")
+	flag.DurationVar(&options.checkpointInterval, "checkpoint", 10*time.Second,
+		"how often downloaded vectors are saved for resuming; 0 saves only on failure")
+	cacheFile := flag.String("cache", "elevationCache.csv", "elevation cache file; empty disables the cache")
+	lookupFile := flag.String("lookup", "",
+		"pick from this lookup raster of the camera instead of rendering; baked when missing or stale")
+	configFile, applySceneFlags := site.SceneFlags(flag.CommandLine)
+	flag.Parse()
+
+	scene, err := site.LoadSceneConfig(*configFile)
+	if err == nil {
+		err = applySceneFlags(scene)
 	}
-	cameraLocation = modeller(cameraLocation)
-
-	cameraPerspective := cameraModel(maxVert, cameraLocation)
-	//3D-2D conversion
-	triangles, primitiveOnScreen := projection(maxVert, cameraPerspective)
-
-	if primitiveSelected, vertexSelected, ok := rasterPicking(pickedX, pickedY, triangles, primitiveOnScreen, cameraPerspective); ok {
-		pretty.Println(primitiveSelected)
-		pretty.Println(vertexSelected)
-	} else {
-		pretty.Println("picking: primitive not selected.")
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
 	}
 
-}
-
-func cameraModel(maxVert float64, cameraLocation *mapVector) fauxgl.Matrix {
-	// camera and projection parameters to create a single matrix
-	cameraRotationLR := float64(-90) - 90       //-ve rotates camera clockwise in degrees
-	cameraRotationUD := float64(-20.0)          //-ve rotates camera downwards in degrees
-	cameraX := float64(cameraLocation.VertX)    //-ve pans camera to the right
-	cameraZ := float64(cameraLocation.VertZ)    //-ve pans camera to the back
-	cameraHeight := float64(-0.00002252)        //height of the camera from ground
-	groundRef := float64(-cameraLocation.VertY) //ground reference to the lowest ground point in the tile
-
-	cameraPosition := fauxgl.Vector{
-		X: cameraX / maxVert,
-		Y: (cameraHeight + groundRef) / maxVert,
-		Z: cameraZ / maxVert,
-	}
-	cameraViewDirection := fauxgl.Vector{
-		X: 0,
-		Y: 0,
-		Z: 1,
-	}
-	cameraUp := fauxgl.Vector{
-		X: 0,
-		Y: -1,
-		Z: 0,
+	//a site mesh replaces the downloaded terrain in its scene file
+	if scene.Mesh.File != "" {
+		if err := importMesh(scene.Mesh, scene.Scene); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
 	}
-	cameraViewDirection = fauxgl.QuatRotate(
-		degToRad(cameraRotationLR), cameraUp).Rotate(cameraViewDirection)
-	cameraViewDirection = fauxgl.QuatRotate(
-		degToRad(cameraRotationUD), cameraViewDirection.Cross(cameraUp)).Rotate(cameraViewDirection)
-	cameraPerspective := fauxgl.LookAt(
-		cameraPosition, (cameraPosition).Add(cameraViewDirection), cameraUp).Perspective(
-		fovy, imageAspectRatio, near, far)
-
-	// camera := fauxgl.LookAt(cameraPosition, (cameraPosition).Add(cameraViewDirection), cameraUp)
-	// perspective := fauxgl.PerspectiveGL(fovy, imageAspectRatio, near, far)
-	// cameraPerspective := perspective.Mul(camera).Scale(fauxgl.Vector{10, 10, 1})
-
-	// pretty.Println("camera location:", cameraLocation)
 
-	return cameraPerspective
-}
-
-func modeller(cameraLocation *mapVector) *mapVector {
-
-	var compositeVector []*mapVector
-	compositeVector = append(compositeVector, cameraLocation)
+	//web client to get vectors; costs money and slow;
+	//client will not run as long as resultRawModel.csv in folder, nor for a site mesh
+	_, err = os.Stat(site.VectorModelFile)
+	if err != nil && scene.Mesh.File == "" {
+		if os.IsNotExist(err) {
+			var source elevationSource
+			if *demFiles != "" {
+				source, err = newDEMSource(strings.Split(*demFiles, ","))
+			} else {
+				source, err = newGoogleElevation(*scanner())
+			}
+			if err != nil {
+				log.Fatalf("fatal error: %s", err)
+			}
+			var cache *elevationCache
+			if *cacheFile != "" {
+				cache, err = loadElevationCache(*cacheFile)
+				if err != nil {
+					log.Fatalf("fatal error: %s", err)
+				}
+			}
+			compositeVector, primitiveIndex := getMapVector(scene.Area, source, options, cache)
+			primitiveIndexDecoder(compositeVector, primitiveIndex)
+		} else {
+			log.Fatalf("fatal error: %s", err)
+		}
+	}
 
-	clientsFile, err := os.Open("resultNormModelProperties.csv")
+	// create a cartesian model in metres around the area
+	model, err := newSceneModel(scene)
 	if err != nil {
-		panic(err)
+		log.Fatalf("fatal error: %s", err)
 	}
-	defer clientsFile.Close()
-
-	propertiesReader := csv.NewReader(bufio.NewReader(clientsFile))
 
-	var _, minVertX, minVertZ float64
-
-	for i := 0; i < 7; i++ {
-		property, error := propertiesReader.Read()
-		if error == io.EOF {
-			break
-		} else if error != nil {
-			log.Fatal(error)
-		}
-		_, err = strconv.ParseFloat(property[3], 64)
+	//the lookup raster is in the pixels of the camera image already
+	if *lookupFile != "" {
+		lookup, err := site.CameraLookup(*lookupFile, scene, model.properties, model.compositeVector, model.primitiveIndex)
 		if err != nil {
-			panic(err)
+			log.Fatalf("fatal error: %s", err)
 		}
-		minVertX, _ = strconv.ParseFloat(property[4], 64)
-		minVertZ, _ = strconv.ParseFloat(property[6], 64)
-	}
-
-	//localize the area using the minimum component of each vector as reference
-	for i := 0; i <= int(len(compositeVector)-1); i++ {
-		compositeVector[i].VertX = (math.Abs(compositeVector[i].Latitude) - minVertX)
-		compositeVector[i].VertY = compositeVector[i].Elevation
-		compositeVector[i].VertZ = (math.Abs(compositeVector[i].Longtitude) - minVertZ)
+		if primitiveSelected, gcs, ok := lookup.Pick(pickedX, pickedY); ok {
+			pretty.Println(primitiveSelected)
+			pretty.Println(gcs)
+		} else {
+			pretty.Println("picking: primitive not selected.")
+		}
+		return
 	}
 
-	// var normModel []*mapVector
-	// 	//read 3D vector model into struct
-	// 	clientsFile2, err := os.Open("resultNormModel.csv")
-	// 	if err != nil {
-	// 		panic(err)
-	// 	}
-	// 	defer clientsFile2.Close()
-	// 	if err := gocsv.UnmarshalFile(clientsFile2, &normModel); err != nil {
-	// 		panic(err)
-	// 	}
-
-	// for _,normVertex:=range normModel{
-
-	// }
-
-	return cameraLocation
-}
-
-func projection(maxVert float64, cameraPerspective fauxgl.Matrix) ([]*fauxgl.Triangle, []int) {
-
-	compositeVector := []*mapVector{}
-	primitiveIndex := []*mapPrimitiveIndex{}
-
-	//read 3D vector model into struct
-	clientsFile, err := os.Open("resultNormModel.csv")
+	//only the terrain at the level of detail the camera sees is rasterized and picked on
+	view, err := model.cameraView(true)
 	if err != nil {
-		panic(err)
+		log.Fatalf("fatal error: %s", err)
 	}
-	defer clientsFile.Close()
-	if err := gocsv.UnmarshalFile(clientsFile, &compositeVector); err != nil {
-		panic(err)
+	cameraPerspective := view.cameraPerspective
+	//3D-2D conversion
+	triangles, primitiveOnScreen := projection(view.maxVert, cameraPerspective, scene.Render,
+		view.compositeVector, view.primitiveIndex)
+
+	var bvh *triangleBVH
+	if scene.Render.Picker != "raster" {
+		bvh = newTriangleBVH(triangles)
 	}
-	//read 3D vector index into struct
-	clientsFile2, err := os.Open("resultPrimativeModel.csv")
-	if err != nil {
-		panic(err)
+
+	//the pick is on the image of the real lens
+	undistortedX, undistortedY := scene.Camera.Intrinsics.Undistort(float64(pickedX), float64(pickedY))
+	picked, ok := picking(scene.Render.Picker, undistortedX, undistortedY,
+		triangles, bvh, primitiveOnScreen, cameraPerspective, scene.Render)
+	if ok {
+		picked, ok = view.scenePick(picked)
 	}
-	defer clientsFile2.Close()
-	if err := gocsv.UnmarshalFile(clientsFile2, &primitiveIndex); err != nil {
-		panic(err)
+	if ok {
+		pretty.Println(picked)
+	} else {
+		pretty.Println("picking: primitive not selected.")
 	}
 
-	//normalize 3D model to 1:1:1 camera space
-	for i := 0; i <= int(len(compositeVector)-1); i++ {
-		compositeVector[i].VertX = compositeVector[i].VertX / maxVert
-		compositeVector[i].VertY = compositeVector[i].VertY / maxVert
-		compositeVector[i].VertZ = compositeVector[i].VertZ / maxVert
-	}
+}
 
-	// pretty.Println("map location:", compositeVector[6464])
-
-	//constructing a mesh of triangles from index to normalized vertices
-	var triangles []*fauxgl.Triangle
-	counter := 0.0
-	primitiveIDCounter := 0
-
-	primitiveCounter := 0.0
-	for _, index := range primitiveIndex[:] {
-		var triangle fauxgl.Triangle
-		for inner := 0; inner < 3; inner++ {
-			primitiveCounter = math.Mod(counter, 3)
-			if primitiveCounter == 0 {
-				triangle.V1.Position = fauxgl.Vector{
-					X: compositeVector[index.PrimitiveBottom].VertX,
-					Y: compositeVector[index.PrimitiveBottom].VertY,
-					Z: compositeVector[index.PrimitiveBottom].VertZ,
-				}
-				triangle.V1.Texture = fauxgl.Vector{
-					X: compositeVector[index.PrimitiveBottom].Latitude,
-					Y: compositeVector[index.PrimitiveBottom].Elevation,
-					Z: compositeVector[index.PrimitiveBottom].Longtitude,
-				}
-			} else if primitiveCounter == 1 {
-				triangle.V2.Position = fauxgl.Vector{
-					X: compositeVector[index.PrimitiveTop].VertX,
-					Y: compositeVector[index.PrimitiveTop].VertY,
-					Z: compositeVector[index.PrimitiveTop].VertZ,
-				}
-				triangle.V2.Texture = fauxgl.Vector{
-					X: compositeVector[index.PrimitiveTop].Latitude,
-					Y: compositeVector[index.PrimitiveTop].Elevation,
-					Z: compositeVector[index.PrimitiveTop].Longtitude,
-				}
-			} else if primitiveCounter == 2 {
-				triangle.V3.Position = fauxgl.Vector{
-					X: compositeVector[index.PrimitiveLeft].VertX,
-					Y: compositeVector[index.PrimitiveLeft].VertY,
-					Z: compositeVector[index.PrimitiveLeft].VertZ,
-				}
-				triangle.V3.Texture = fauxgl.Vector{
-					X: compositeVector[index.PrimitiveLeft].Latitude,
-					Y: compositeVector[index.PrimitiveLeft].Elevation,
-					Z: compositeVector[index.PrimitiveLeft].Longtitude,
-				}
-			}
-			counter++
-		}
-		triangle.PrimitiveID = int(primitiveIDCounter)
-		triangle.FixNormals()
-		triangles = append(triangles, &triangle)
-		primitiveIDCounter++
-	}
+func projection(maxVert float64, cameraPerspective fauxgl.Matrix, render site.RenderConfig,
+	compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) ([]*fauxgl.Triangle, []int) {
+
+	//normalize 3D model to 1:1:1 camera space; the terrain itself stays in metres
+	triangles := modelTriangles(maxVert, compositeVector, primitiveIndex)
 	mesh := fauxgl.NewEmptyMesh()
 	triangleMesh := fauxgl.NewTriangleMesh(triangles)
 	mesh.Add(triangleMesh)
 
 	//creating the window for CPU render
-	contextRender := fauxgl.NewContext(windWidth*scale, windHeight*scale)
+	contextRender := fauxgl.NewContext(render.Width*render.Scale, render.Height*render.Scale)
 	contextRender.SetPickingFlag(false)
 	contextRender.ClearColorBufferWith(fauxgl.Transparent)
 	// contextRender.ClearDepthBuffer()
@@ -300,15 +191,49 @@ func projection(maxVert float64, cameraPerspective fauxgl.Matrix) ([]*fauxgl.Tri
 	fmt.Println("**********RENDERING**********", time.Since(start), "**********RENDERING**********")
 
 	image := contextRender.Image()
-	image = resize.Resize(windWidth, windHeight, image, resize.Bilinear)
+	image = resize.Resize(uint(render.Width), uint(render.Height), image, resize.Bilinear)
 
 	fauxgl.SavePNG("out.png", image)
 
 	return triangles, contextRender.PrimitiveSelectable()
 }
 
-func rasterPicking(pickedX, pickedY int,
-	triangles []*fauxgl.Triangle, primitiveOnScreen []int, cameraPerspective fauxgl.Matrix) (*fauxgl.Triangle, *fauxgl.Vertex, bool) {
+//modelTriangles is the terrain in the normalized model; normals face up, the texture is the
+//GCS and the primitive ID the index of the primitive
+func modelTriangles(maxVert float64, compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) []*fauxgl.Triangle {
+	vertex := func(i int) fauxgl.Vertex {
+		vector := compositeVector[i]
+		return fauxgl.Vertex{
+			Position: fauxgl.Vector{X: vector.VertX / maxVert, Y: vector.VertY / maxVert, Z: vector.VertZ / maxVert},
+			Texture:  fauxgl.Vector{X: vector.Latitude, Y: vector.Elevation, Z: vector.Longtitude},
+		}
+	}
+	triangles := make([]*fauxgl.Triangle, len(primitiveIndex))
+	for i, index := range primitiveIndex {
+		triangle := fauxgl.NewTriangle(vertex(index.PrimitiveBottom), vertex(index.PrimitiveTop), vertex(index.PrimitiveLeft))
+		normal := triangle.Normal()
+		if normal.Y > 0 {
+			//Y is down
+			normal = normal.Negate()
+		}
+		triangle.V1.Normal, triangle.V2.Normal, triangle.V3.Normal = normal, normal, normal
+		triangle.PrimitiveID = i
+		triangles[i] = triangle
+	}
+	return triangles
+}
+
+//pickedPoint is where the ray of a pick meets the primitive picked
+type pickedPoint struct {
+	Triangle    *fauxgl.Triangle
+	PrimitiveID int
+	Vertex      *fauxgl.Vertex //the vertex the picking context returned
+	Position    fauxgl.Vector  //in the normalized model
+	GCS         fauxgl.Vector  //Latitude, Elevation and Longtitude like a vertex Texture
+}
+
+func rasterPicking(pickedX, pickedY float64, triangles []*fauxgl.Triangle, primitiveOnScreen []int,
+	cameraPerspective fauxgl.Matrix, render site.RenderConfig) (*pickedPoint, bool) {
 
 	var trianglesOnScreen []*fauxgl.Triangle
 
@@ -327,8 +252,9 @@ func rasterPicking(pickedX, pickedY int,
 	meshOnScreen.Add(triangleMesh)
 
 	//creating the window for CPU render
-	contextPicking := fauxgl.NewContext(windWidth*scale, windHeight*scale)
-	contextPicking.SetPickedXY(pickedX*scale, pickedY*scale)
+	contextPicking := fauxgl.NewContext(render.Width*render.Scale, render.Height*render.Scale)
+	//pixel centres are at .5 in the render
+	contextPicking.SetPickedXY(int((pickedX+0.5)*float64(render.Scale)), int((pickedY+0.5)*float64(render.Scale)))
 	contextPicking.SetPickingFlag(true)
 	contextPicking.SetPrimitiveOnScreen(nil)
 	// contextPicking.ClearDepthBuffer()
@@ -341,12 +267,30 @@ func rasterPicking(pickedX, pickedY int,
 	fmt.Println("***********PICKING***********", time.Since(start), "***********PICKING***********")
 
 	if ok, _ := contextPicking.ReturnedPick(); ok == nil {
-		return nil, nil, false
+		return nil, false
 	}
 
 	triangle, vertex := contextPicking.ReturnedPick()
 
-	return triangle, vertex, true
+	//the GCS is interpolated between the corners of the triangle where the ray of the pixel meets it,
+	//not that of the vertex returned, which is only as good as the grid spacing
+	picked := &pickedPoint{
+		Triangle:    triangle,
+		PrimitiveID: triangle.PrimitiveID,
+		Vertex:      vertex,
+		Position:    vertex.Position,
+		GCS:         vertex.Texture,
+	}
+	origin, direction := site.PixelRay(cameraPerspective.Inverse(), pickedX, pickedY, render)
+	if weights, distance, ok := site.Barycentric(origin, direction,
+		triangle.V1.Position, triangle.V2.Position, triangle.V3.Position); ok {
+		picked.Position = origin.Add(direction.MulScalar(distance))
+		picked.GCS = triangle.V1.Texture.MulScalar(weights.X).
+			Add(triangle.V2.Texture.MulScalar(weights.Y)).
+			Add(triangle.V3.Texture.MulScalar(weights.Z))
+	}
+
+	return picked, true
 }
 
 func sliceUniqMap(s []int) []int {
@@ -363,271 +307,58 @@ func sliceUniqMap(s []int) []int {
 	return s[:j]
 }
 
-func getModel() (maxVert float64) {
+func getMapVector(area site.AreaConfig, source elevationSource,
+	options fetchOptions, cache *elevationCache) ([]*site.MapVector, []*site.MapPrimitiveIndex) {
 
-	/*
-		compositeVector := []*mapVector{}
-		//read 3D model into struct
-		clientsFile, err := os.Open("resultVectorModel.csv")
-		if err != nil {
-			panic(err)
-		}
-		defer clientsFile.Close()
-		if err := gocsv.UnmarshalFile(clientsFile, &compositeVector); err != nil {
-			panic(err)
-		}
-
-		//finds the min/max of ground height, latitude and latitude
-		compositeVector[0].VertY = compositeVector[0].Elevation / 1.11 * 0.00001
-		minVertY := compositeVector[0].VertY
-		maxVertY := compositeVector[0].VertY
-		for i := 0; i <= int(len(compositeVector)-1); i++ {
-			compositeVector[i].VertY = compositeVector[i].Elevation / 1.11 * 0.00001
-			if compositeVector[i].VertY < minVertY {
-				minVertY = compositeVector[i].VertY
-			}
-			if compositeVector[i].VertY > maxVertY {
-				maxVertY = compositeVector[i].VertY
-			}
-		}
-		minVertX := math.Abs(compositeVector[0].Latitude)
-		minVertZ := math.Abs(compositeVector[0].Longtitude)
-		//localize the maximum elevation to the ground reference
-		maxVertY = maxVertY - minVertY
-
-		//localize the area using the minimum component of each vector as reference
-		for i := 0; i <= int(len(compositeVector)-1); i++ {
-			compositeVector[i].VertX = (math.Abs(compositeVector[i].Latitude) - minVertX)
-			compositeVector[i].VertY = (compositeVector[i].VertY - minVertY)
-			compositeVector[i].VertZ = (math.Abs(compositeVector[i].Longtitude) - minVertZ)
-		}
-		maxVertX := math.Abs(compositeVector[len(compositeVector)-1].VertX)
-		maxVertZ := math.Abs(compositeVector[len(compositeVector)-1].VertZ)
-		maxVert = math.Max(math.Max(maxVertX, maxVertZ), maxVertY)
-
-		// clientsFile2, err := os.OpenFile("resultNormModel.csv", os.O_RDWR|os.O_CREATE, os.ModePerm)
-		// if err != nil {
-		// 	panic(err)
-		// }
-		// defer clientsFile2.Close()
-		// err = gocsv.MarshalFile(&compositeVector, clientsFile2)
-		// if err != nil {
-		// 	panic(err)
-		// }
-
-		data := [][]string{
-			{strconv.FormatFloat(maxVertX, 'E', -1, 64),
-				strconv.FormatFloat(maxVertY, 'E', -1, 64),
-				strconv.FormatFloat(maxVertZ, 'E', -1, 64),
-				strconv.FormatFloat(maxVert, 'E', -1, 64),
-				strconv.FormatFloat(minVertX, 'E', -1, 64),
-				strconv.FormatFloat(minVertY, 'E', -1, 64),
-				strconv.FormatFloat(minVertZ, 'E', -1, 64)},
-		}
-
-		file, err := os.Create("resultNormModelProperties.csv")
-		checkError("Cannot create file", err)
-		defer file.Close()
-
-		writer := csv.NewWriter(file)
-		defer writer.Flush()
-
-		for _, value := range data {
-			err := writer.Write(value)
-			checkError("Cannot write to file", err)
-		}
-
-		return maxVert
-	*/
-	return 0.00199
-}
-
-func getMapVector(apiKey *string) ([]*mapVector, []*mapPrimitiveIndex) {
-
-	var compositeVector []*mapVector
-	var compositeVectorElem *mapVector
-	var primitiveIndex []*mapPrimitiveIndex
-	var primitiveIndexElem *mapPrimitiveIndex
-
-	baseLat := 2
-	baseLng := int(round(math.Abs((lngEnd - lngStart) / sampleResolutionLng)))
-	latHeight := int(round(math.Abs((latEnd - latStart) / sampleResolutionLat)))
-	latBaseIndex, lngBaseIndex := 1, 1
-	latBaseHeight := latStart + sampleResolutionLat
-	latBaseGround := latStart
-	vectorIndex := 0.0
-	//sometimes the count target is over by baseLng elements, because sampling goes over the boundary
-	downloadProgress := pb.StartNew(int((baseLng) * (latHeight - 1 + baseLat)))
-
-	clientAccount, err := maps.NewClient(maps.WithAPIKey(strings.TrimSuffix(*apiKey, "\r\n")))
+	//fetching and meshing are independent; the grid only fixes the order of the vertices
+	compositeVector, rows, cols := planGrid(area)
+	primitiveIndex, err := triangulateGrid(rows, cols)
 	if err != nil {
 		log.Fatalf("fatal error: %s", err)
 	}
 
-	//TODO: hangle case for less than 2 baseLng
-	for lngBaseIndex+latBaseIndex <= baseLat+baseLng {
-		for lngBaseIndex > 0 && latBaseIndex > 0 && lngBaseIndex <= baseLng && latBaseIndex <= baseLat {
-
-			lngLocation := lngStart + (float64(lngBaseIndex-1)*(lngEnd-lngStart))/(float64(baseLng)-1.0)
-			latLocation := latBaseGround + (float64(latBaseIndex-1)*(latBaseHeight-latBaseGround))/(float64(baseLat)-1.0)
-
-			r := &maps.ElevationRequest{
-				Locations: []maps.LatLng{
-					{Lat: latLocation, Lng: lngLocation},
-				},
-			}
-			baseVector, err := clientAccount.Elevation(context.Background(), r)
-			if err != nil {
-				log.Fatalf("fatal error: %s", err)
-			}
-
-			compositeVectorElem = &mapVector{
-				VertX: 0,
-				//90deg on X is flip Y and Z,then -ve nowY; -90deg is flip then -ve nowZ
-				VertZ:      0,
-				VertY:      0,
-				Latitude:   (*baseVector[0].Location).Lat,
-				Longtitude: (*baseVector[0].Location).Lng,
-				Elevation:  baseVector[0].Elevation,
-			}
-
-			compositeVector = append(compositeVector, compositeVectorElem)
-
-			primitiveCounter := math.Mod(vectorIndex, 2)
-
-			if primitiveCounter == 1 && len(compositeVector) > 2 {
-				primitiveIndexElem = &mapPrimitiveIndex{0, 0, 0}
-				primitiveIndexElem.PrimitiveBottom = int(vectorIndex - 3)
-				primitiveIndexElem.PrimitiveTop = int(vectorIndex - 2)
-				primitiveIndexElem.PrimitiveLeft = int(vectorIndex - 1)
-				primitiveIndex = append(primitiveIndex, primitiveIndexElem)
-				primitiveIndexElem = &mapPrimitiveIndex{0, 0, 0}
-				primitiveIndexElem.PrimitiveBottom = int(vectorIndex - 1)
-				primitiveIndexElem.PrimitiveTop = int(vectorIndex - 2)
-				primitiveIndexElem.PrimitiveLeft = int(vectorIndex - 0)
-				primitiveIndex = append(primitiveIndex, primitiveIndexElem)
-			}
-			downloadProgress.Increment()
-			vectorIndex++
-			latBaseIndex--
-			lngBaseIndex++
-		}
-		latBaseIndex += lngBaseIndex
-		lngBaseIndex = 1
-		if latBaseIndex >= baseLat {
-			lngBaseIndex += latBaseIndex - baseLat
-			latBaseIndex = baseLat
-		}
-	}
-	vectorIndex--
-
-	if 1 <= latHeight-1 {
-		for latTier := 0; latTier <= latHeight-baseLat; latTier += 2 {
-			for assignedIndex, assignedVector := range compositeVector[(latTier)*baseLng : (latTier+2)*baseLng] {
-				if odd(assignedIndex) {
-
-					r := &maps.ElevationRequest{
-						Locations: []maps.LatLng{
-							{Lat: assignedVector.Latitude + sampleResolutionLat, Lng: assignedVector.Longtitude},
-						},
-					}
-					baseVector, err := clientAccount.Elevation(context.Background(), r)
-					if err != nil {
-						log.Fatalf("fatal error: %s", err)
-					}
-					compositeVectorElem = &mapVector{
-						VertX:      0,
-						VertZ:      0,
-						VertY:      0,
-						Latitude:   (*baseVector[0].Location).Lat,
-						Longtitude: (*baseVector[0].Location).Lng,
-						Elevation:  baseVector[0].Elevation,
-					}
-					compositeVector = append(compositeVector, compositeVectorElem)
-
-					r = &maps.ElevationRequest{
-						Locations: []maps.LatLng{
-							{Lat: assignedVector.Latitude + sampleResolutionLat*2, Lng: assignedVector.Longtitude},
-						},
-					}
-					baseVector, err = clientAccount.Elevation(context.Background(), r)
-					if err != nil {
-						log.Fatalf("fatal error: %s", err)
-					}
-					compositeVectorElem = &mapVector{
-						VertX:      0,
-						VertZ:      0,
-						VertY:      0,
-						Latitude:   (*baseVector[0].Location).Lat,
-						Longtitude: (*baseVector[0].Location).Lng,
-						Elevation:  baseVector[0].Elevation,
-					}
-					compositeVector = append(compositeVector, compositeVectorElem)
-
-					primitiveCounterTiers := math.Mod(float64(latTier+1), 2)
-					indexBoundaryLng := ((latTier-latTier/2-1)+2)*baseLng*2 + 2
-					loopTierCounter := ((latTier - latTier/2 - 1) + 1) * baseLng * 2
-
-					if primitiveCounterTiers == 1 && len(compositeVector) > indexBoundaryLng {
-						primitiveIndexElem = &mapPrimitiveIndex{0, 0, 0}
-						primitiveIndexElem.PrimitiveBottom = int(assignedIndex + loopTierCounter - 2)
-						primitiveIndexElem.PrimitiveTop = int(vectorIndex - 1)
-						primitiveIndexElem.PrimitiveLeft = int(assignedIndex + loopTierCounter)
-						primitiveIndex = append(primitiveIndex, primitiveIndexElem)
-						primitiveIndexElem = &mapPrimitiveIndex{0, 0, 0}
-						primitiveIndexElem.PrimitiveBottom = int(assignedIndex + loopTierCounter)
-						primitiveIndexElem.PrimitiveTop = int(vectorIndex - 1)
-						primitiveIndexElem.PrimitiveLeft = int(vectorIndex + 1)
-						primitiveIndex = append(primitiveIndex, primitiveIndexElem)
-					}
-					vectorIndex += 2
-
-					if assignedIndex == baseLng*2-1 {
-						for i := 0; baseLng-1 > i; i++ {
-							primitiveIndexElem = &mapPrimitiveIndex{0, 0, 0}
-							primitiveIndexElem.PrimitiveBottom = int(vectorIndex) - (baseLng*2 - 1) + i*2
-							primitiveIndexElem.PrimitiveTop = int(vectorIndex) - (baseLng*2 - 2) + i*2
-							primitiveIndexElem.PrimitiveLeft = int(vectorIndex) - (baseLng*2 - 3) + i*2
-							primitiveIndex = append(primitiveIndex, primitiveIndexElem)
-							primitiveIndexElem = &mapPrimitiveIndex{0, 0, 0}
-							primitiveIndexElem.PrimitiveBottom = int(vectorIndex) - (baseLng*2 - 3) + i*2
-							primitiveIndexElem.PrimitiveTop = int(vectorIndex) - (baseLng*2 - 2) + i*2
-							primitiveIndexElem.PrimitiveLeft = int(vectorIndex) - (baseLng*2 - 4) + i*2
-							primitiveIndex = append(primitiveIndex, primitiveIndexElem)
-						}
-					}
-					downloadProgress.Increment()
-					downloadProgress.Increment()
-				}
-
-			}
-
-		}
-	}
-
-	clientsFile, err := os.OpenFile("resultVectorModel.csv", os.O_RDWR|os.O_CREATE, os.ModePerm)
+	//elevations are fetched in bulk in the order of the grid
+	checkpoint, restored, err := loadFetchCheckpoint(checkpointPath(source.Provider(), area),
+		options.checkpointInterval, compositeVector)
 	if err != nil {
-		panic(err)
+		log.Fatalf("fatal error: %s", err)
 	}
-	defer clientsFile.Close()
-
-	err = gocsv.MarshalFile(&compositeVector, clientsFile)
-	if err != nil {
-		panic(err)
+	downloadProgress := pb.StartNew(len(compositeVector))
+	if restored > 0 {
+		fmt.Println("resuming from checkpoint:", restored, "of", len(compositeVector), "vectors already downloaded")
+		downloadProgress.Add(restored)
 	}
 
-	clientsFile2, err := os.OpenFile("resultPrimativeModel.csv", os.O_RDWR|os.O_CREATE, os.ModePerm)
+	//stop at ctrl-c with the checkpoint saved
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = fetchElevations(ctx, source, compositeVector, options, downloadProgress, checkpoint, cache)
 	if err != nil {
-		panic(err)
+		log.Fatalf("fatal error: %s", err)
 	}
-	defer clientsFile2.Close()
 
-	err = gocsv.MarshalFile(&primitiveIndex, clientsFile2)
-	if err != nil {
-		panic(err)
+	//written over whole, so nothing of a larger model before is left at the end
+	if err := site.WriteVectorModel(compositeVector, primitiveIndex); err != nil {
+		log.Fatalf("fatal error: %s", err)
 	}
 
+	checkpoint.remove()
 	downloadProgress.FinishPrint("Vectors downloaded.")
+	//hits and misses are all counted before the download; the cache grew by what it fetched
+	if cache != nil {
+		fmt.Println(cache.stats())
+	}
 	return compositeVector, primitiveIndex
 }
 
@@ -640,7 +371,7 @@ func scanner() *string {
 	return &text
 }
 
-func primitiveIndexDecoder(compositeVector []*mapVector, primitiveIndex []*mapPrimitiveIndex) {
+func primitiveIndexDecoder(compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) {
 
 	// pretty.Println(compositeVector[len(compositeVector)-1])
 	// pretty.Println(compositeVector)
@@ -662,51 +393,8 @@ func primitiveIndexDecoder(compositeVector []*mapVector, primitiveIndex []*mapPr
 	// }
 }
 
-func mapBoundary() (float64, float64, float64) {
-	ellipsoidConfig := ellipsoid.Init(
-		"WGS84",
-		ellipsoid.Degrees,
-		ellipsoid.Meter,
-		ellipsoid.LongitudeIsSymmetric,
-		ellipsoid.BearingIsSymmetric)
-
-	xDistance, _ := ellipsoidConfig.To(
-		latStart,
-		lngStart,
-		latStart,
-		lngEnd)
-
-	yDistance, _ := ellipsoidConfig.To(
-		latStart,
-		lngStart,
-		latEnd,
-		lngStart)
-
-	return yDistance, xDistance, math.Max(xDistance, yDistance)
-}
-
-func degToRad(d float64) float64 { return d * degRadConversion }
-
-func odd(number int) bool { return number%2 != 0 }
-
-func round(f float64) int {
-	if math.Abs(f) < 0.5 {
-		return 0
-	}
-	return int(f + math.Copysign(0.5, f))
-}
-
 func checkError(message string, err error) {
 	if err != nil {
 		log.Fatal(message, err)
 	}
 }
-
-type mapVector struct {
-	VertX, VertY, VertZ             float64
-	Latitude, Longtitude, Elevation float64
-}
-
-type mapPrimitiveIndex struct {
-	PrimitiveBottom, PrimitiveTop, PrimitiveLeft int
-}
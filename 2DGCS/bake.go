@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//bakeCommand is "2DGCS bake [-o resultNormModel.lookup] [-tiff camera.tif]": bakes the
+//lookup raster of the camera, and writes it as a georeference layer of the camera image too;
+//a camera of the registry is baked to its ID.lookup
+func bakeCommand(args []string) {
+	commandFlags := flag.NewFlagSet("bake", flag.ExitOnError)
+	output := commandFlags.String("o", "", "lookup raster of the camera; resultNormModel.lookup, or ID.lookup for -camera, when empty")
+	tiffFile := commandFlags.String("tiff", "",
+		"float64 TIFF of latitude, longitude and elevation for every pixel of the camera image; NaN where no ground is seen")
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	if *output == "" {
+		*output = model.scene.LookupPath()
+	}
+	lookup, err := site.CameraLookup(*output, model.scene, model.properties, model.compositeVector, model.primitiveIndex)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	seen := 0
+	for _, primitive := range lookup.Primitive {
+		if primitive >= 0 {
+			seen++
+		}
+	}
+	fmt.Printf("%dx%d lookup in %s; ground in %d pixels\n", lookup.Width, lookup.Height, *output, seen)
+
+	if *tiffFile == "" {
+		return
+	}
+	samples := make([]float64, lookup.Width*lookup.Height*3)
+	for y := 0; y < lookup.Height; y++ {
+		for x := 0; x < lookup.Width; x++ {
+			i := y*lookup.Width + x
+			if _, gcs, ok := lookup.Pick(x, y); ok {
+				samples[i*3], samples[i*3+1], samples[i*3+2] = gcs.X, gcs.Z, gcs.Y
+			} else {
+				samples[i*3], samples[i*3+1], samples[i*3+2] = math.NaN(), math.NaN(), math.NaN()
+			}
+		}
+	}
+	if err := writeFloatTIFF(*tiffFile, lookup.Width, lookup.Height, 3, samples, nil); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fmt.Println("latitude, longitude and elevation bands written to", *tiffFile)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//a node of the hierarchy holds no more triangles than this before it is split
+const bvhLeafTriangles = 8
+
+//triangleBVH is a bounding volume hierarchy over the triangles of a projection, in the
+//normalized model; nodes are kept in one slice, the two children of a node next to each other
+type triangleBVH struct {
+	triangles []*fauxgl.Triangle
+	order     []int //triangles sorted so every node holds a run of them
+	nodes     []bvhNode
+}
+
+type bvhNode struct {
+	min, max    fauxgl.Vector
+	left        int //first child, the second is next to it; 0 at a leaf
+	first, size int //run of order at a leaf
+}
+
+func newTriangleBVH(triangles []*fauxgl.Triangle) *triangleBVH {
+	b := &triangleBVH{triangles: triangles, order: make([]int, len(triangles))}
+	centroids := make([]fauxgl.Vector, len(triangles))
+	for i, triangle := range triangles {
+		b.order[i] = i
+		centroids[i] = triangle.V1.Position.Add(triangle.V2.Position).Add(triangle.V3.Position).DivScalar(3)
+	}
+	b.nodes = append(b.nodes, bvhNode{})
+	b.build(0, 0, len(triangles), centroids)
+	return b
+}
+
+//build bounds the run of order, and splits it in half at the median centroid of its longest axis
+func (b *triangleBVH) build(node, first, size int, centroids []fauxgl.Vector) {
+	min := fauxgl.Vector{X: math.Inf(1), Y: math.Inf(1), Z: math.Inf(1)}
+	max := fauxgl.Vector{X: math.Inf(-1), Y: math.Inf(-1), Z: math.Inf(-1)}
+	for _, i := range b.order[first : first+size] {
+		triangle := b.triangles[i]
+		for _, position := range []fauxgl.Vector{triangle.V1.Position, triangle.V2.Position, triangle.V3.Position} {
+			min, max = min.Min(position), max.Max(position)
+		}
+	}
+	b.nodes[node] = bvhNode{min: min, max: max, first: first, size: size}
+	if size <= bvhLeafTriangles {
+		return
+	}
+
+	extent := max.Sub(min)
+	axis := func(v fauxgl.Vector) float64 { return v.X }
+	if extent.Y > extent.X && extent.Y >= extent.Z {
+		axis = func(v fauxgl.Vector) float64 { return v.Y }
+	} else if extent.Z > extent.X && extent.Z > extent.Y {
+		axis = func(v fauxgl.Vector) float64 { return v.Z }
+	}
+	run := b.order[first : first+size]
+	sort.Slice(run, func(i, j int) bool { return axis(centroids[run[i]]) < axis(centroids[run[j]]) })
+
+	left := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{}, bvhNode{})
+	b.nodes[node].left = left
+	b.build(left, first, size/2, centroids)
+	b.build(left+1, first+size/2, size-size/2, centroids)
+}
+
+//hits tells if the ray enters the box of a node before distance along it (the slab test)
+func (n *bvhNode) hits(origin, inverse fauxgl.Vector, distance float64) bool {
+	near, far := 0.0, distance
+	for _, slab := range [][4]float64{
+		{origin.X, inverse.X, n.min.X, n.max.X},
+		{origin.Y, inverse.Y, n.min.Y, n.max.Y},
+		{origin.Z, inverse.Z, n.min.Z, n.max.Z},
+	} {
+		t1, t2 := (slab[2]-slab[0])*slab[1], (slab[3]-slab[0])*slab[1]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		near, far = math.Max(near, t1), math.Min(far, t2)
+		if near > far {
+			return false
+		}
+	}
+	return true
+}
+
+//intersect finds the nearest triangle the ray hits within distance 1 of the direction, the way
+//site.PixelRay spans the near to the far plane: the triangle, how far along and the weights of its corners.
+//facing is the sign of the determinant of the camera matrix, or 0 to hit the back of triangles too
+func (b *triangleBVH) intersect(origin, direction fauxgl.Vector, facing float64) (int, float64, fauxgl.Vector, bool) {
+	inverse := fauxgl.Vector{X: 1 / direction.X, Y: 1 / direction.Y, Z: 1 / direction.Z}
+	nearest, nearestDistance, nearestWeights := -1, 1.0, fauxgl.Vector{}
+	if len(b.triangles) == 0 {
+		return -1, 0, nearestWeights, false
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if !node.hits(origin, inverse, nearestDistance) {
+			continue
+		}
+		if node.left != 0 {
+			stack = append(stack, node.left, node.left+1)
+			continue
+		}
+		for _, i := range b.order[node.first : node.first+node.size] {
+			triangle := b.triangles[i]
+			weights, distance, ok := site.Barycentric(origin, direction,
+				triangle.V1.Position, triangle.V2.Position, triangle.V3.Position)
+			if !ok || distance < 0 || distance >= nearestDistance ||
+				weights.X < -1e-12 || weights.Y < -1e-12 || weights.Z < -1e-12 {
+				continue
+			}
+			if facing != 0 && backFacing(triangle, direction, facing) {
+				continue
+			}
+			nearest, nearestDistance, nearestWeights = i, distance, weights
+		}
+	}
+	return nearest, nearestDistance, nearestWeights, nearest >= 0
+}
+
+//backFacing tells if the ray sees the back of the triangle. The raster pickers cull the triangles
+//wound clockwise on the screen; a camera matrix keeps the turn of the corners about the ray where
+//its determinant is positive and reverses it where it is negative, as a perspective does
+func backFacing(triangle *fauxgl.Triangle, direction fauxgl.Vector, facing float64) bool {
+	normal := triangle.V2.Position.Sub(triangle.V1.Position).Cross(triangle.V3.Position.Sub(triangle.V1.Position))
+	return normal.Dot(direction)*facing <= 0
+}
+
+//rayPicking casts the ray of the pixel through the camera matrix into the BVH of the triangles of a
+//projection instead of rasterizing them; what it returns is as rasterPicking's, the vertex the corner
+//nearest the hit. Like the raster picker it sees through the back of triangles, so a camera under
+//the terrain picks nothing
+func rayPicking(pickedX, pickedY float64, bvh *triangleBVH,
+	cameraPerspective fauxgl.Matrix, render site.RenderConfig) (*pickedPoint, bool) {
+
+	start := time.Now()
+	origin, direction := site.PixelRay(cameraPerspective.Inverse(), pickedX, pickedY, render)
+	i, distance, weights, ok := bvh.intersect(origin, direction, math.Copysign(1, cameraPerspective.Determinant()))
+	fmt.Println("**********RAY CASTING**********", time.Since(start), "**********RAY CASTING**********")
+	if !ok {
+		return nil, false
+	}
+
+	triangle := bvh.triangles[i]
+	vertex := &triangle.V1
+	if weights.Y > weights.X && weights.Y >= weights.Z {
+		vertex = &triangle.V2
+	} else if weights.Z > weights.X && weights.Z > weights.Y {
+		vertex = &triangle.V3
+	}
+	return &pickedPoint{
+		Triangle:    triangle,
+		PrimitiveID: triangle.PrimitiveID,
+		Vertex:      vertex,
+		Position:    origin.Add(direction.MulScalar(distance)),
+		GCS: triangle.V1.Texture.MulScalar(weights.X).
+			Add(triangle.V2.Texture.MulScalar(weights.Y)).
+			Add(triangle.V3.Texture.MulScalar(weights.Z)),
+	}, true
+}
+
+//picking picks with the picker of the render config; "compare" ray casts, and reports
+//where the raster picker disagrees with it. bvh is built once over the triangles of the projection
+//for the pickers that ray cast, and is nil for the raster picker
+func picking(picker string, pickedX, pickedY float64, triangles []*fauxgl.Triangle, bvh *triangleBVH,
+	primitiveOnScreen []int, cameraPerspective fauxgl.Matrix, render site.RenderConfig) (*pickedPoint, bool) {
+
+	switch picker {
+	case "raycast":
+		return rayPicking(pickedX, pickedY, bvh, cameraPerspective, render)
+	case "compare":
+		picked, ok := rayPicking(pickedX, pickedY, bvh, cameraPerspective, render)
+		rastered, rasterOK := rasterPicking(pickedX, pickedY, triangles, primitiveOnScreen, cameraPerspective, render)
+		switch {
+		case ok != rasterOK:
+			fmt.Printf("picking: ray cast hit %t, raster picked %t\n", ok, rasterOK)
+		case ok && picked.PrimitiveID != rastered.PrimitiveID:
+			fmt.Printf("picking: ray cast hit primitive %d, raster picked %d; elevations %.3f metres apart\n",
+				picked.PrimitiveID, rastered.PrimitiveID, math.Abs(picked.GCS.Y-rastered.GCS.Y))
+		}
+		return picked, ok
+	}
+	return rasterPicking(pickedX, pickedY, triangles, primitiveOnScreen, cameraPerspective, render)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nomnom-ray/fauxgl"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//pickingScene is a 9x11 grid of about 4 metres, rolling by up to 2 metres, seen by a camera
+type pickingScene struct {
+	vectors           []*site.MapVector
+	primitiveIndex    []*site.MapPrimitiveIndex
+	cols              int
+	maxVert           float64
+	triangles         []*fauxgl.Triangle
+	primitiveOnScreen []int
+	cameraPerspective fauxgl.Matrix
+	render            site.RenderConfig
+}
+
+func newPickingScene(t *testing.T, camera site.CameraConfig) *pickingScene {
+	vectors, rows, cols := planGrid(site.AreaConfig{
+		LatStart: 43.4500, LngStart: -80.4900, LatEnd: 43.4504, LngEnd: -80.4895,
+		ResolutionLat: 0.00005, ResolutionLng: 0.00005,
+	})
+	properties := &site.ModelProperties{
+		OriginLatitude: 43.45, OriginLongitude: -80.49, OriginElevation: 330, MaxVert: 100,
+	}
+	for i, vector := range vectors {
+		vector.Elevation = properties.OriginElevation + 1 + math.Sin(float64(i/cols)/2)*math.Cos(float64(i%cols)/3)
+		properties.Localize(vector)
+	}
+	primitiveIndex, err := triangulateGrid(rows, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	triangles := modelTriangles(properties.MaxVert, vectors, primitiveIndex)
+	primitiveOnScreen := make([]int, len(triangles))
+	for i := range primitiveOnScreen {
+		primitiveOnScreen[i] = i
+	}
+
+	render := site.RenderConfig{Width: 160, Height: 90, Scale: 1, Fovy: 60}
+	location := &site.MapVector{Latitude: camera.Latitude, Longtitude: camera.Longitude, Elevation: properties.OriginElevation}
+	properties.Localize(location)
+	return &pickingScene{
+		vectors:           vectors,
+		primitiveIndex:    primitiveIndex,
+		cols:              cols,
+		maxVert:           properties.MaxVert,
+		triangles:         triangles,
+		primitiveOnScreen: primitiveOnScreen,
+		cameraPerspective: site.CameraModel(properties.MaxVert, location, camera, render),
+		render:            render,
+	}
+}
+
+//overhead looks down on the middle of the grid, steeply enough that no slope hides another
+var overhead = site.CameraConfig{Latitude: 43.4502, Longitude: -80.48975, Height: 30, RotationUD: -80}
+
+//knownPick is a point on a known triangle and the pixel it projects to
+type knownPick struct {
+	pickedX, pickedY float64
+	primitiveID      int
+	gcs              fauxgl.Vector
+}
+
+//pixel projects a point of the normalized model through the camera matrix; false off the image
+func (s *pickingScene) pixel(position fauxgl.Vector) (float64, float64, bool) {
+	clip := s.cameraPerspective.MulPositionW(position)
+	if clip.W <= 0 {
+		return 0, 0, false
+	}
+	pickedX := (clip.X/clip.W+1)/2*float64(s.render.Width) - 0.5
+	pickedY := (1-clip.Y/clip.W)/2*float64(s.render.Height) - 0.5
+	return pickedX, pickedY, pickedX >= 0 && pickedX <= float64(s.render.Width-1) &&
+		pickedY >= 0 && pickedY <= float64(s.render.Height-1)
+}
+
+//knownPicks places points inside every triangle by their weights and keeps those on the image;
+//what they are is known without casting a ray
+func (s *pickingScene) knownPicks() []knownPick {
+	var picks []knownPick
+	for i, triangle := range s.triangles {
+		for _, weights := range []fauxgl.Vector{{X: 0.2, Y: 0.3, Z: 0.5}, {X: 0.6, Y: 0.25, Z: 0.15}} {
+			position := triangle.V1.Position.MulScalar(weights.X).
+				Add(triangle.V2.Position.MulScalar(weights.Y)).
+				Add(triangle.V3.Position.MulScalar(weights.Z))
+			pickedX, pickedY, ok := s.pixel(position)
+			if !ok {
+				continue
+			}
+			picks = append(picks, knownPick{pickedX, pickedY, i, triangle.V1.Texture.MulScalar(weights.X).
+				Add(triangle.V2.Texture.MulScalar(weights.Y)).
+				Add(triangle.V3.Texture.MulScalar(weights.Z))})
+		}
+	}
+	return picks
+}
+
+//sharedEdge is the middle of the diagonal the two triangles of a cell share, where a ray grazes both
+func (s *pickingScene) sharedEdge() (knownPick, bool) {
+	cell := 4*(s.cols-1) + 6
+	shared := [2]*site.MapVector{s.vectors[s.primitiveIndex[2*cell].PrimitiveTop], s.vectors[s.primitiveIndex[2*cell].PrimitiveLeft]}
+	middle := fauxgl.Vector{
+		X: (shared[0].VertX + shared[1].VertX) / 2 / s.maxVert,
+		Y: (shared[0].VertY + shared[1].VertY) / 2 / s.maxVert,
+		Z: (shared[0].VertZ + shared[1].VertZ) / 2 / s.maxVert,
+	}
+	pickedX, pickedY, ok := s.pixel(middle)
+	return knownPick{pickedX, pickedY, 2 * cell, fauxgl.Vector{
+		X: (shared[0].Latitude + shared[1].Latitude) / 2,
+		Y: (shared[0].Elevation + shared[1].Elevation) / 2,
+		Z: (shared[0].Longtitude + shared[1].Longtitude) / 2,
+	}}, ok
+}
+
+//checkPicks fails where the picker misses a known pick or picks another primitive or GCS
+func checkPicks(t *testing.T, name string, s *pickingScene, pick func(pickedX, pickedY float64) (*pickedPoint, bool)) {
+	picks := s.knownPicks()
+	if len(picks) < len(s.triangles) {
+		t.Fatalf("%d of %d triangles on the image", len(picks)/2, len(s.triangles))
+	}
+	for _, want := range picks {
+		picked, ok := pick(want.pickedX, want.pickedY)
+		if !ok {
+			t.Errorf("%s: pixel %.2f, %.2f of primitive %d is missed", name, want.pickedX, want.pickedY, want.primitiveID)
+			continue
+		}
+		if picked.PrimitiveID != want.primitiveID || math.Abs(picked.GCS.X-want.gcs.X) > 1e-9 ||
+			math.Abs(picked.GCS.Y-want.gcs.Y) > 1e-6 || math.Abs(picked.GCS.Z-want.gcs.Z) > 1e-9 {
+			t.Errorf("%s: pixel %.2f, %.2f picks primitive %d at %v, want %d at %v", name, want.pickedX, want.pickedY,
+				picked.PrimitiveID, picked.GCS, want.primitiveID, want.gcs)
+		}
+	}
+
+	want, ok := s.sharedEdge()
+	if !ok {
+		t.Fatal("the shared edge is off the image")
+	}
+	picked, ok := pick(want.pickedX, want.pickedY)
+	if !ok {
+		t.Errorf("%s: the shared edge at %.3f, %.3f is missed", name, want.pickedX, want.pickedY)
+		return
+	}
+	if picked.PrimitiveID != want.primitiveID && picked.PrimitiveID != want.primitiveID+1 {
+		t.Errorf("%s: the shared edge picks primitive %d, want %d or %d", name, picked.PrimitiveID, want.primitiveID, want.primitiveID+1)
+	}
+	if math.Abs(picked.GCS.X-want.gcs.X) > 1e-9 || math.Abs(picked.GCS.Y-want.gcs.Y) > 1e-6 ||
+		math.Abs(picked.GCS.Z-want.gcs.Z) > 1e-9 {
+		t.Errorf("%s: the shared edge at %v, want %v", name, picked.GCS, want.gcs)
+	}
+}
+
+func TestRayPicking(t *testing.T) {
+	s := newPickingScene(t, overhead)
+	bvh := newTriangleBVH(s.triangles)
+	checkPicks(t, "ray cast", s, func(pickedX, pickedY float64) (*pickedPoint, bool) {
+		return rayPicking(pickedX, pickedY, bvh, s.cameraPerspective, s.render)
+	})
+
+	//looking up over the grid, and at the back of it from under it, nothing is picked; from under
+	//it the rays do meet the grid, only its back
+	for _, test := range []struct {
+		name     string
+		camera   site.CameraConfig
+		backHits bool
+	}{
+		{"sky", site.CameraConfig{Latitude: 43.4502, Longitude: -80.4895, Height: 10, RotationUD: 30}, false},
+		{"under the grid", site.CameraConfig{Latitude: 43.4502, Longitude: -80.48975, Height: -30, RotationUD: 80}, true},
+	} {
+		s := newPickingScene(t, test.camera)
+		bvh := newTriangleBVH(s.triangles)
+		backHits := 0
+		for y := 0; y < s.render.Height; y += 6 {
+			for x := 0; x < s.render.Width; x += 8 {
+				if picked, ok := rayPicking(float64(x), float64(y), bvh, s.cameraPerspective, s.render); ok {
+					t.Errorf("%s: pixel %d, %d picks primitive %d", test.name, x, y, picked.PrimitiveID)
+				}
+				origin, direction := site.PixelRay(s.cameraPerspective.Inverse(), float64(x), float64(y), s.render)
+				if _, _, _, ok := bvh.intersect(origin, direction, 0); ok {
+					backHits++
+				}
+			}
+		}
+		if (backHits > 0) != test.backHits {
+			t.Errorf("%s: %d rays meet the back of the grid", test.name, backHits)
+		}
+	}
+}
+
+func TestRasterPicking(t *testing.T) {
+	s := newPickingScene(t, overhead)
+	picks := s.knownPicks()
+	if len(picks) == 0 {
+		t.Fatal("no triangle on the image")
+	}
+	//the picking context is of the nomnom-ray fork of fauxgl, which the package imports; a fauxgl
+	//without its hooks picks nothing, and is a broken build rather than a test to skip
+	if _, ok := rasterPicking(picks[0].pickedX, picks[0].pickedY, s.triangles, s.primitiveOnScreen,
+		s.cameraPerspective, s.render); !ok {
+		t.Fatal("raster picking picks nothing; it needs the picking hooks of the nomnom-ray fork of fauxgl")
+	}
+	checkPicks(t, "raster", s, func(pickedX, pickedY float64) (*pickedPoint, bool) {
+		return rasterPicking(pickedX, pickedY, s.triangles, s.primitiveOnScreen, s.cameraPerspective, s.render)
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//cacheResolution rounds cached locations to about a centimetre
+const cacheResolution = 1e-7
+
+//cachedElevation is one row of the cache file
+type cachedElevation struct {
+	Provider                        string
+	Latitude, Longtitude, Elevation float64
+	FetchedAt                       int64 //unix seconds
+}
+
+type cacheKey struct {
+	provider string
+	lat, lng int
+}
+
+//elevationCache keeps every elevation ever fetched on disk, so neighbouring
+//samples and reruns never pay twice for the same point of the same provider
+type elevationCache struct {
+	path         string
+	mutex        sync.Mutex
+	entries      map[cacheKey]*cachedElevation
+	hits, misses int
+	dirty        bool
+}
+
+func newCacheKey(provider string, lat, lng float64) cacheKey {
+	return cacheKey{
+		provider: provider,
+		lat:      site.Round(lat / cacheResolution),
+		lng:      site.Round(lng / cacheResolution),
+	}
+}
+
+//loadElevationCache reads the cache file; a missing file is an empty cache
+func loadElevationCache(path string) (*elevationCache, error) {
+	cache := &elevationCache{
+		path:    path,
+		entries: map[cacheKey]*cachedElevation{},
+	}
+
+	clientsFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer clientsFile.Close()
+
+	var rows []*cachedElevation
+	if err := gocsv.UnmarshalFile(clientsFile, &rows); err != nil {
+		return nil, fmt.Errorf("elevation cache %s: %s", path, err)
+	}
+	for _, row := range rows {
+		cache.entries[newCacheKey(row.Provider, row.Latitude, row.Longtitude)] = row
+	}
+	return cache, nil
+}
+
+func (c *elevationCache) lookup(provider string, lat, lng float64) (float64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[newCacheKey(provider, lat, lng)]
+	if !ok {
+		c.misses++
+		return 0, false
+	}
+	c.hits++
+	return entry.Elevation, true
+}
+
+func (c *elevationCache) store(provider string, lat, lng, elevation float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := newCacheKey(provider, lat, lng)
+	if entry, ok := c.entries[key]; ok && entry.Elevation == elevation {
+		return
+	}
+	c.entries[key] = &cachedElevation{
+		Provider:   provider,
+		Latitude:   lat,
+		Longtitude: lng,
+		Elevation:  elevation,
+		FetchedAt:  time.Now().Unix(),
+	}
+	c.dirty = true
+}
+
+func (c *elevationCache) stats() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return fmt.Sprintf("elevation cache: %d hits, %d misses, %d cached", c.hits, c.misses, len(c.entries))
+}
+
+//sorted lists the entries in a stable order for writing
+func (c *elevationCache) sorted() []*cachedElevation {
+	rows := make([]*cachedElevation, 0, len(c.entries))
+	for _, entry := range c.entries {
+		rows = append(rows, entry)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Provider != rows[j].Provider {
+			return rows[i].Provider < rows[j].Provider
+		}
+		if rows[i].Latitude != rows[j].Latitude {
+			return rows[i].Latitude < rows[j].Latitude
+		}
+		return rows[i].Longtitude < rows[j].Longtitude
+	})
+	return rows
+}
+
+//save rewrites the cache file through a temporary file when anything changed
+func (c *elevationCache) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	rows := c.sorted()
+	clientsFile, err := os.Create(c.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = gocsv.MarshalFile(&rows, clientsFile)
+	clientsFile.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(c.path+".tmp", c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+//cacheCommand is "2DGCS cache stats|prune|export [flags]"
+func cacheCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("usage: 2DGCS cache stats|prune|export [flags]")
+		os.Exit(2)
+	}
+
+	commandFlags := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	cacheFile := commandFlags.String("cache", "elevationCache.csv", "elevation cache file")
+	provider := commandFlags.String("provider", "", "only entries of this provider")
+	older := commandFlags.Duration("older", 0, "prune: only entries fetched longer ago than this")
+	outside := commandFlags.String("outside", "",
+		"prune: only entries outside of this latStart,lngStart,latEnd,lngEnd box")
+	output := commandFlags.String("o", "", "export: output file; stdout when empty")
+	commandFlags.Parse(args[1:])
+
+	cache, err := loadElevationCache(*cacheFile)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+
+	switch args[0] {
+	case "stats":
+		counts := map[string]int{}
+		for _, entry := range cache.entries {
+			counts[entry.Provider]++
+		}
+		for providerName, count := range counts {
+			fmt.Printf("%s: %d\n", providerName, count)
+		}
+		fmt.Printf("total: %d\n", len(cache.entries))
+
+	case "prune":
+		if *provider == "" && *older == 0 && *outside == "" {
+			log.Fatal("nothing to prune; give -provider, -older or -outside")
+		}
+		var box []float64
+		if *outside != "" {
+			box, err = parseBox(*outside)
+			if err != nil {
+				log.Fatalf("fatal error: -outside: %s", err)
+			}
+		}
+		pruned := cache.prune(*provider, *older, box, time.Now())
+		if err := cache.save(); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+		fmt.Printf("pruned %d of %d entries\n", pruned, pruned+len(cache.entries))
+
+	case "export":
+		exportFile := os.Stdout
+		if *output != "" {
+			exportFile, err = os.Create(*output)
+			if err != nil {
+				log.Fatalf("fatal error: %s", err)
+			}
+			defer exportFile.Close()
+		}
+		if err := cache.export(exportFile, *provider); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+
+	default:
+		fmt.Println("usage: 2DGCS cache stats|prune|export [flags]")
+		os.Exit(2)
+	}
+}
+
+//prune deletes the entries of the provider, fetched longer than older before now and outside of the
+//box; an empty provider, a zero older or a nil box doesn't narrow it down. It tells how many went
+func (c *elevationCache) prune(provider string, older time.Duration, box []float64, now time.Time) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pruned := 0
+	for key, entry := range c.entries {
+		if provider != "" && entry.Provider != provider {
+			continue
+		}
+		if older != 0 && now.Sub(time.Unix(entry.FetchedAt, 0)) < older {
+			continue
+		}
+		if box != nil && inBox(entry.Latitude, entry.Longtitude, box) {
+			continue
+		}
+		delete(c.entries, key)
+		pruned++
+	}
+	if pruned > 0 {
+		c.dirty = true
+	}
+	return pruned
+}
+
+//export writes the entries of the provider, or all of them, as CSV in the order of the cache file
+func (c *elevationCache) export(exportFile io.Writer, provider string) error {
+	c.mutex.Lock()
+	var rows []*cachedElevation
+	for _, entry := range c.sorted() {
+		if provider == "" || entry.Provider == provider {
+			rows = append(rows, entry)
+		}
+	}
+	c.mutex.Unlock()
+	return gocsv.Marshal(&rows, exportFile)
+}
+
+//parseBox reads a latStart,lngStart,latEnd,lngEnd box
+func parseBox(text string) ([]float64, error) {
+	var box []float64
+	for _, field := range strings.Split(text, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		box = append(box, value)
+	}
+	if len(box) != 4 {
+		return nil, fmt.Errorf("%q is not latStart,lngStart,latEnd,lngEnd", text)
+	}
+	return box, nil
+}
+
+//inBox tells if a point is inside a latStart,lngStart,latEnd,lngEnd box in any corner order
+func inBox(lat, lng float64, box []float64) bool {
+	minLat, maxLat := box[0], box[2]
+	if minLat > maxLat {
+		minLat, maxLat = maxLat, minLat
+	}
+	minLng, maxLng := box[1], box[3]
+	if minLng > maxLng {
+		minLng, maxLng = maxLng, minLng
+	}
+	return lat >= minLat && lat <= maxLat && lng >= minLng && lng <= maxLng
+}
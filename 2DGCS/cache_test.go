@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name               string
+		provider           string
+		lat, lng           float64
+		otherProvider      string
+		otherLat, otherLng float64
+		same               bool
+	}{
+		{"same point", "google", 43.4512345, -80.4912345, "google", 43.4512345, -80.4912345, true},
+		{"within a rounding", "google", 43.45123451, -80.49123449, "google", 43.45123449, -80.49123451, true},
+		{"a centimetre apart", "google", 43.4512345, -80.4912345, "google", 43.4512346, -80.4912345, false},
+		{"either side of the equator", "google", 0.00000004, 10, "google", -0.00000004, 10, true},
+		{"another provider", "google", 43.4512345, -80.4912345, "dem:N43W081.hgt", 43.4512345, -80.4912345, false},
+	}
+	for _, test := range tests {
+		same := newCacheKey(test.provider, test.lat, test.lng) == newCacheKey(test.otherProvider, test.otherLat, test.otherLng)
+		if same != test.same {
+			t.Errorf("%s: same key %v, want %v", test.name, same, test.same)
+		}
+	}
+}
+
+//testCache is a cache at path of an entry of each provider in and out of a box, fetched a day and
+//an hour before now
+func testCache(path string, now time.Time) *elevationCache {
+	cache := &elevationCache{path: path, entries: map[cacheKey]*cachedElevation{}}
+	for _, entry := range []*cachedElevation{
+		{"google", 43.4513, -80.4913, 330, now.Add(-24 * time.Hour).Unix()},
+		{"google", 44.5, -80.4913, 331, now.Add(-time.Hour).Unix()},
+		{"dem:N43W081.hgt", 43.4513, -80.4913, 332, now.Add(-24 * time.Hour).Unix()},
+		{"dem:N43W081.hgt", 43.4513, -79.5, 333, now.Add(-time.Hour).Unix()},
+	} {
+		cache.entries[newCacheKey(entry.Provider, entry.Latitude, entry.Longtitude)] = entry
+	}
+	return cache
+}
+
+func TestElevationCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "elevationCache.csv")
+
+	cache, err := loadElevationCache(path)
+	if err != nil || len(cache.entries) != 0 {
+		t.Fatalf("missing cache: %d entries, error %v", len(cache.entries), err)
+	}
+	if _, ok := cache.lookup("google", 43.45, -80.49); ok {
+		t.Error("empty cache hit")
+	}
+	cache.store("google", 43.45, -80.49, 330)
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+
+	//storing what is there already changes nothing
+	cache.store("google", 43.45, -80.49, 330)
+	if cache.dirty {
+		t.Error("an elevation stored again makes the cache dirty")
+	}
+	cache.store("google", 43.45, -80.49, 331)
+	if !cache.dirty {
+		t.Error("a new elevation leaves the cache clean")
+	}
+
+	loaded, err := loadElevationCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elevation, ok := loaded.lookup("google", 43.45000001, -80.49); !ok || elevation != 330 {
+		t.Errorf("saved elevation %v %v, want 330", elevation, ok)
+	}
+	if _, ok := loaded.lookup("dem:N43W081.hgt", 43.45, -80.49); ok {
+		t.Error("another provider hit")
+	}
+	if stats := loaded.stats(); stats != "elevation cache: 1 hits, 1 misses, 1 cached" {
+		t.Errorf("stats %q", stats)
+	}
+}
+
+func TestPruneElevationCache(t *testing.T) {
+	now := time.Now()
+	box := []float64{43.5, -80.5, 43.4, -80.4}
+	tests := []struct {
+		name     string
+		provider string
+		older    time.Duration
+		box      []float64
+		kept     []float64 //elevations left
+	}{
+		{"a provider", "google", 0, nil, []float64{332, 333}},
+		{"older than half a day", "", 12 * time.Hour, nil, []float64{331, 333}},
+		{"outside of the box", "", 0, box, []float64{330, 332}},
+		{"a provider outside of the box", "dem:N43W081.hgt", 0, box, []float64{330, 331, 332}},
+		{"all narrowed down", "google", 12 * time.Hour, box, []float64{330, 331, 332, 333}},
+		{"another provider", "srtm", 0, nil, []float64{330, 331, 332, 333}},
+	}
+	for _, test := range tests {
+		cache := testCache("", now)
+		pruned := cache.prune(test.provider, test.older, test.box, now)
+		if pruned != 4-len(test.kept) || cache.dirty != (pruned > 0) {
+			t.Errorf("%s: %d pruned, dirty %v, want %d", test.name, pruned, cache.dirty, 4-len(test.kept))
+		}
+		for _, elevation := range test.kept {
+			found := false
+			for _, entry := range cache.entries {
+				found = found || entry.Elevation == elevation
+			}
+			if !found {
+				t.Errorf("%s: %v pruned", test.name, elevation)
+			}
+		}
+	}
+}
+
+func TestExportElevationCache(t *testing.T) {
+	cache := testCache("", time.Now())
+	for _, test := range []struct {
+		provider string
+		rows     int
+	}{{"", 4}, {"google", 2}, {"srtm", 0}} {
+		var exported bytes.Buffer
+		if err := cache.export(&exported, test.provider); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+		if len(lines)-1 != test.rows {
+			t.Errorf("%q: %d rows exported, want %d", test.provider, len(lines)-1, test.rows)
+			continue
+		}
+		//by provider, then latitude and longitude
+		for _, line := range lines[1:] {
+			if test.provider != "" && !strings.HasPrefix(line, test.provider+",") {
+				t.Errorf("%q: exported %q", test.provider, line)
+			}
+		}
+		if test.provider == "" && (!strings.HasPrefix(lines[1], "dem:N43W081.hgt,43.4513,-80.4913,") ||
+			!strings.HasPrefix(lines[4], "google,44.5,")) {
+			t.Errorf("exported out of order: %q", lines[1:])
+		}
+	}
+}
+
+func TestParseBox(t *testing.T) {
+	if box, err := parseBox("43.45, -80.49,43.46,-80.48"); err != nil || len(box) != 4 || box[1] != -80.49 {
+		t.Errorf("box %v, error %v", box, err)
+	}
+	for _, text := range []string{"", "43.45,-80.49,43.46", "43.45,-80.49,43.46,-80.48,1", "a,b,c,d"} {
+		if _, err := parseBox(text); err == nil {
+			t.Errorf("%q: no error", text)
+		}
+	}
+	//any corner order
+	for _, box := range [][]float64{{43.4, -80.5, 43.5, -80.4}, {43.5, -80.4, 43.4, -80.5}} {
+		if !inBox(43.45, -80.45, box) || inBox(43.55, -80.45, box) || inBox(43.45, -80.35, box) {
+			t.Errorf("box %v", box)
+		}
+	}
+}
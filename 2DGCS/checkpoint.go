@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gocarina/gocsv"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//checkpointVector is a vertex already fetched, with its place in the sample plan
+type checkpointVector struct {
+	Index                           int
+	Latitude, Longtitude, Elevation float64
+}
+
+//fetchCheckpoint periodically saves the fetched vertices, so a rerun of the same
+//area and resolution only pays for the vertices that are still missing
+type fetchCheckpoint struct {
+	path     string
+	interval time.Duration
+	vectors  []*site.MapVector
+	fetched  []bool
+	lastSave time.Time
+	mutex    sync.Mutex
+}
+
+//checkpointPath names the checkpoint after the provider, area and resolution it was sampled with;
+//like the cache, elevations of one provider are never resumed from another
+func checkpointPath(provider string, area site.AreaConfig) string {
+	//the provider may name files, which are no part of a file name
+	provider = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, provider)
+	return fmt.Sprintf("resultCheckpoint_%s_%.7f_%.7f_%.7f_%.7f_%g_%g.csv", provider,
+		area.LatStart, area.LngStart, area.LatEnd, area.LngEnd, area.ResolutionLat, area.ResolutionLng)
+}
+
+//loadFetchCheckpoint restores the elevations of an earlier run into the planned vectors;
+//rows that don't match the plan are ignored
+func loadFetchCheckpoint(path string, interval time.Duration, vectors []*site.MapVector) (*fetchCheckpoint, int, error) {
+	checkpoint := &fetchCheckpoint{
+		path:     path,
+		interval: interval,
+		vectors:  vectors,
+		fetched:  make([]bool, len(vectors)),
+		lastSave: time.Now(),
+	}
+
+	clientsFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return checkpoint, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer clientsFile.Close()
+
+	var saved []*checkpointVector
+	if err := gocsv.UnmarshalFile(clientsFile, &saved); err != nil {
+		return nil, 0, fmt.Errorf("checkpoint %s: %s", path, err)
+	}
+
+	restored := 0
+	for _, row := range saved {
+		if row.Index < 0 || row.Index >= len(vectors) || checkpoint.fetched[row.Index] {
+			continue
+		}
+		vector := vectors[row.Index]
+		if math.Abs(vector.Latitude-row.Latitude) > 1e-9 || math.Abs(vector.Longtitude-row.Longtitude) > 1e-9 {
+			continue
+		}
+		vector.Elevation = row.Elevation
+		checkpoint.fetched[row.Index] = true
+		restored++
+	}
+	return checkpoint, restored, nil
+}
+
+//markFetched records a fetched batch and saves the checkpoint once the interval is up
+func (c *fetchCheckpoint) markFetched(indices []int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, i := range indices {
+		c.fetched[i] = true
+	}
+	if c.interval <= 0 || time.Since(c.lastSave) < c.interval {
+		return nil
+	}
+	return c.saveLocked()
+}
+
+func (c *fetchCheckpoint) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.saveLocked()
+}
+
+//saveLocked writes to a temporary file first, so a crash never leaves half a checkpoint
+func (c *fetchCheckpoint) saveLocked() error {
+	var saved []*checkpointVector
+	for i, vector := range c.vectors {
+		if c.fetched[i] {
+			saved = append(saved, &checkpointVector{
+				Index:      i,
+				Latitude:   vector.Latitude,
+				Longtitude: vector.Longtitude,
+				Elevation:  vector.Elevation,
+			})
+		}
+	}
+	c.lastSave = time.Now()
+	if len(saved) == 0 {
+		return nil
+	}
+
+	clientsFile, err := os.Create(c.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	err = gocsv.MarshalFile(&saved, clientsFile)
+	clientsFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(c.path+".tmp", c.path)
+}
+
+//remove deletes the checkpoint once the vertices are written out for good
+func (c *fetchCheckpoint) remove() {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		fmt.Println("checkpoint not removed:", err)
+	}
+}
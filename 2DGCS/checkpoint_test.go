@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestCheckpointPath(t *testing.T) {
+	area := site.DefaultSceneConfig().Area
+	finer := area
+	finer.ResolutionLat /= 2
+	moved := area
+	moved.LatStart += 0.0001
+	path := checkpointPath("google", area)
+	if path != checkpointPath("google", site.DefaultSceneConfig().Area) {
+		t.Error("the same area is checkpointed under two names")
+	}
+	if path == checkpointPath("google", finer) || path == checkpointPath("google", moved) {
+		t.Error("another sample plan resumes from the checkpoint of the area")
+	}
+	if path == checkpointPath("dem:N43W081.hgt", area) {
+		t.Error("another provider resumes from the checkpoint of the area")
+	}
+	if dem := checkpointPath("dem:N43W081.hgt+N43W080.hgt", area); filepath.Base(dem) != dem || strings.ContainsAny(dem, ":+") {
+		t.Errorf("the checkpoint of a DEM is %s", dem)
+	}
+}
+
+func TestFetchCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.csv")
+
+	//nothing to resume from
+	vectors := fakeVectors(6)
+	checkpoint, restored, err := loadFetchCheckpoint(path, 0, vectors)
+	if err != nil || restored != 0 {
+		t.Fatalf("no checkpoint: %d restored, error %v", restored, err)
+	}
+	if err := checkpoint.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("a checkpoint of nothing fetched is written")
+	}
+
+	//without an interval only save writes; nothing is left of the temporary file
+	for _, i := range []int{1, 2, 4} {
+		vectors[i].Elevation = float64(100 + i)
+	}
+	if err := checkpoint.markFetched([]int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("saved before the interval")
+	}
+	checkpoint.markFetched([]int{4})
+	if err := checkpoint.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+
+	//a rerun of the same plan gets the fetched vertices back
+	resumed := fakeVectors(6)
+	checkpoint, restored, err = loadFetchCheckpoint(path, 0, resumed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 3 {
+		t.Errorf("%d restored, want 3", restored)
+	}
+	for i, vector := range resumed {
+		fetched := i == 1 || i == 2 || i == 4
+		if checkpoint.fetched[i] != fetched || fetched && vector.Elevation != float64(100+i) {
+			t.Errorf("vector %d fetched %v at %v", i, checkpoint.fetched[i], vector.Elevation)
+		}
+	}
+
+	//rows of another plan are not restored
+	other := fakeVectors(3)
+	other[1].Latitude += 0.00001
+	_, restored, err = loadFetchCheckpoint(path, 0, other)
+	if err != nil || restored != 1 {
+		t.Errorf("other plan: %d restored, want 1; error %v", restored, err)
+	}
+
+	//with an interval every batch past it saves
+	checkpoint, _, _ = loadFetchCheckpoint(path, 1, fakeVectors(6))
+	checkpoint.markFetched([]int{0, 5})
+	resumed = fakeVectors(6)
+	if _, restored, _ = loadFetchCheckpoint(path, 0, resumed); restored != 5 {
+		t.Errorf("%d restored after the interval, want 5", restored)
+	}
+
+	checkpoint.remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("checkpoint not removed")
+	}
+	if err := ioutil.WriteFile(path, []byte("not,a\ncheckpoint"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadFetchCheckpoint(path, 0, fakeVectors(6)); err == nil {
+		t.Error("a broken checkpoint loaded")
+	}
+}
+
+func TestGetMapVector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	working, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(working)
+
+	//the CSVs of a larger model are there from before
+	long := "header\n" + strings.Repeat("1,2,3\n", 1000)
+	for _, name := range []string{"resultVectorModel.csv", "resultPrimativeModel.csv"} {
+		if err := ioutil.WriteFile(name, []byte(long), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	area := site.AreaConfig{
+		LatStart: 43.45, LngStart: -80.49, LatEnd: 43.4502, LngEnd: -80.4897,
+		ResolutionLat: 0.0001, ResolutionLng: 0.0001,
+	}
+	//another provider fetched the same plan before; its elevations are not resumed from
+	planned, _, _ := planGrid(area)
+	other, _, err := loadFetchCheckpoint(checkpointPath("google", area), 0, planned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []int
+	for i, vector := range planned {
+		vector.Elevation = -1
+		all = append(all, i)
+	}
+	other.markFetched(all)
+	if err := other.save(); err != nil {
+		t.Fatal(err)
+	}
+	compositeVector, primitiveIndex := getMapVector(area, &fakeElevation{}, fetchOptions{batchSize: 4, workers: 2}, nil)
+	if len(compositeVector) != 12 || len(primitiveIndex) != 12 {
+		t.Fatalf("%d vectors and %d primitives, want 12 and 12", len(compositeVector), len(primitiveIndex))
+	}
+
+	savedVector, savedIndex, err := site.LoadVectorModel("resultVectorModel.csv", "resultPrimativeModel.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(savedVector) != len(compositeVector) || len(savedIndex) != len(primitiveIndex) {
+		t.Errorf("%d vectors and %d primitives written, want %d and %d",
+			len(savedVector), len(savedIndex), len(compositeVector), len(primitiveIndex))
+	}
+	for i, vector := range savedVector {
+		if want := fakeGround(vector.Latitude, vector.Longtitude); vector.Elevation != want {
+			t.Errorf("vector %d written at %v, want %v", i, vector.Elevation, want)
+		}
+	}
+	for _, name := range []string{"resultVectorModel.csv", "resultPrimativeModel.csv"} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(string(data), "\n"); lines != 13 {
+			t.Errorf("%s: %d lines, want a header and 12 rows", name, lines)
+		}
+	}
+	if _, err := os.Stat(checkpointPath("fake", area)); !os.IsNotExist(err) {
+		t.Error("checkpoint of a finished download left behind")
+	}
+}
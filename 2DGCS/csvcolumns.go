@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//csvColumn is a column of a points CSV by the names its header may give it, in any case
+type csvColumn struct {
+	name     string //names the column in errors
+	aliases  []string
+	optional bool
+}
+
+var (
+	nameColumn      = csvColumn{name: "name", aliases: []string{"name", "id"}, optional: true}
+	latColumn       = csvColumn{name: "latitude", aliases: []string{"lat", "latitude"}}
+	lngColumn       = csvColumn{name: "longitude", aliases: []string{"lng", "lon", "long", "longitude", "longtitude"}}
+	elevationColumn = csvColumn{name: "elevation", aliases: []string{"elevation", "elev", "alt", "altitude"}}
+	pixelXColumn    = csvColumn{name: "pixelX", aliases: []string{"pixelx", "x", "u"}}
+	pixelYColumn    = csvColumn{name: "pixelY", aliases: []string{"pixely", "y", "v"}}
+)
+
+//readCSVColumns reads a CSV by the columns its header names, in any order; row is called with the
+//line and the field of every column of every row, empty for an optional column the header leaves out
+func readCSVColumns(path string, columns []csvColumn, row func(line int, fields []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	found := make([]int, len(columns))
+	var missing []string
+	for k, column := range columns {
+		found[k] = -1
+		for i, name := range header {
+			name = strings.ToLower(strings.TrimSpace(name))
+			for _, alias := range column.aliases {
+				if name == alias {
+					found[k] = i
+				}
+			}
+		}
+		if found[k] < 0 && !column.optional {
+			missing = append(missing, column.name)
+		}
+	}
+	if len(missing) > 0 {
+		last := len(missing) - 1
+		names := missing[last]
+		if last > 0 {
+			names = strings.Join(missing[:last], ", ") + " and " + names
+		}
+		return fmt.Errorf("%s: needs %s columns", path, names)
+	}
+
+	fields := make([]string, len(columns))
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		for k, i := range found {
+			fields[k] = ""
+			if i >= 0 {
+				fields[k] = strings.TrimSpace(record[i])
+			}
+		}
+		if err := row(line, fields); err != nil {
+			return fmt.Errorf("%s line %d: %s", path, line, err)
+		}
+	}
+}
+
+//parseFloats parses the fields of a row of numbers
+func parseFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		var err error
+		values[i], err = strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//the three readers of points take their columns the same way: by any alias, in any case and order
+func TestReadCSVColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "columns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	want := []site.MapVector{
+		{Latitude: 43.45, Longtitude: -80.49, Elevation: 330.5},
+		{Latitude: 43.451, Longtitude: -80.491, Elevation: 331},
+	}
+	check := func(name string, points []*site.MapVector) {
+		if len(points) != len(want) {
+			t.Fatalf("%s: %d points", name, len(points))
+		}
+		for i, point := range points {
+			if *point != want[i] {
+				t.Errorf("%s: point %d is %+v, want %+v", name, i, *point, want[i])
+			}
+		}
+	}
+
+	names, points, err := readPoints(write("named.csv", "Elev, ID ,Longitude,LAT\n330.5,gate,-80.49,43.45\n331,,-80.491,43.451\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("named", points)
+	if strings.Join(names, ",") != "gate,3" {
+		t.Errorf("named: points are named %v", names)
+	}
+	names, points, err = readPoints(write("unnamed.csv", "lon,lat,altitude\n-80.49,43.45,330.5\n-80.491,43.451,331\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("unnamed", points)
+	if strings.Join(names, ",") != "2,3" {
+		t.Errorf("unnamed: points are named %v", names)
+	}
+
+	points, err = readScatteredPoints(write("scattered.csv", "Z,lng,latitude\n330.5,-80.49,43.45\n331,-80.491,43.451\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("scattered", points)
+
+	controlPoints, err := readControlPoints(write("control.csv", "lat,lng,elevation,u,PixelY\n43.45,-80.49,330.5,12,34.5\n43.451,-80.491,331,600,470\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	points = nil
+	for _, point := range controlPoints {
+		points = append(points, point.vector)
+	}
+	check("control", points)
+	if controlPoints[0].pixelX != 12 || controlPoints[0].pixelY != 34.5 || controlPoints[1].pixelX != 600 || controlPoints[1].pixelY != 470 {
+		t.Errorf("control: pixels %v, %v and %v, %v", controlPoints[0].pixelX, controlPoints[0].pixelY,
+			controlPoints[1].pixelX, controlPoints[1].pixelY)
+	}
+
+	for _, test := range []struct {
+		name, data string
+		read       func(path string) error
+		err        string
+	}{
+		{"no elevation", "name,lat,lng\ngate,43.45,-80.49\n", func(path string) error {
+			_, _, err := readPoints(path)
+			return err
+		}, "needs elevation columns"},
+		{"no pixels", "lat,lng,elevation\n43.45,-80.49,330\n", func(path string) error {
+			_, err := readControlPoints(path)
+			return err
+		}, "needs pixelX and pixelY columns"},
+		{"z is no control elevation", "x,y,lat,lng,z\n1,2,43.45,-80.49,330\n", func(path string) error {
+			_, err := readControlPoints(path)
+			return err
+		}, "needs elevation columns"},
+		{"not a number", "lat,lng,elevation\n43.45,-80.49,330\n43.451,west,331\n", func(path string) error {
+			_, err := readScatteredPoints(path)
+			return err
+		}, "line 3: "},
+	} {
+		err := test.read(write(strings.Replace(test.name, " ", "-", -1)+".csv", test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: reads with error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+type delaunayPoint struct {
+	x, y float64
+}
+
+type edgeKey [2]int
+
+func newEdgeKey(a, b int) edgeKey {
+	if a > b {
+		a, b = b, a
+	}
+	return edgeKey{a, b}
+}
+
+//delaunayMesh is a counter-clockwise triangulation with its edge adjacency;
+//removed triangles stay in the slice as {-1, -1, -1}
+type delaunayMesh struct {
+	points      []delaunayPoint
+	triangles   [][3]int
+	edges       map[edgeKey][]int
+	constrained map[edgeKey]bool
+}
+
+//triangulateScattered meshes points that are not on a grid with a Delaunay triangulation
+//on the east and north of an ENU frame at their middle. Constraint edges (pairs of point
+//indices) are forced into the mesh; with boundary set they are taken as a closed outline
+//and the triangles outside of it are dropped. Coincident points are merged, so the
+//vertices are returned as well
+func triangulateScattered(points []*site.MapVector, constraints [][2]int, boundary bool) ([]*site.MapVector, []*site.MapPrimitiveIndex, error) {
+	if len(points) < 3 {
+		return nil, nil, fmt.Errorf("delaunay: %d points are not enough for a triangle", len(points))
+	}
+	if boundary {
+		if err := closedOutline(constraints); err != nil {
+			return nil, nil, fmt.Errorf("delaunay: %s", err)
+		}
+	}
+
+	//the east-north plane of the frame at the middle of the points
+	var lat0, lng0 float64
+	for _, point := range points {
+		lat0 += point.Latitude
+		lng0 += point.Longtitude
+	}
+	lat0 /= float64(len(points))
+	lng0 /= float64(len(points))
+	frame := site.NewENUFrame(lat0, lng0, 0)
+
+	//merge coincident points, remembering where every input point went
+	var vectors []*site.MapVector
+	var plane []delaunayPoint
+	merged := make([]int, len(points))
+	seen := map[[2]int64]int{}
+	for i, point := range points {
+		east, north, _ := frame.ToENU(point.Latitude, point.Longtitude, 0)
+		p := delaunayPoint{x: east, y: north}
+		key := [2]int64{int64(math.Floor(p.x*1000 + 0.5)), int64(math.Floor(p.y*1000 + 0.5))}
+		if j, ok := seen[key]; ok {
+			merged[i] = j
+			continue
+		}
+		seen[key] = len(vectors)
+		merged[i] = len(vectors)
+		vectors = append(vectors, point)
+		plane = append(plane, p)
+	}
+	if len(vectors) < 3 {
+		return nil, nil, fmt.Errorf("delaunay: fewer than 3 distinct points")
+	}
+
+	mesh := newDelaunayMesh(plane)
+	for _, constraint := range constraints {
+		if constraint[0] < 0 || constraint[1] < 0 || constraint[0] >= len(points) || constraint[1] >= len(points) {
+			return nil, nil, fmt.Errorf("delaunay: constraint %d-%d is not between points", constraint[0], constraint[1])
+		}
+		if err := mesh.insertConstraint(merged[constraint[0]], merged[constraint[1]]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if boundary {
+		mesh.removeOutside()
+	}
+
+	var primitiveIndex []*site.MapPrimitiveIndex
+	for _, t := range mesh.triangles {
+		if t[0] < 0 {
+			continue
+		}
+		primitiveIndex = append(primitiveIndex, &site.MapPrimitiveIndex{
+			PrimitiveBottom: t[0],
+			PrimitiveTop:    t[1],
+			PrimitiveLeft:   t[2],
+		})
+	}
+	if len(primitiveIndex) == 0 {
+		return nil, nil, fmt.Errorf("delaunay: no triangles left; are the points collinear?")
+	}
+	return vectors, primitiveIndex, nil
+}
+
+//closedOutline tells if constraint edges can be a boundary: there are some, and every point on
+//them has an even number of edges, so the outline has no loose end for the outside to leak in
+func closedOutline(constraints [][2]int) error {
+	if len(constraints) == 0 {
+		return fmt.Errorf("the boundary has no edges")
+	}
+	degree := make(map[int]int)
+	for _, constraint := range constraints {
+		degree[constraint[0]]++
+		degree[constraint[1]]++
+	}
+	open := -1
+	for point, edges := range degree {
+		if edges%2 != 0 && (open < 0 || point < open) {
+			open = point
+		}
+	}
+	if open >= 0 {
+		return fmt.Errorf("the boundary is open at point %d", open)
+	}
+	return nil
+}
+
+func orient(a, b, c delaunayPoint) float64 {
+	return (b.x-a.x)*(c.y-a.y) - (b.y-a.y)*(c.x-a.x)
+}
+
+//inCircle is positive when d is inside the circle through the counter-clockwise a, b, c
+func inCircle(a, b, c, d delaunayPoint) float64 {
+	adx, ady := a.x-d.x, a.y-d.y
+	bdx, bdy := b.x-d.x, b.y-d.y
+	cdx, cdy := c.x-d.x, c.y-d.y
+	return (adx*adx+ady*ady)*(bdx*cdy-cdx*bdy) -
+		(bdx*bdx+bdy*bdy)*(adx*cdy-cdx*ady) +
+		(cdx*cdx+cdy*cdy)*(adx*bdy-bdx*ady)
+}
+
+//segmentsCross is true when ab and cd cross at a point inside both
+func segmentsCross(a, b, c, d delaunayPoint) bool {
+	return orient(a, b, c)*orient(a, b, d) < 0 && orient(c, d, a)*orient(c, d, b) < 0
+}
+
+//newDelaunayMesh runs Bowyer-Watson over the points sorted by x; triangles whose
+//circumcircle is left behind by the sweep are final and no longer tested
+func newDelaunayMesh(points []delaunayPoint) *delaunayMesh {
+	type sweepTriangle struct {
+		v          [3]int
+		cx, cy, r2 float64
+	}
+
+	n := len(points)
+	minX, minY, maxX, maxY := points[0].x, points[0].y, points[0].x, points[0].y
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	span := math.Max(math.Max(maxX-minX, maxY-minY), 1)
+	midX, midY := (minX+maxX)/2, (minY+maxY)/2
+
+	//super triangle around everything; its corners are dropped at the end
+	all := append(append([]delaunayPoint{}, points...),
+		delaunayPoint{midX - 20*span, midY - span},
+		delaunayPoint{midX + 20*span, midY - span},
+		delaunayPoint{midX, midY + 20*span})
+
+	circumcircle := func(v [3]int) sweepTriangle {
+		a, b, c := all[v[0]], all[v[1]], all[v[2]]
+		d := 2 * (a.x*(b.y-c.y) + b.x*(c.y-a.y) + c.x*(a.y-b.y))
+		if d == 0 {
+			return sweepTriangle{v: v, r2: math.Inf(1)}
+		}
+		a2, b2, c2 := a.x*a.x+a.y*a.y, b.x*b.x+b.y*b.y, c.x*c.x+c.y*c.y
+		cx := (a2*(b.y-c.y) + b2*(c.y-a.y) + c2*(a.y-b.y)) / d
+		cy := (a2*(c.x-b.x) + b2*(a.x-c.x) + c2*(b.x-a.x)) / d
+		return sweepTriangle{v: v, cx: cx, cy: cy, r2: (a.x-cx)*(a.x-cx) + (a.y-cy)*(a.y-cy)}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return points[order[i]].x < points[order[j]].x })
+
+	open := []sweepTriangle{circumcircle([3]int{n, n + 1, n + 2})}
+	var done []sweepTriangle
+	for _, i := range order {
+		p := all[i]
+		edgeCount := map[edgeKey]int{}
+		var cavity [][2]int
+		kept := open[:0]
+		for _, t := range open {
+			dx, dy := p.x-t.cx, p.y-t.cy
+			if dx > 0 && dx*dx > t.r2 {
+				done = append(done, t)
+				continue
+			}
+			if dx*dx+dy*dy <= t.r2*(1+1e-12) {
+				for k := 0; k < 3; k++ {
+					edge := [2]int{t.v[k], t.v[(k+1)%3]}
+					edgeCount[newEdgeKey(edge[0], edge[1])]++
+					cavity = append(cavity, edge)
+				}
+				continue
+			}
+			kept = append(kept, t)
+		}
+		open = kept
+		for _, edge := range cavity {
+			if edgeCount[newEdgeKey(edge[0], edge[1])] == 1 {
+				open = append(open, circumcircle([3]int{edge[0], edge[1], i}))
+			}
+		}
+	}
+	done = append(done, open...)
+
+	mesh := &delaunayMesh{
+		points:      points,
+		edges:       map[edgeKey][]int{},
+		constrained: map[edgeKey]bool{},
+	}
+	for _, t := range done {
+		if t.v[0] >= n || t.v[1] >= n || t.v[2] >= n {
+			continue
+		}
+		mesh.addTriangle(t.v)
+	}
+	return mesh
+}
+
+//addTriangle stores the triangle counter-clockwise and registers its edges
+func (m *delaunayMesh) addTriangle(v [3]int) int {
+	area := orient(m.points[v[0]], m.points[v[1]], m.points[v[2]])
+	if area == 0 {
+		return -1
+	}
+	if area < 0 {
+		v[1], v[2] = v[2], v[1]
+	}
+	id := len(m.triangles)
+	m.triangles = append(m.triangles, v)
+	for k := 0; k < 3; k++ {
+		key := newEdgeKey(v[k], v[(k+1)%3])
+		m.edges[key] = append(m.edges[key], id)
+	}
+	return id
+}
+
+func (m *delaunayMesh) removeTriangle(id int) {
+	v := m.triangles[id]
+	for k := 0; k < 3; k++ {
+		key := newEdgeKey(v[k], v[(k+1)%3])
+		owners := m.edges[key][:0]
+		for _, owner := range m.edges[key] {
+			if owner != id {
+				owners = append(owners, owner)
+			}
+		}
+		if len(owners) == 0 {
+			delete(m.edges, key)
+		} else {
+			m.edges[key] = owners
+		}
+	}
+	m.triangles[id] = [3]int{-1, -1, -1}
+}
+
+func opposite(t [3]int, edge edgeKey) int {
+	for _, v := range t {
+		if v != edge[0] && v != edge[1] {
+			return v
+		}
+	}
+	return -1
+}
+
+//insertConstraint forces the edge ab into the mesh: the triangles it crosses are removed
+//and the cavity on either side of ab is filled again as a constrained Delaunay polygon
+func (m *delaunayMesh) insertConstraint(a, b int) error {
+	if a == b {
+		return nil
+	}
+	//a constraint running through a vertex is two constraints
+	pa, pb := m.points[a], m.points[b]
+	length2 := (pb.x-pa.x)*(pb.x-pa.x) + (pb.y-pa.y)*(pb.y-pa.y)
+	for c, pc := range m.points {
+		if c == a || c == b || math.Abs(orient(pa, pb, pc)) > 1e-9*length2 {
+			continue
+		}
+		along := (pc.x-pa.x)*(pb.x-pa.x) + (pc.y-pa.y)*(pb.y-pa.y)
+		if along > 0 && along < length2 {
+			if err := m.insertConstraint(a, c); err != nil {
+				return err
+			}
+			return m.insertConstraint(c, b)
+		}
+	}
+
+	key := newEdgeKey(a, b)
+	if _, ok := m.edges[key]; ok {
+		m.constrained[key] = true
+		return nil
+	}
+
+	//the triangle at a whose far edge ab goes through
+	current, crossed := -1, edgeKey{}
+	for id, t := range m.triangles {
+		for k := 0; k < 3 && current < 0; k++ {
+			edge := newEdgeKey(t[(k+1)%3], t[(k+2)%3])
+			if t[k] == a && segmentsCross(pa, pb, m.points[edge[0]], m.points[edge[1]]) {
+				current, crossed = id, edge
+			}
+		}
+	}
+	if current < 0 {
+		return fmt.Errorf("delaunay: cannot insert constraint %d-%d", a, b)
+	}
+
+	//walk to b through the crossed edges, collecting the vertices left and right of ab in order
+	var left, right []int
+	side := func(v int) {
+		if orient(pa, pb, m.points[v]) > 0 {
+			left = append(left, v)
+		} else {
+			right = append(right, v)
+		}
+	}
+	side(crossed[0])
+	side(crossed[1])
+	cavity := []int{current}
+	for {
+		if m.constrained[crossed] {
+			return fmt.Errorf("delaunay: constraints %d-%d and %d-%d cross", a, b, crossed[0], crossed[1])
+		}
+		next := -1
+		for _, owner := range m.edges[crossed] {
+			if owner != current {
+				next = owner
+			}
+		}
+		if next < 0 {
+			return fmt.Errorf("delaunay: cannot insert constraint %d-%d", a, b)
+		}
+		cavity = append(cavity, next)
+		v := opposite(m.triangles[next], crossed)
+		if v == b {
+			break
+		}
+		side(v)
+		if segmentsCross(pa, pb, m.points[v], m.points[crossed[0]]) {
+			crossed = newEdgeKey(v, crossed[0])
+		} else {
+			crossed = newEdgeKey(v, crossed[1])
+		}
+		current = next
+	}
+
+	for _, id := range cavity {
+		m.removeTriangle(id)
+	}
+	m.fillPolygon(a, b, left)
+	m.fillPolygon(a, b, right)
+	m.constrained[key] = true
+	return nil
+}
+
+//fillPolygon triangulates the polygon of the edge ab and the chain of vertices from a to b
+//on one side of it; the chain vertex with no other inside its circle through a and b goes
+//with ab, and both parts of the chain left of it and right of it are filled the same way
+func (m *delaunayMesh) fillPolygon(a, b int, chain []int) {
+	if len(chain) == 0 {
+		return
+	}
+	pa, pb := m.points[a], m.points[b]
+	c := 0
+	for i := 1; i < len(chain); i++ {
+		pc := m.points[chain[c]]
+		if inCircle(pa, pb, pc, m.points[chain[i]])*orient(pa, pb, pc) > 0 {
+			c = i
+		}
+	}
+	m.fillPolygon(a, chain[c], chain[:c])
+	m.fillPolygon(chain[c], b, chain[c+1:])
+	m.addTriangle([3]int{a, b, chain[c]})
+}
+
+//removeOutside drops the triangles outside of the outline. Every constrained edge crossed on
+//the way in from the hull goes in or out of it, so the triangles an even number of crossings
+//away are outside; holes in the outline are dropped as well
+func (m *delaunayMesh) removeOutside() {
+	//levels[d] are the triangles first reached d crossings away; the hull is outside, but
+	//a triangle on a constrained edge of the hull is in at once
+	levels := make([][]int, 2)
+	for edge, owners := range m.edges {
+		if len(owners) != 1 {
+			continue
+		}
+		if m.constrained[edge] {
+			levels[1] = append(levels[1], owners[0])
+		} else {
+			levels[0] = append(levels[0], owners[0])
+		}
+	}
+	crossings := map[int]int{}
+	for d := 0; d < len(levels); d++ {
+		for len(levels[d]) > 0 {
+			id := levels[d][len(levels[d])-1]
+			levels[d] = levels[d][:len(levels[d])-1]
+			if _, ok := crossings[id]; ok {
+				continue
+			}
+			crossings[id] = d
+			t := m.triangles[id]
+			for k := 0; k < 3; k++ {
+				edge := newEdgeKey(t[k], t[(k+1)%3])
+				next := d
+				if m.constrained[edge] {
+					next++
+				}
+				if next == len(levels) {
+					levels = append(levels, nil)
+				}
+				for _, owner := range m.edges[edge] {
+					if _, ok := crossings[owner]; !ok {
+						levels[next] = append(levels[next], owner)
+					}
+				}
+			}
+		}
+	}
+	for id, d := range crossings {
+		if d%2 == 0 {
+			m.removeTriangle(id)
+		}
+	}
+}
+
+//triangulateCommand is "2DGCS triangulate -points survey.csv [-edges edges.csv] [-boundary]";
+//it writes resultVectorModel.csv and resultPrimativeModel.csv like the grid download does
+func triangulateCommand(args []string) {
+	commandFlags := flag.NewFlagSet("triangulate", flag.ExitOnError)
+	pointsFile := commandFlags.String("points", "", "CSV of points with latitude, longitude and elevation columns")
+	edgesFile := commandFlags.String("edges", "", "CSV of constraint edges as from,to point rows counted from 0")
+	boundary := commandFlags.Bool("boundary", false, "the constraint edges are a closed outline; drop triangles outside of it and in its holes")
+	commandFlags.Parse(args)
+	if *pointsFile == "" {
+		log.Fatal("fatal error: -points is required")
+	}
+
+	points, err := readScatteredPoints(*pointsFile)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	var constraints [][2]int
+	if *edgesFile != "" {
+		constraints, err = readConstraintEdges(*edgesFile)
+		if err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+	}
+	if *boundary && *edgesFile == "" {
+		log.Fatal("fatal error: -boundary needs the outline as -edges")
+	}
+
+	compositeVector, primitiveIndex, err := triangulateScattered(points, constraints, *boundary)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+
+	if err := site.WriteVectorModel(compositeVector, primitiveIndex); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fmt.Println(len(compositeVector), "vectors,", len(primitiveIndex), "primitives triangulated.")
+}
+
+//readScatteredPoints reads a CSV with a header naming the latitude, longitude and elevation columns
+func readScatteredPoints(path string) ([]*site.MapVector, error) {
+	//a scattered point's elevation may also be its z
+	elevation := elevationColumn
+	elevation.aliases = append([]string{"z"}, elevation.aliases...)
+
+	var points []*site.MapVector
+	err := readCSVColumns(path, []csvColumn{latColumn, lngColumn, elevation}, func(line int, fields []string) error {
+		values, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		points = append(points, &site.MapVector{
+			Latitude:   values[0],
+			Longtitude: values[1],
+			Elevation:  values[2],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+//readConstraintEdges reads from,to pairs of point rows; a header line is skipped
+func readConstraintEdges(path string) ([][2]int, error) {
+	clientsFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer clientsFile.Close()
+
+	records, err := csv.NewReader(clientsFile).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	var edges [][2]int
+	for line, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s line %d: needs from,to", path, line+1)
+		}
+		from, errFrom := strconv.Atoi(strings.TrimSpace(record[0]))
+		to, errTo := strconv.Atoi(strings.TrimSpace(record[1]))
+		if errFrom != nil || errTo != nil {
+			if line == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("%s line %d: needs from,to", path, line+1)
+		}
+		edges = append(edges, [2]int{from, to})
+	}
+	return edges, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//checkTriangles fails unless the live triangles are counter-clockwise and no edge is used twice
+//in the same direction, so they do not overlap; it returns their area
+func checkTriangles(t *testing.T, name string, m *delaunayMesh) float64 {
+	area := 0.0
+	directed := map[[2]int]bool{}
+	for _, v := range m.triangles {
+		if v[0] < 0 {
+			continue
+		}
+		twice := orient(m.points[v[0]], m.points[v[1]], m.points[v[2]])
+		if twice <= 0 {
+			t.Errorf("%s: triangle %v is not counter-clockwise", name, v)
+		}
+		area += twice / 2
+		for k := 0; k < 3; k++ {
+			edge := [2]int{v[k], v[(k+1)%3]}
+			if directed[edge] {
+				t.Errorf("%s: triangles overlap on %v", name, edge)
+			}
+			directed[edge] = true
+		}
+	}
+	return area
+}
+
+//constraintPresent tells if the edge ab is in the mesh, as edges between the points along it
+func constraintPresent(m *delaunayMesh, a, b int) bool {
+	pa, pb := m.points[a], m.points[b]
+	length2 := (pb.x-pa.x)*(pb.x-pa.x) + (pb.y-pa.y)*(pb.y-pa.y)
+	along := func(c int) float64 {
+		pc := m.points[c]
+		return (pc.x-pa.x)*(pb.x-pa.x) + (pc.y-pa.y)*(pb.y-pa.y)
+	}
+	chain := []int{a, b}
+	for c, pc := range m.points {
+		if c != a && c != b && orient(pa, pb, pc) == 0 && along(c) > 0 && along(c) < length2 {
+			chain = append(chain, c)
+		}
+	}
+	sort.Slice(chain, func(i, j int) bool { return along(chain[i]) < along(chain[j]) })
+	for i := 1; i < len(chain); i++ {
+		if _, ok := m.edges[newEdgeKey(chain[i-1], chain[i])]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func polygonArea(points []delaunayPoint) float64 {
+	area := 0.0
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		area += p.x*q.y - q.x*p.y
+	}
+	return area / 2
+}
+
+func TestDelaunaySweep(t *testing.T) {
+	random := rand.New(rand.NewSource(8))
+	var scattered []delaunayPoint
+	for i := 0; i < 300; i++ {
+		scattered = append(scattered, delaunayPoint{random.Float64() * 100, random.Float64() * 50})
+	}
+	//every four neighbours of a grid are on a circle
+	var grid []delaunayPoint
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			grid = append(grid, delaunayPoint{float64(x), float64(y)})
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		points []delaunayPoint
+		area   float64 //0 to leave out
+	}{{"scattered", scattered, 0}, {"grid", grid, 25}} {
+		m := newDelaunayMesh(test.points)
+		area := checkTriangles(t, test.name, m)
+		if test.area > 0 && math.Abs(area-test.area) > 1e-9 {
+			t.Errorf("%s: area %v, want %v", test.name, area, test.area)
+		}
+
+		//all points are used and the hull is covered: 2n-2-h triangles for h edges on the hull
+		hull := 0
+		for _, owners := range m.edges {
+			if len(owners) == 1 {
+				hull++
+			}
+		}
+		if len(m.triangles) != 2*len(test.points)-2-hull {
+			t.Errorf("%s: %d triangles of %d points with %d on the hull", test.name, len(m.triangles), len(test.points), hull)
+		}
+
+		//no point is inside the circle of a triangle
+		for _, v := range m.triangles {
+			a, b, c := m.points[v[0]], m.points[v[1]], m.points[v[2]]
+			scale := orient(a, b, c) * orient(a, b, c)
+			for i, d := range m.points {
+				if i != v[0] && i != v[1] && i != v[2] && inCircle(a, b, c, d) > 1e-9*scale {
+					t.Errorf("%s: point %d is inside the circle of %v", test.name, i, v)
+				}
+			}
+		}
+	}
+}
+
+func TestDelaunayConstraints(t *testing.T) {
+	//a square with a square hole; the points next to the hole keep its sides out of the
+	//unconstrained mesh
+	holed := []delaunayPoint{
+		{0, 0}, {10, 0}, {10, 10}, {0, 10},
+		{4, 4}, {6, 4}, {6, 6}, {4, 6},
+		{5, 3.9}, {6.1, 5}, {5, 6.1}, {3.9, 5},
+		{5, 5}, {2, 7}, {8, 2}, {1, 1},
+	}
+	//a U on a 7x7 grid with slanted insides; its sides along the grid run through grid points
+	var grid []delaunayPoint
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 7; x++ {
+			grid = append(grid, delaunayPoint{float64(x), float64(y)})
+		}
+	}
+	at := func(x, y int) int { return y*7 + x }
+
+	tests := []struct {
+		name        string
+		points      []delaunayPoint
+		constraints [][2]int
+		hull, area  float64
+	}{
+		{"square with a hole", holed, [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 0}, {4, 5}, {5, 6}, {6, 7}, {7, 4}}, 100, 96},
+		{"concave", grid, [][2]int{{at(0, 0), at(6, 0)}, {at(6, 0), at(6, 6)}, {at(6, 6), at(4, 6)},
+			{at(4, 6), at(3, 2)}, {at(3, 2), at(2, 6)}, {at(2, 6), at(0, 6)}, {at(0, 6), at(0, 0)}},
+			36, polygonArea([]delaunayPoint{{0, 0}, {6, 0}, {6, 6}, {4, 6}, {3, 2}, {2, 6}, {0, 6}})},
+	}
+	for _, test := range tests {
+		m := newDelaunayMesh(test.points)
+		missing := 0
+		for _, constraint := range test.constraints {
+			if !constraintPresent(m, constraint[0], constraint[1]) {
+				missing++
+			}
+		}
+		if missing == 0 {
+			t.Errorf("%s: every constraint is in the unconstrained mesh already", test.name)
+		}
+
+		for _, constraint := range test.constraints {
+			if err := m.insertConstraint(constraint[0], constraint[1]); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		}
+		for _, constraint := range test.constraints {
+			if !constraintPresent(m, constraint[0], constraint[1]) {
+				t.Errorf("%s: constraint %d-%d is not in the mesh", test.name, constraint[0], constraint[1])
+			}
+		}
+		//filling the cavities leaves the hull covered as before
+		if area := checkTriangles(t, test.name, m); math.Abs(area-test.hull) > 1e-9 {
+			t.Errorf("%s: constrained area %v, want %v", test.name, area, test.hull)
+		}
+
+		m.removeOutside()
+		if area := checkTriangles(t, test.name, m); math.Abs(area-test.area) > 1e-9 {
+			t.Errorf("%s: area inside %v, want %v", test.name, area, test.area)
+		}
+		for _, constraint := range test.constraints {
+			if !constraintPresent(m, constraint[0], constraint[1]) {
+				t.Errorf("%s: constraint %d-%d is dropped with the outside", test.name, constraint[0], constraint[1])
+			}
+		}
+	}
+
+	//constraints crossing each other cannot both be in the mesh
+	m := newDelaunayMesh(grid)
+	if err := m.insertConstraint(at(0, 0), at(5, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.insertConstraint(at(0, 6), at(6, 1)); err == nil {
+		t.Error("crossing constraints inserted")
+	}
+}
+
+func TestTriangulateScattered(t *testing.T) {
+	//the square with a hole of TestDelaunayConstraints about 100m across, with the first point twice
+	plane := [][2]float64{
+		{0, 0}, {10, 0}, {10, 10}, {0, 10},
+		{4, 4}, {6, 4}, {6, 6}, {4, 6},
+		{5, 3.9}, {6.1, 5}, {5, 6.1}, {3.9, 5},
+		{5, 5}, {2, 7}, {8, 2}, {1, 1}, {0, 0},
+	}
+	var points []*site.MapVector
+	for _, p := range plane {
+		points = append(points, &site.MapVector{Latitude: 43.45 + p[1]*1e-4, Longtitude: -80.49 + p[0]*1e-4, Elevation: 330})
+	}
+	outline := [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 0}, {4, 5}, {5, 6}, {6, 7}, {7, 4}}
+
+	vectors, primitiveIndex, err := triangulateScattered(points, outline, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != len(points)-1 {
+		t.Errorf("%d vectors of %d points with one twice", len(vectors), len(points))
+	}
+	//16 points with 4 on the hull make 2*16-2-4 triangles, less the 4 around the point in the hole
+	if len(primitiveIndex) != 22 {
+		t.Errorf("%d triangles, want 22", len(primitiveIndex))
+	}
+	for _, index := range primitiveIndex {
+		hole := 0
+		for _, v := range []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft} {
+			if v >= 4 && v <= 7 || v == 12 {
+				hole++
+			}
+		}
+		if hole == 3 {
+			t.Errorf("triangle %d, %d, %d is in the hole", index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft)
+		}
+	}
+
+	if _, _, err := triangulateScattered(points, outline[:7], true); err == nil {
+		t.Error("an open boundary triangulated")
+	}
+	if _, _, err := triangulateScattered(points, nil, true); err == nil {
+		t.Error("a boundary of no edges triangulated")
+	}
+	if _, _, err := triangulateScattered(points, [][2]int{{0, 17}}, false); err == nil {
+		t.Error("a constraint to no point triangulated")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//demRaster is a single band elevation grid on a regular lat/lng lattice;
+//posts are counted from the north-west corner going south and east
+type demRaster struct {
+	name             string
+	rows, cols       int
+	north, west      float64 //GCS of the first post
+	latStep, lngStep float64 //degrees between neighbouring posts
+	noData           float64
+	hasNoData        bool
+	posts            []float64
+}
+
+//demSource answers elevations offline from SRTM .hgt tiles and GeoTIFF DEMs on disk
+type demSource struct {
+	rasters []*demRaster
+}
+
+//newDEMSource loads every .hgt or .tif file; the first raster covering a point wins
+func newDEMSource(paths []string) (*demSource, error) {
+	source := &demSource{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		var raster *demRaster
+		var err error
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".hgt":
+			raster, err = readHGT(path)
+		case ".tif", ".tiff":
+			raster, err = readGeoTIFF(path)
+		default:
+			err = fmt.Errorf("dem: unknown elevation file type %s", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		source.rasters = append(source.rasters, raster)
+	}
+	if len(source.rasters) == 0 {
+		return nil, fmt.Errorf("dem: no elevation files given")
+	}
+	return source, nil
+}
+
+//Provider is named after the files, as different DEMs answer differently for the same point
+func (d *demSource) Provider() string {
+	var names []string
+	for _, raster := range d.rasters {
+		names = append(names, filepath.Base(raster.name))
+	}
+	return "dem:" + strings.Join(names, "+")
+}
+
+func (d *demSource) Elevation(ctx context.Context, locations []latLng) ([]float64, error) {
+	elevations := make([]float64, len(locations))
+	for i, location := range locations {
+		found := false
+		for _, raster := range d.rasters {
+			if elevation, ok := raster.elevation(location.Lat, location.Lng); ok {
+				elevations[i] = elevation
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("dem: no elevation at %.7f, %.7f", location.Lat, location.Lng)
+		}
+	}
+	return elevations, nil
+}
+
+//elevation interpolates bilinearly between the 4 posts around the point;
+//void posts are left out and the remaining weights renormalized
+func (r *demRaster) elevation(lat, lng float64) (float64, bool) {
+	//points on the edge of the raster may land a rounding error outside of it
+	row := clampPost((r.north-lat)/r.latStep, r.rows)
+	col := clampPost((lng-r.west)/r.lngStep, r.cols)
+	if row < 0 || col < 0 || row > float64(r.rows-1) || col > float64(r.cols-1) {
+		return 0, false
+	}
+
+	row0 := int(math.Min(math.Floor(row), float64(r.rows-2)))
+	col0 := int(math.Min(math.Floor(col), float64(r.cols-2)))
+	if row0 < 0 {
+		row0 = 0
+	}
+	if col0 < 0 {
+		col0 = 0
+	}
+	fracRow := row - float64(row0)
+	fracCol := col - float64(col0)
+
+	var sum, weights float64
+	for _, corner := range [4]struct {
+		row, col int
+		weight   float64
+	}{
+		{row0, col0, (1 - fracRow) * (1 - fracCol)},
+		{row0, col0 + 1, (1 - fracRow) * fracCol},
+		{row0 + 1, col0, fracRow * (1 - fracCol)},
+		{row0 + 1, col0 + 1, fracRow * fracCol},
+	} {
+		if corner.row >= r.rows || corner.col >= r.cols || corner.weight == 0 {
+			continue
+		}
+		post := r.posts[corner.row*r.cols+corner.col]
+		if r.hasNoData && post == r.noData || math.IsNaN(post) {
+			continue
+		}
+		sum += post * corner.weight
+		weights += corner.weight
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
+
+func clampPost(post float64, posts int) float64 {
+	const tolerance = 1e-6
+	if post < 0 && post > -tolerance {
+		return 0
+	}
+	if last := float64(posts - 1); post > last && post < last+tolerance {
+		return last
+	}
+	return post
+}
+
+//readHGT reads an SRTM tile; the file name (e.g. N43W081.hgt) is the south-west corner
+//and the tile is a square of big-endian int16 posts with -32768 as void
+func readHGT(path string) (*demRaster, error) {
+	base := strings.ToUpper(filepath.Base(path))
+	if len(base) < 7 {
+		return nil, fmt.Errorf("dem: cannot locate %s from its name", path)
+	}
+	lat, err := strconv.Atoi(base[1:3])
+	if err != nil {
+		return nil, fmt.Errorf("dem: cannot locate %s from its name", path)
+	}
+	lng, err := strconv.Atoi(base[4:7])
+	if err != nil {
+		return nil, fmt.Errorf("dem: cannot locate %s from its name", path)
+	}
+	if base[0] == 'S' {
+		lat = -lat
+	} else if base[0] != 'N' {
+		return nil, fmt.Errorf("dem: cannot locate %s from its name", path)
+	}
+	if base[3] == 'W' {
+		lng = -lng
+	} else if base[3] != 'E' {
+		return nil, fmt.Errorf("dem: cannot locate %s from its name", path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	size := int(math.Sqrt(float64(len(data) / 2)))
+	if size < 2 || size*size*2 != len(data) {
+		return nil, fmt.Errorf("dem: %s is not a square SRTM tile", path)
+	}
+
+	raster := &demRaster{
+		name:      path,
+		rows:      size,
+		cols:      size,
+		north:     float64(lat + 1),
+		west:      float64(lng),
+		latStep:   1 / float64(size-1),
+		lngStep:   1 / float64(size-1),
+		noData:    -32768,
+		hasNoData: true,
+		posts:     make([]float64, size*size),
+	}
+	for i := range raster.posts {
+		raster.posts[i] = float64(int16(binary.BigEndian.Uint16(data[i*2:])))
+	}
+	return raster, nil
+}
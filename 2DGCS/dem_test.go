@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//writeHGT writes a tile of size x size posts valued 100*row + column from the north-west
+//corner, with voids at the posts given
+func writeHGT(t *testing.T, path string, size int, voids ...int) {
+	data := make([]byte, size*size*2)
+	for i := 0; i < size*size; i++ {
+		binary.BigEndian.PutUint16(data[i*2:], uint16(int16(100*(i/size)+i%size)))
+	}
+	for _, void := range voids {
+		binary.BigEndian.PutUint16(data[void*2:], uint16(0x8000))
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadHGT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hgt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//posts a quarter degree apart, the one east of the north-west corner void
+	path := filepath.Join(dir, "N43W081.hgt")
+	writeHGT(t, path, 5, 1)
+	raster, err := readHGT(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raster.north != 44 || raster.west != -81 || raster.latStep != 0.25 || raster.lngStep != 0.25 {
+		t.Errorf("tile at %v, %v by %v, %v, want 44, -81 by 0.25, 0.25", raster.north, raster.west, raster.latStep, raster.lngStep)
+	}
+
+	tests := []struct {
+		lat, lng  float64
+		elevation float64
+		ok        bool
+	}{
+		{44, -81, 0, true},
+		{43, -80, 404, true},
+		{43.5, -80.5, 202, true},
+		{43.625, -80.375, 152.5, true},
+		{43.875, -80.875, (0 + 100 + 101) / 3.0, true}, //the void left out
+		{44, -80.75, 0, false},                         //on the void
+		{43 - 1e-9, -80.5, 402, true},                  //a rounding error off the edge
+		{42.9, -80.5, 0, false},
+		{43.5, -79.9, 0, false},
+	}
+	for _, test := range tests {
+		elevation, ok := raster.elevation(test.lat, test.lng)
+		if ok != test.ok || ok && math.Abs(elevation-test.elevation) > 1e-6 {
+			t.Errorf("%v, %v: %v %v, want %v %v", test.lat, test.lng, elevation, ok, test.elevation, test.ok)
+		}
+	}
+
+	names := []struct {
+		name        string
+		north, west float64
+		fails       bool
+	}{
+		{"s01e002.hgt", 0, 2, false},
+		{"N00E000.HGT", 1, 0, false},
+		{"X43W081.hgt", 0, 0, true},
+		{"N43Q081.hgt", 0, 0, true},
+		{"N4.hgt", 0, 0, true},
+		{"tile.hgt", 0, 0, true},
+	}
+	for _, test := range names {
+		path := filepath.Join(dir, test.name)
+		writeHGT(t, path, 3)
+		raster, err := readHGT(path)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if raster.north != test.north || raster.west != test.west {
+			t.Errorf("%s: at %v, %v, want %v, %v", test.name, raster.north, raster.west, test.north, test.west)
+		}
+	}
+
+	short := filepath.Join(dir, "N10E010.hgt")
+	if err := ioutil.WriteFile(short, make([]byte, 5*5*2+2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readHGT(short); err == nil {
+		t.Error("a tile that is not square read")
+	}
+}
+
+func TestDEMSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the tile listed first answers on the meridian both tiles cover
+	west, east := filepath.Join(dir, "N43W081.hgt"), filepath.Join(dir, "N43W080.hgt")
+	writeHGT(t, west, 5)
+	writeHGT(t, east, 2)
+	source, err := newDEMSource([]string{west, " ", east + "\r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider := source.Provider(); provider != "dem:N43W081.hgt+N43W080.hgt" {
+		t.Errorf("provider %q", provider)
+	}
+	elevations, err := source.Elevation(context.Background(), []latLng{{43.5, -80.5}, {43.75, -80}, {43.5, -79.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elevations[0] != 202 || elevations[1] != 104 || elevations[2] != 50.5 {
+		t.Errorf("elevations %v, want [202 104 50.5]", elevations)
+	}
+	if _, err := source.Elevation(context.Background(), []latLng{{43.5, -80.5}, {45, -80.5}}); err == nil {
+		t.Error("a location outside every tile answered")
+	}
+
+	for _, paths := range [][]string{nil, {""}, {filepath.Join(dir, "dem.png")}, {filepath.Join(dir, "N01E001.hgt")}} {
+		if _, err := newDEMSource(paths); err == nil {
+			t.Errorf("%q: no error", paths)
+		}
+	}
+}
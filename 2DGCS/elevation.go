@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nomnom-ray/golang/site"
+	"googlemaps.github.io/maps"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+//elevationSource answers the ground elevation of GCS points;
+//elevations are returned in the same order as the requested locations.
+//Provider names the data behind the source for the elevation cache
+type elevationSource interface {
+	Elevation(ctx context.Context, locations []latLng) ([]float64, error)
+	Provider() string
+}
+
+type latLng struct {
+	Lat, Lng float64
+}
+
+//transientError is a failed request that may succeed when asked again, like a network error, a
+//rate limit or a server error; the fetch gives up on any other error at once
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string { return e.err.Error() }
+
+//googleElevation is the Google Maps elevation API; costs money and slow
+type googleElevation struct {
+	client *maps.Client
+}
+
+func newGoogleElevation(apiKey string) (*googleElevation, error) {
+	client, err := maps.NewClient(maps.WithAPIKey(strings.TrimSuffix(apiKey, "\r\n")),
+		maps.WithHTTPClient(&http.Client{Transport: serverErrors{http.DefaultTransport}}))
+	if err != nil {
+		return nil, err
+	}
+	return &googleElevation{client: client}, nil
+}
+
+//serverErrors fails the requests the API answers with a 5xx, which the client would otherwise
+//try to read as an answer
+type serverErrors struct {
+	transport http.RoundTripper
+}
+
+func (s serverErrors) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := s.transport.RoundTrip(request)
+	if err == nil && response.StatusCode >= 500 {
+		response.Body.Close()
+		return nil, fmt.Errorf("google elevation: %s", response.Status)
+	}
+	return response, err
+}
+
+//googleTransient tells the errors of the client worth asking again: requests that got no answer,
+//including the 5xx of serverErrors, and the statuses of a rate limit or a server error. A denied
+//or invalid request or a bad key is not
+func googleTransient(err error) bool {
+	var requestErr *url.Error
+	if errors.As(err, &requestErr) {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "OVER_QUERY_LIMIT") || strings.Contains(message, "UNKNOWN_ERROR")
+}
+
+func (g *googleElevation) Provider() string { return "google" }
+
+func (g *googleElevation) Elevation(ctx context.Context, locations []latLng) ([]float64, error) {
+	r := &maps.ElevationRequest{
+		Locations: make([]maps.LatLng, len(locations)),
+	}
+	for i, location := range locations {
+		r.Locations[i] = maps.LatLng{Lat: location.Lat, Lng: location.Lng}
+	}
+
+	results, err := g.client.Elevation(ctx, r)
+	if err != nil {
+		if googleTransient(err) {
+			return nil, transientError{err}
+		}
+		return nil, err
+	}
+	if len(results) != len(locations) {
+		return nil, fmt.Errorf("google elevation: %d results for %d locations", len(results), len(locations))
+	}
+
+	elevations := make([]float64, len(results))
+	for i, result := range results {
+		elevations[i] = result.Elevation
+	}
+	return elevations, nil
+}
+
+//plannedVector is a sample of the grid planGrid lays out row by row, south to north, and west
+//to east within a row; its elevation is fetched later in that order, and its place in the model
+//is set when the scene is built
+func plannedVector(lat, lng float64) *site.MapVector {
+	return &site.MapVector{
+		Latitude:   lat,
+		Longtitude: lng,
+	}
+}
+
+//fetchOptions tune how elevations are requested from the source
+type fetchOptions struct {
+	batchSize int           //locations per request
+	workers   int           //requests in flight
+	qps       float64       //requests per second over all workers; 0 is unlimited
+	retries   int           //retries of a request failed by a transientError before giving up
+	backoff   time.Duration //wait before the first retry, doubled on every retry
+
+	checkpointInterval time.Duration //how often fetched vertices are saved; 0 saves only on failure
+}
+
+//fetchElevations fills in the elevation of every vector in batches by a pool of workers;
+//each batch writes back to its own indices, so the order of the vectors is kept.
+//Vectors restored from the checkpoint or found in the cache are not requested;
+//checkpoint and cache may be nil
+func fetchElevations(ctx context.Context, source elevationSource, vectors []*site.MapVector,
+	options fetchOptions, progress *pb.ProgressBar, checkpoint *fetchCheckpoint, cache *elevationCache) error {
+
+	if options.batchSize < 1 {
+		options.batchSize = 1
+	}
+	if options.workers < 1 {
+		options.workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//one tick per request shared by all workers
+	var throttle <-chan time.Time
+	if options.qps > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / options.qps))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	var pending, cached []int
+	for i, vector := range vectors {
+		if checkpoint != nil && checkpoint.fetched[i] {
+			if cache != nil {
+				cache.store(source.Provider(), vector.Latitude, vector.Longtitude, vector.Elevation)
+			}
+			continue
+		}
+		if cache != nil {
+			if elevation, ok := cache.lookup(source.Provider(), vector.Latitude, vector.Longtitude); ok {
+				vector.Elevation = elevation
+				cached = append(cached, i)
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+	if cache != nil {
+		defer func() {
+			if err := cache.save(); err != nil {
+				log.Printf("elevation cache not saved: %s", err)
+			}
+		}()
+	}
+	if len(cached) > 0 {
+		if checkpoint != nil {
+			if err := checkpoint.markFetched(cached); err != nil {
+				log.Printf("checkpoint not saved: %s", err)
+			}
+		}
+		if progress != nil {
+			progress.Prefix(fmt.Sprintf("%d cached ", len(cached)))
+			progress.Add(len(cached))
+		}
+	}
+
+	//batches are disjoint, so every worker marks its own indices
+	fetched := make([]bool, len(vectors))
+
+	batches := make(chan []int)
+	go func() {
+		defer close(batches)
+		for start := 0; start < len(pending); start += options.batchSize {
+			end := start + options.batchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			select {
+			case batches <- pending[start:end]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	wg.Add(options.workers)
+	for i := 0; i < options.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				err := fetchBatchElevations(ctx, source, vectors, batch, options, throttle)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				for _, index := range batch {
+					fetched[index] = true
+				}
+				if cache != nil {
+					for _, index := range batch {
+						cache.store(source.Provider(), vectors[index].Latitude, vectors[index].Longtitude, vectors[index].Elevation)
+					}
+				}
+				if checkpoint != nil {
+					if err := checkpoint.markFetched(batch); err != nil {
+						log.Printf("checkpoint not saved: %s", err)
+					}
+				}
+				if progress != nil {
+					progress.Add(len(batch))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	//an interrupt while the workers wait for batches ends them without an error of their own;
+	//nothing may be written as downloaded unless every pending vector was fetched
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr == nil {
+		missing := 0
+		for _, index := range pending {
+			if !fetched[index] {
+				missing++
+			}
+		}
+		if missing > 0 {
+			firstErr = fmt.Errorf("elevations: %d of %d vectors not fetched", missing, len(pending))
+		}
+	}
+
+	//keep what was fetched before giving up for the next run
+	if firstErr != nil && checkpoint != nil {
+		if err := checkpoint.save(); err != nil {
+			log.Printf("checkpoint not saved: %s", err)
+		}
+	}
+	return firstErr
+}
+
+//fetchBatchElevations requests the vectors at the batch indices, retrying transient errors with
+//exponential backoff
+func fetchBatchElevations(ctx context.Context, source elevationSource, vectors []*site.MapVector, batch []int,
+	options fetchOptions, throttle <-chan time.Time) error {
+
+	locations := make([]latLng, len(batch))
+	for i, index := range batch {
+		locations[i] = latLng{Lat: vectors[index].Latitude, Lng: vectors[index].Longtitude}
+	}
+
+	backoff := options.backoff
+	for attempt := 0; ; attempt++ {
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		//a source that answers another number of elevations would leave vectors unfetched or overrun the batch
+		elevations, err := source.Elevation(ctx, locations)
+		if err == nil && len(elevations) != len(locations) {
+			err = fmt.Errorf("%d elevations for %d locations", len(elevations), len(locations))
+		}
+		if err == nil {
+			for i, elevation := range elevations {
+				vectors[batch[i]].Elevation = elevation
+			}
+			return nil
+		}
+		if _, transient := err.(transientError); !transient || attempt >= options.retries || ctx.Err() != nil {
+			return fmt.Errorf("elevation of %.7f, %.7f and %d more: %s",
+				locations[0].Lat, locations[0].Lng, len(locations)-1, err)
+		}
+
+		log.Printf("elevation request failed, retrying in %s: %s", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//fakeElevation answers an elevation made of the location, and keeps the size of every request
+type fakeElevation struct {
+	mutex    sync.Mutex
+	requests []int
+	extra    int     //elevations answered more than asked for; less when negative
+	failures []error //the first requests fail with these, one each
+}
+
+func (f *fakeElevation) Provider() string { return "fake" }
+
+func (f *fakeElevation) Elevation(ctx context.Context, locations []latLng) ([]float64, error) {
+	f.mutex.Lock()
+	f.requests = append(f.requests, len(locations))
+	var err error
+	if len(f.failures) > 0 {
+		err, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	elevations := make([]float64, len(locations)+f.extra)
+	for i := range elevations {
+		if i < len(locations) {
+			elevations[i] = fakeGround(locations[i].Lat, locations[i].Lng)
+		}
+	}
+	return elevations, nil
+}
+
+func fakeGround(lat, lng float64) float64 {
+	return 1000*lat + lng
+}
+
+//fakeVectors is a row of n vectors with distinct locations
+func fakeVectors(n int) []*site.MapVector {
+	vectors := make([]*site.MapVector, n)
+	for i := range vectors {
+		vectors[i] = plannedVector(43+float64(i)/1000, -80-float64(i)/1000)
+	}
+	return vectors
+}
+
+func TestFetchElevations(t *testing.T) {
+	tests := []struct {
+		vectors, batchSize, workers int
+		requests                    int
+	}{
+		{vectors: 1, batchSize: 512, workers: 4, requests: 1},
+		{vectors: 100, batchSize: 1, workers: 1, requests: 100},
+		{vectors: 100, batchSize: 7, workers: 3, requests: 15},
+		{vectors: 512, batchSize: 512, workers: 4, requests: 1},
+		{vectors: 513, batchSize: 512, workers: 4, requests: 2},
+		{vectors: 30, batchSize: 0, workers: 0, requests: 30},
+	}
+	for _, test := range tests {
+		source := &fakeElevation{}
+		vectors := fakeVectors(test.vectors)
+		options := fetchOptions{batchSize: test.batchSize, workers: test.workers}
+		if err := fetchElevations(context.Background(), source, vectors, options, nil, nil, nil); err != nil {
+			t.Errorf("%d vectors in %d by %d workers: %s", test.vectors, test.batchSize, test.workers, err)
+			continue
+		}
+		if len(source.requests) != test.requests {
+			t.Errorf("%d vectors in %d by %d workers: %d requests, want %d",
+				test.vectors, test.batchSize, test.workers, len(source.requests), test.requests)
+		}
+		for _, size := range source.requests {
+			if test.batchSize > 0 && size > test.batchSize {
+				t.Errorf("%d vectors in %d by %d workers: a request of %d", test.vectors, test.batchSize, test.workers, size)
+			}
+		}
+		//every batch writes back to its own vectors
+		for i, vector := range vectors {
+			if want := fakeGround(vector.Latitude, vector.Longtitude); vector.Elevation != want {
+				t.Errorf("%d vectors in %d by %d workers: vector %d at %v, want %v",
+					test.vectors, test.batchSize, test.workers, i, vector.Elevation, want)
+				break
+			}
+		}
+	}
+}
+
+func TestFetchElevationsFails(t *testing.T) {
+	//a source answering another number of elevations than asked is an error, not a short download
+	for _, extra := range []int{1, -1} {
+		source := &fakeElevation{extra: extra}
+		if err := fetchElevations(context.Background(), source, fakeVectors(10), fetchOptions{batchSize: 4, workers: 2}, nil, nil, nil); err == nil {
+			t.Errorf("%d elevations too many accepted", extra)
+		}
+	}
+
+	//an interrupt before the workers get their batches is not a complete download
+	for run := 0; run < 20; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := fetchElevations(ctx, &fakeElevation{}, fakeVectors(100), fetchOptions{batchSize: 1, workers: 4}, nil, nil, nil); err == nil {
+			t.Fatal("interrupted download reported complete")
+		}
+	}
+}
+
+func TestFetchElevationsRetries(t *testing.T) {
+	busy := transientError{errors.New("maps: OVER_QUERY_LIMIT - You have exceeded your rate-limit for this API.")}
+	denied := errors.New("maps: REQUEST_DENIED - The provided API key is invalid.")
+	tests := []struct {
+		name     string
+		failures []error
+		retries  int
+		requests int
+		fails    bool
+	}{
+		{"answered", nil, 2, 1, false},
+		{"transient errors retried", []error{busy, busy}, 2, 3, false},
+		{"out of retries", []error{busy, busy, busy}, 2, 3, true},
+		{"no retries", []error{busy}, 0, 1, true},
+		{"denied at once", []error{denied, busy}, 5, 1, true},
+		{"denied after a retry", []error{busy, denied}, 5, 2, true},
+	}
+	for _, test := range tests {
+		source := &fakeElevation{failures: test.failures}
+		options := fetchOptions{batchSize: 10, workers: 1, retries: test.retries, backoff: time.Millisecond}
+		err := fetchElevations(context.Background(), source, fakeVectors(10), options, nil, nil, nil)
+		if (err != nil) != test.fails {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+		if len(source.requests) != test.requests {
+			t.Errorf("%s: %d requests, want %d", test.name, len(source.requests), test.requests)
+		}
+	}
+}
+
+func TestFetchElevationsThrottle(t *testing.T) {
+	//a request per tick of the limit over all workers
+	source := &fakeElevation{}
+	options := fetchOptions{batchSize: 2, workers: 4, qps: 100}
+	start := time.Now()
+	if err := fetchElevations(context.Background(), source, fakeVectors(10), options, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("5 requests at 100 per second in %s", elapsed)
+	}
+}
+
+func TestGoogleTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{&url.Error{Op: "Get", URL: "https://maps.googleapis.com", Err: errors.New("connection reset by peer")}, true},
+		{fmt.Errorf("maps: %w", &url.Error{Op: "Get", URL: "https://maps.googleapis.com", Err: errors.New("timeout")}), true},
+		{errors.New("maps: OVER_QUERY_LIMIT - You have exceeded your rate-limit for this API."), true},
+		{errors.New("maps: UNKNOWN_ERROR - "), true},
+		{errors.New("maps: REQUEST_DENIED - The provided API key is invalid."), false},
+		{errors.New("maps: INVALID_REQUEST - "), false},
+		{errors.New("maps: main.go: API Key missing"), false},
+	}
+	for _, test := range tests {
+		if transient := googleTransient(test.err); transient != test.transient {
+			t.Errorf("%q: transient %v, want %v", test.err, transient, test.transient)
+		}
+	}
+
+	//a server error is an error of the request, and so transient; a client error is an answer
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: serverErrors{http.DefaultTransport}}
+	if _, err := client.Get(server.URL); err == nil || !googleTransient(err) {
+		t.Errorf("503: error %v", err)
+	}
+	status = http.StatusBadRequest
+	if response, err := client.Get(server.URL); err != nil || response.StatusCode != status {
+		t.Errorf("400: error %v", err)
+	} else {
+		response.Body.Close()
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//exportMesh is the terrain as an indexed mesh in metres with a normal and the GCS of every vertex
+type exportMesh struct {
+	positions, normals []fauxgl.Vector
+	gcs                []fauxgl.Vector //latitude, elevation, longitude like Triangle.V*.Texture
+	indices            []int
+	properties         *site.ModelProperties
+}
+
+//yUp turns the model axes (X north, Y down, Z west) into X north, Y up, Z east for OBJ and glTF
+func yUp(v fauxgl.Vector) fauxgl.Vector { return fauxgl.Vector{X: v.X, Y: -v.Y, Z: -v.Z} }
+
+//zUp turns the model axes into X east, Y north, Z up for PLY and STL
+func zUp(v fauxgl.Vector) fauxgl.Vector { return fauxgl.Vector{X: -v.Z, Y: v.X, Z: -v.Y} }
+
+//newExportMesh is the mesh projection renders, before it is normalized to camera space
+func newExportMesh(compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex, properties *site.ModelProperties) *exportMesh {
+	mesh := &exportMesh{
+		positions:  make([]fauxgl.Vector, len(compositeVector)),
+		normals:    make([]fauxgl.Vector, len(compositeVector)),
+		gcs:        make([]fauxgl.Vector, len(compositeVector)),
+		properties: properties,
+	}
+	for i, vector := range compositeVector {
+		mesh.positions[i] = fauxgl.Vector{X: vector.VertX, Y: vector.VertY, Z: vector.VertZ}
+		mesh.gcs[i] = fauxgl.Vector{X: vector.Latitude, Y: vector.Elevation, Z: vector.Longtitude}
+	}
+	//vertex normals weighted by the area of the triangles around them
+	for _, index := range primitiveIndex {
+		v1, v2, v3 := index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft
+		mesh.indices = append(mesh.indices, v1, v2, v3)
+		normal := mesh.positions[v2].Sub(mesh.positions[v1]).Cross(mesh.positions[v3].Sub(mesh.positions[v1]))
+		for _, v := range []int{v1, v2, v3} {
+			mesh.normals[v] = mesh.normals[v].Add(normal)
+		}
+	}
+	for i, normal := range mesh.normals {
+		if normal.Length() > 0 {
+			mesh.normals[i] = normal.Normalize()
+		}
+	}
+	return mesh
+}
+
+//exportCommand is "2DGCS export -o terrain.obj|.ply|.stl|.gltf|.glb [-format ...]"; the terrain
+//is the scene of the config
+func exportCommand(args []string) {
+	commandFlags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := commandFlags.String("o", "terrain.obj", "output file")
+	format := commandFlags.String("format", "", "obj, ply, stl, gltf or glb; taken from the output file when empty")
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*output)), ".")
+	}
+	writers := map[string]func(io.Writer, *exportMesh) error{
+		"obj":  writeOBJ,
+		"ply":  writePLY,
+		"stl":  writeSTL,
+		"gltf": writeGLTF,
+		"glb":  writeGLB,
+	}
+	write, ok := writers[*format]
+	if !ok {
+		log.Fatalf("fatal error: unknown export format %q; use obj, ply, stl, gltf or glb", *format)
+	}
+
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+
+	exportFile, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	defer exportFile.Close()
+	writer := bufio.NewWriter(exportFile)
+	if err := write(writer, newExportMesh(model.compositeVector, model.primitiveIndex, model.properties)); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fmt.Println(len(model.compositeVector), "vectors,", len(model.primitiveIndex), "primitives exported to", *output)
+}
+
+//writeOBJ writes Y up positions and normals; the texture coordinate of every vertex is its
+//latitude, elevation and longitude
+func writeOBJ(w io.Writer, mesh *exportMesh) error {
+	fmt.Fprintf(w, "# 2DGCS terrain; metres, x north, y up, z east; vt is latitude elevation longitude\n")
+	fmt.Fprintf(w, "# origin %.9f %.9f %.3f\n",
+		mesh.properties.OriginLatitude, mesh.properties.OriginLongitude, mesh.properties.OriginElevation)
+	for _, position := range mesh.positions {
+		p := yUp(position)
+		fmt.Fprintf(w, "v %.4f %.4f %.4f\n", p.X, p.Y, p.Z)
+	}
+	for _, gcs := range mesh.gcs {
+		fmt.Fprintf(w, "vt %.9f %.3f %.9f\n", gcs.X, gcs.Y, gcs.Z)
+	}
+	for _, normal := range mesh.normals {
+		n := yUp(normal)
+		fmt.Fprintf(w, "vn %.5f %.5f %.5f\n", n.X, n.Y, n.Z)
+	}
+	for i := 0; i < len(mesh.indices); i += 3 {
+		v1, v2, v3 := mesh.indices[i]+1, mesh.indices[i+1]+1, mesh.indices[i+2]+1
+		if _, err := fmt.Fprintf(w, "f %d/%d/%d %d/%d/%d %d/%d/%d\n", v1, v1, v1, v2, v2, v2, v3, v3, v3); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writePLY writes binary PLY, Z up, with latitude, longitude and elevation as vertex properties
+func writePLY(w io.Writer, mesh *exportMesh) error {
+	fmt.Fprintf(w, "ply\nformat binary_little_endian 1.0\n")
+	fmt.Fprintf(w, "comment 2DGCS terrain; metres, x east, y north, z up\n")
+	fmt.Fprintf(w, "comment origin %.9f %.9f %.3f\n",
+		mesh.properties.OriginLatitude, mesh.properties.OriginLongitude, mesh.properties.OriginElevation)
+	fmt.Fprintf(w, "element vertex %d\n", len(mesh.positions))
+	for _, name := range []string{"x", "y", "z", "nx", "ny", "nz"} {
+		fmt.Fprintf(w, "property float %s\n", name)
+	}
+	for _, name := range []string{"latitude", "longitude", "elevation"} {
+		fmt.Fprintf(w, "property double %s\n", name)
+	}
+	fmt.Fprintf(w, "element face %d\n", len(mesh.indices)/3)
+	fmt.Fprintf(w, "property list uchar int vertex_indices\nend_header\n")
+
+	for i := range mesh.positions {
+		p, n := zUp(mesh.positions[i]), zUp(mesh.normals[i])
+		vertex := struct {
+			Position, Normal [3]float32
+			GCS              [3]float64
+		}{
+			Position: [3]float32{float32(p.X), float32(p.Y), float32(p.Z)},
+			Normal:   [3]float32{float32(n.X), float32(n.Y), float32(n.Z)},
+			GCS:      [3]float64{mesh.gcs[i].X, mesh.gcs[i].Z, mesh.gcs[i].Y},
+		}
+		if err := binary.Write(w, binary.LittleEndian, &vertex); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(mesh.indices); i += 3 {
+		face := struct {
+			Count   uint8
+			Indices [3]int32
+		}{3, [3]int32{int32(mesh.indices[i]), int32(mesh.indices[i+1]), int32(mesh.indices[i+2])}}
+		if err := binary.Write(w, binary.LittleEndian, &face); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeSTL writes binary STL, Z up; the format has no room for the GCS
+func writeSTL(w io.Writer, mesh *exportMesh) error {
+	var header [80]byte
+	copy(header[:], fmt.Sprintf("2DGCS terrain; metres, x east, y north, z up; origin %.7f %.7f %.2f",
+		mesh.properties.OriginLatitude, mesh.properties.OriginLongitude, mesh.properties.OriginElevation))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(mesh.indices)/3)); err != nil {
+		return err
+	}
+	for i := 0; i < len(mesh.indices); i += 3 {
+		v1 := zUp(mesh.positions[mesh.indices[i]])
+		v2 := zUp(mesh.positions[mesh.indices[i+1]])
+		v3 := zUp(mesh.positions[mesh.indices[i+2]])
+		normal := v2.Sub(v1).Cross(v3.Sub(v1))
+		if normal.Length() > 0 {
+			normal = normal.Normalize()
+		}
+		var facet struct {
+			Normal, V1, V2, V3 [3]float32
+			Attribute          uint16
+		}
+		for _, pair := range []struct {
+			to   *[3]float32
+			from fauxgl.Vector
+		}{{&facet.Normal, normal}, {&facet.V1, v1}, {&facet.V2, v2}, {&facet.V3, v3}} {
+			*pair.to = [3]float32{float32(pair.from.X), float32(pair.from.Y), float32(pair.from.Z)}
+		}
+		if err := binary.Write(w, binary.LittleEndian, &facet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//glTF 2.0 as far as a single indexed triangle mesh needs it
+type gltfDocument struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name string `json:"name"`
+	Mesh int    `json:"mesh"`
+}
+
+type gltfMesh struct {
+	Name       string                 `json:"name"`
+	Primitives []gltfPrimitive        `json:"primitives"`
+	Extras     map[string]interface{} `json:"extras,omitempty"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Mode       int            `json:"mode"`
+}
+
+type gltfBuffer struct {
+	ByteLength int    `json:"byteLength"`
+	URI        string `json:"uri,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+const (
+	gltfFloat        = 5126
+	gltfUnsignedInt  = 5125
+	gltfArrayBuffer  = 34962
+	gltfElementArray = 34963
+	gltfTriangles    = 4
+)
+
+//newGLTF builds the document and its binary buffer. Positions are Y up as glTF wants;
+//GCS goes in the custom attributes _LATITUDE, _LONGITUDE and _ELEVATION as offsets from the
+//origin in the mesh extras, since glTF only has 32 bit floats
+func newGLTF(mesh *exportMesh) (*gltfDocument, []byte) {
+	var buffer bytes.Buffer
+	document := &gltfDocument{
+		Asset:  gltfAsset{Version: "2.0", Generator: "2DGCS"},
+		Scenes: []gltfScene{{Nodes: []int{0}}},
+		Nodes:  []gltfNode{{Name: "terrain", Mesh: 0}},
+	}
+	addAccessor := func(data []float32, accessorType string, components int, target int, bounds bool) int {
+		offset := buffer.Len()
+		binary.Write(&buffer, binary.LittleEndian, data)
+		document.BufferViews = append(document.BufferViews, gltfBufferView{
+			ByteOffset: offset, ByteLength: buffer.Len() - offset, Target: target})
+		accessor := gltfAccessor{
+			BufferView:    len(document.BufferViews) - 1,
+			ComponentType: gltfFloat,
+			Count:         len(data) / components,
+			Type:          accessorType,
+		}
+		if bounds {
+			accessor.Min = append([]float32{}, data[:components]...)
+			accessor.Max = append([]float32{}, data[:components]...)
+			for i, value := range data {
+				accessor.Min[i%components] = float32(math.Min(float64(accessor.Min[i%components]), float64(value)))
+				accessor.Max[i%components] = float32(math.Max(float64(accessor.Max[i%components]), float64(value)))
+			}
+		}
+		document.Accessors = append(document.Accessors, accessor)
+		return len(document.Accessors) - 1
+	}
+
+	var positions, normals, latitudes, longitudes, elevations []float32
+	for i := range mesh.positions {
+		p, n := yUp(mesh.positions[i]), yUp(mesh.normals[i])
+		positions = append(positions, float32(p.X), float32(p.Y), float32(p.Z))
+		normals = append(normals, float32(n.X), float32(n.Y), float32(n.Z))
+		latitudes = append(latitudes, float32(mesh.gcs[i].X-mesh.properties.OriginLatitude))
+		elevations = append(elevations, float32(mesh.gcs[i].Y-mesh.properties.OriginElevation))
+		longitudes = append(longitudes, float32(mesh.gcs[i].Z-mesh.properties.OriginLongitude))
+	}
+	primitive := gltfPrimitive{
+		Attributes: map[string]int{
+			"POSITION":   addAccessor(positions, "VEC3", 3, gltfArrayBuffer, true),
+			"NORMAL":     addAccessor(normals, "VEC3", 3, gltfArrayBuffer, false),
+			"_LATITUDE":  addAccessor(latitudes, "SCALAR", 1, gltfArrayBuffer, false),
+			"_LONGITUDE": addAccessor(longitudes, "SCALAR", 1, gltfArrayBuffer, false),
+			"_ELEVATION": addAccessor(elevations, "SCALAR", 1, gltfArrayBuffer, false),
+		},
+		Mode: gltfTriangles,
+	}
+
+	offset := buffer.Len()
+	indices := make([]uint32, len(mesh.indices))
+	for i, index := range mesh.indices {
+		indices[i] = uint32(index)
+	}
+	binary.Write(&buffer, binary.LittleEndian, indices)
+	document.BufferViews = append(document.BufferViews, gltfBufferView{
+		ByteOffset: offset, ByteLength: buffer.Len() - offset, Target: gltfElementArray})
+	document.Accessors = append(document.Accessors, gltfAccessor{
+		BufferView:    len(document.BufferViews) - 1,
+		ComponentType: gltfUnsignedInt,
+		Count:         len(indices),
+		Type:          "SCALAR",
+	})
+	primitive.Indices = len(document.Accessors) - 1
+
+	document.Meshes = []gltfMesh{{
+		Name:       "terrain",
+		Primitives: []gltfPrimitive{primitive},
+		Extras: map[string]interface{}{
+			"axes":            "metres, x north, y up, z east",
+			"originLatitude":  mesh.properties.OriginLatitude,
+			"originLongitude": mesh.properties.OriginLongitude,
+			"originElevation": mesh.properties.OriginElevation,
+		},
+	}}
+	document.Buffers = []gltfBuffer{{ByteLength: buffer.Len()}}
+	return document, buffer.Bytes()
+}
+
+//writeGLTF writes a .gltf with the buffer embedded as a data URI
+func writeGLTF(w io.Writer, mesh *exportMesh) error {
+	document, data := newGLTF(mesh)
+	document.Buffers[0].URI = "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(data)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+//writeGLB writes the binary container: a JSON chunk and a BIN chunk, both padded to 4 bytes
+func writeGLB(w io.Writer, mesh *exportMesh) error {
+	document, data := newGLTF(mesh)
+	content, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	for len(content)%4 != 0 {
+		content = append(content, ' ')
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	header := []uint32{0x46546C67, 2, uint32(12 + 8 + len(content) + 8 + len(data))}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	for _, chunk := range []struct {
+		kind uint32
+		data []byte
+	}{{0x4E4F534A, content}, {0x004E4942, data}} {
+		if err := binary.Write(w, binary.LittleEndian, []uint32{uint32(len(chunk.data)), chunk.kind}); err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
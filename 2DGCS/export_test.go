@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//testExportMesh is a 3x3 grid 10m apart rising to the north-west, at 43, -80 and 300m
+func testExportMesh(t *testing.T) *exportMesh {
+	var compositeVector []*site.MapVector
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			compositeVector = append(compositeVector, &site.MapVector{
+				VertX: float64(row) * 10, VertY: -float64(row * col), VertZ: float64(col) * 10,
+				Latitude: 43 + float64(row)*1e-4, Longtitude: -80 - float64(col)*1e-4, Elevation: 300 + float64(row*col),
+			})
+		}
+	}
+	primitiveIndex, err := triangulateGrid(3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := &site.ModelProperties{OriginLatitude: 43, OriginLongitude: -80, OriginElevation: 300}
+	return newExportMesh(compositeVector, primitiveIndex, properties)
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mesh := testExportMesh(t)
+
+	tests := []struct {
+		name  string
+		write func(io.Writer, *exportMesh) error
+		read  func(string) ([][3]float64, [][3]int, error)
+		axes  func(fauxgl.Vector) fauxgl.Vector
+	}{
+		{"terrain.obj", writeOBJ, readOBJ, yUp},
+		{"terrain.ply", writePLY, readPLY, zUp},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		var written bytes.Buffer
+		if err := test.write(&written, mesh); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, written.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		positions, faces, err := test.read(path)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(positions) != len(mesh.positions) || len(faces)*3 != len(mesh.indices) {
+			t.Errorf("%s: %d positions and %d faces read, want %d and %d",
+				test.name, len(positions), len(faces), len(mesh.positions), len(mesh.indices)/3)
+			continue
+		}
+		for i, position := range positions {
+			want := test.axes(mesh.positions[i])
+			if math.Abs(position[0]-want.X) > 1e-4 || math.Abs(position[1]-want.Y) > 1e-4 || math.Abs(position[2]-want.Z) > 1e-4 {
+				t.Errorf("%s: position %d read as %v, want %v", test.name, i, position, want)
+			}
+		}
+		for i, face := range faces {
+			if face != [3]int{mesh.indices[i*3], mesh.indices[i*3+1], mesh.indices[i*3+2]} {
+				t.Errorf("%s: face %d read as %v, want %v", test.name, i, face, mesh.indices[i*3:i*3+3])
+			}
+		}
+	}
+}
+
+func TestWriteGLB(t *testing.T) {
+	mesh := testExportMesh(t)
+	var written bytes.Buffer
+	if err := writeGLB(&written, mesh); err != nil {
+		t.Fatal(err)
+	}
+	data := written.Bytes()
+
+	//header: magic, version 2 and the whole length
+	if len(data) < 12 || binary.LittleEndian.Uint32(data) != 0x46546C67 || binary.LittleEndian.Uint32(data[4:]) != 2 ||
+		int(binary.LittleEndian.Uint32(data[8:])) != len(data) {
+		t.Fatalf("header % x of %d bytes", data[:12], len(data))
+	}
+
+	//a JSON chunk and a BIN chunk, 4 byte aligned, that end the file
+	var chunks [][]byte
+	for offset, kind := 12, 0; offset < len(data); kind++ {
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		want := []uint32{0x4E4F534A, 0x004E4942}[kind%2]
+		if kind > 1 || binary.LittleEndian.Uint32(data[offset+4:]) != want {
+			t.Fatalf("chunk %d at %d is not the %x chunk", kind, offset, want)
+		}
+		if length%4 != 0 || offset%4 != 0 || offset+8+length > len(data) {
+			t.Fatalf("chunk %d at %d of %d bytes in %d", kind, offset, length, len(data))
+		}
+		chunks = append(chunks, data[offset+8:offset+8+length])
+		offset += 8 + length
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("%d chunks, want 2", len(chunks))
+	}
+
+	var document gltfDocument
+	if err := json.Unmarshal(chunks[0], &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Buffers) != 1 || document.Buffers[0].URI != "" || document.Buffers[0].ByteLength > len(chunks[1]) ||
+		len(chunks[1])-document.Buffers[0].ByteLength >= 4 {
+		t.Fatalf("buffers %+v for a BIN chunk of %d bytes", document.Buffers, len(chunks[1]))
+	}
+
+	//the positions and the indices are where the accessors say
+	primitive := document.Meshes[0].Primitives[0]
+	accessor := document.Accessors[primitive.Attributes["POSITION"]]
+	view := document.BufferViews[accessor.BufferView]
+	positions := make([]float32, accessor.Count*3)
+	binary.Read(bytes.NewReader(chunks[1][view.ByteOffset:view.ByteOffset+view.ByteLength]), binary.LittleEndian, positions)
+	for i := range mesh.positions {
+		want := yUp(mesh.positions[i])
+		if positions[i*3] != float32(want.X) || positions[i*3+1] != float32(want.Y) || positions[i*3+2] != float32(want.Z) {
+			t.Errorf("position %d is %v, want %v", i, positions[i*3:i*3+3], want)
+		}
+	}
+	if accessor.Min[1] != 0 || accessor.Max[1] != 4 {
+		t.Errorf("heights between %v and %v, want 0 and 4", accessor.Min[1], accessor.Max[1])
+	}
+	accessor = document.Accessors[primitive.Indices]
+	view = document.BufferViews[accessor.BufferView]
+	indices := make([]uint32, accessor.Count)
+	binary.Read(bytes.NewReader(chunks[1][view.ByteOffset:view.ByteOffset+view.ByteLength]), binary.LittleEndian, indices)
+	if accessor.ComponentType != gltfUnsignedInt || len(indices) != len(mesh.indices) {
+		t.Fatalf("%d indices of type %d, want %d", len(indices), accessor.ComponentType, len(mesh.indices))
+	}
+	for i, index := range indices {
+		if int(index) != mesh.indices[i] {
+			t.Errorf("index %d is %d, want %d", i, index, mesh.indices[i])
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//screenPoint is where a point of the world lands in the camera image
+type screenPoint struct {
+	PixelX, PixelY float64 //in the camera image, through the lens
+	Distance       float64 //metres in front of the camera, along its view
+	InFrame        bool
+	Occluded       bool
+}
+
+//depthBuffer is the depth of the terrain in every pixel of a render, as the context left it:
+//0 on the near plane to 1 on the far plane, more than 1 where there is no terrain
+type depthBuffer struct {
+	width, height, scale int
+	depth                []float64
+}
+
+//a point may be this share of its distance behind the terrain of its pixel and still be seen;
+//a point on the ground is as far as the ground, give or take the rasterization of the primitive
+const occlusionTolerance = 0.01
+
+//renderDepth renders the terrain from the camera only for the depth buffer
+func renderDepth(maxVert float64, cameraPerspective fauxgl.Matrix, render site.RenderConfig,
+	compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) *depthBuffer {
+
+	triangles := modelTriangles(maxVert, compositeVector, primitiveIndex)
+	contextRender := fauxgl.NewContext(render.Width*render.Scale, render.Height*render.Scale)
+	contextRender.SetPickingFlag(false)
+	contextRender.Shader = fauxgl.NewSolidColorShader(cameraPerspective, color)
+	contextRender.DrawMesh(fauxgl.NewTriangleMesh(triangles))
+	return &depthBuffer{
+		width:  contextRender.Width,
+		height: contextRender.Height,
+		scale:  render.Scale,
+		depth:  contextRender.DepthBuffer,
+	}
+}
+
+//eyeDistance turns a depth of the buffer back into the distance along the view in the normalized model
+func eyeDistance(depth float64) float64 {
+	ndc := 2*depth - 1
+	return 2 * site.Near * site.Far / (site.Far + site.Near - ndc*(site.Far-site.Near))
+}
+
+//projectGCS is a pick the other way round: the pixel latitude, longitude and elevation (metres, the
+//datum of the terrain) are seen at, through the same camera matrix and lens. The depth buffer of the
+//terrain tells if it is hidden; without one nothing is
+func projectGCS(latitude, longitude, elevation float64, properties *site.ModelProperties, maxVert float64,
+	cameraPerspective fauxgl.Matrix, intrinsics site.IntrinsicsConfig, render site.RenderConfig, depth *depthBuffer) screenPoint {
+
+	vector := &site.MapVector{Latitude: latitude, Longtitude: longitude, Elevation: elevation}
+	properties.Localize(vector)
+	clip := cameraPerspective.MulPositionW(fauxgl.Vector{
+		X: vector.VertX / maxVert,
+		Y: vector.VertY / maxVert,
+		Z: vector.VertZ / maxVert,
+	})
+	point := screenPoint{Distance: clip.W * maxVert}
+	if clip.W <= 0 {
+		//behind the camera
+		return point
+	}
+	ndc := clip.Vector().DivScalar(clip.W)
+
+	//pixel centres are at .5 in the render; the lens moves the pixel of the pinhole render
+	u := (ndc.X+1)/2*float64(render.Width) - 0.5
+	v := (1-ndc.Y)/2*float64(render.Height) - 0.5
+	point.PixelX, point.PixelY = intrinsics.Distort(u, v)
+	point.InFrame = ndc.Z >= -1 && ndc.Z <= 1 &&
+		point.PixelX >= -0.5 && point.PixelX < float64(render.Width)-0.5 &&
+		point.PixelY >= -0.5 && point.PixelY < float64(render.Height)-0.5
+
+	if depth == nil {
+		return point
+	}
+	x, y := int((u+0.5)*float64(depth.scale)), int((v+0.5)*float64(depth.scale))
+	if u < -0.5 || v < -0.5 || x >= depth.width || y >= depth.height {
+		return point
+	}
+	if terrain := depth.depth[y*depth.width+x]; terrain <= 1 {
+		point.Occluded = eyeDistance(terrain) < clip.W*(1-occlusionTolerance)
+	}
+	return point
+}
+
+//projectCommand is "2DGCS project -points assets.csv": the pixels of the camera image known assets
+//are at, with whether they are in the frame and whether the terrain hides them
+func projectCommand(args []string) {
+	commandFlags := flag.NewFlagSet("project", flag.ExitOnError)
+	pointsFile := commandFlags.String("points", "",
+		"CSV of points with latitude, longitude and elevation columns, and optionally name")
+	output := commandFlags.String("o", "", fmt.Sprintf("CSV the pixels are written to; standard output when empty. "+
+		"A point is occluded where the terrain of its pixel is nearer than it by more than %g%% of its distance",
+		occlusionTolerance*100))
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+	if *pointsFile == "" {
+		log.Fatal("fatal error: -points is required")
+	}
+
+	names, points, err := readPoints(*pointsFile)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+
+	//the whole model hides the points, not a level of detail of it
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	view, err := model.cameraView(false)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	scene := model.scene
+	depth := renderDepth(view.maxVert, view.cameraPerspective, scene.Render, view.compositeVector, view.primitiveIndex)
+
+	writer := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+		defer file.Close()
+		writer = file
+	}
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"name", "latitude", "longitude", "elevation", "pixelX", "pixelY", "distance", "inFrame", "occluded"})
+	for i, point := range points {
+		projected := projectGCS(point.Latitude, point.Longtitude, point.Elevation, model.properties, view.maxVert,
+			view.cameraPerspective, scene.Camera.Intrinsics, scene.Render, depth)
+		csvWriter.Write([]string{
+			names[i],
+			strconv.FormatFloat(point.Latitude, 'f', 7, 64),
+			strconv.FormatFloat(point.Longtitude, 'f', 7, 64),
+			strconv.FormatFloat(point.Elevation, 'f', 3, 64),
+			strconv.FormatFloat(projected.PixelX, 'f', 2, 64),
+			strconv.FormatFloat(projected.PixelY, 'f', 2, 64),
+			strconv.FormatFloat(projected.Distance, 'f', 3, 64),
+			strconv.FormatBool(projected.InFrame),
+			strconv.FormatBool(projected.Occluded),
+		})
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+}
+
+//readPoints reads named points; a point without a name is named by its line
+func readPoints(path string) ([]string, []*site.MapVector, error) {
+	var names []string
+	var points []*site.MapVector
+	err := readCSVColumns(path, []csvColumn{nameColumn, latColumn, lngColumn, elevationColumn}, func(line int, fields []string) error {
+		values, err := parseFloats(fields[1:])
+		if err != nil {
+			return err
+		}
+		if fields[0] != "" {
+			names = append(names, fields[0])
+		} else {
+			names = append(names, strconv.Itoa(line))
+		}
+		points = append(points, &site.MapVector{Latitude: values[0], Longtitude: values[1], Elevation: values[2]})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return names, points, nil
+}
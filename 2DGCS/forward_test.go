@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestProjectGCS(t *testing.T) {
+	properties := &site.ModelProperties{OriginLatitude: 43.45, OriginLongitude: -80.49, OriginElevation: 300, MaxVert: 100}
+	render := site.RenderConfig{Width: 160, Height: 120, Scale: 2, Fovy: 60}
+	//level, 10m over the origin, looking west; north is to the right of the image
+	camera := site.CameraConfig{Latitude: 43.45, Longitude: -80.49, Elevation: 300, Height: 10}
+	location := &site.MapVector{Latitude: camera.Latitude, Longtitude: camera.Longitude, Elevation: camera.Elevation}
+	properties.Localize(location)
+	cameraPerspective := site.CameraModel(properties.MaxVert, location, camera, render)
+
+	//a pinhole of the field of view, in pixels, with pixel centres at .5
+	focal := float64(render.Height) / 2 / math.Tan(site.DegToRad(render.Fovy)/2)
+	centreX, centreY := float64(render.Width)/2-0.5, float64(render.Height)/2-0.5
+
+	//the points are ahead, right and up of the camera, in metres; model X is north, Y down and Z west
+	tests := []struct {
+		name             string
+		ahead, right, up float64
+		pixelX, pixelY   float64
+		inFrame          bool
+	}{
+		{"ahead", 50, 0, 0, centreX, centreY, true},
+		{"right and below", 50, 10, -5, centreX + focal*10/50, centreY + focal*5/50, true},
+		{"left and above", 20, -8, 3, centreX - focal*8/20, centreY - focal*3/20, true},
+		{"off the side", 50, 60, 0, centreX + focal*60/50, centreY, false},
+		{"past the far plane", site.Far*properties.MaxVert + 100, 0, 0, centreX, centreY, false},
+		{"behind", -20, 0, 0, 0, 0, false},
+	}
+	gcs := func(ahead, right, up float64) (float64, float64, float64) {
+		return properties.GCS(location.VertX+right, location.VertY-camera.Height-up, location.VertZ+ahead)
+	}
+	for _, test := range tests {
+		latitude, longitude, elevation := gcs(test.ahead, test.right, test.up)
+		point := projectGCS(latitude, longitude, elevation, properties, properties.MaxVert,
+			cameraPerspective, camera.Intrinsics, render, nil)
+		if point.InFrame != test.inFrame || point.Occluded || math.Abs(point.Distance-test.ahead) > 1e-6 {
+			t.Errorf("%s: in frame %t, occluded %t at %.6f metres, want %t, false at %v",
+				test.name, point.InFrame, point.Occluded, point.Distance, test.inFrame, test.ahead)
+		}
+		if test.ahead > 0 && (math.Abs(point.PixelX-test.pixelX) > 1e-6 || math.Abs(point.PixelY-test.pixelY) > 1e-6) {
+			t.Errorf("%s: pixel %.6f, %.6f, want %.6f, %.6f", test.name, point.PixelX, point.PixelY, test.pixelX, test.pixelY)
+		}
+	}
+
+	//the depth buffer of terrain at a distance, more than 1 where there is none
+	depthOf := func(distance float64) *depthBuffer {
+		depth := &depthBuffer{width: render.Width * render.Scale, height: render.Height * render.Scale, scale: render.Scale}
+		depth.depth = make([]float64, depth.width*depth.height)
+		for i := range depth.depth {
+			depth.depth[i] = 2
+			if distance > 0 {
+				depth.depth[i] = windowDepth(distance, properties.MaxVert)
+			}
+		}
+		return depth
+	}
+
+	//the point ahead, 50 metres away, behind terrain at different distances
+	latitude, longitude, elevation := gcs(50, 0, 0)
+	for _, test := range []struct {
+		name     string
+		terrain  float64 //0 for none
+		occluded bool
+	}{
+		{"no terrain", 0, false},
+		{"on the ground", 50, false},
+		{"within the tolerance of the ground", 50 * (1 - occlusionTolerance/2), false},
+		{"behind a hill", 50 * (1 - 2*occlusionTolerance), true},
+		{"behind a wall", 5, true},
+		{"before the terrain", 80, false},
+	} {
+		point := projectGCS(latitude, longitude, elevation, properties, properties.MaxVert,
+			cameraPerspective, camera.Intrinsics, render, depthOf(test.terrain))
+		if point.Occluded != test.occluded || !point.InFrame {
+			t.Errorf("%s: occluded %t, in frame %t, want %t, true", test.name, point.Occluded, point.InFrame, test.occluded)
+		}
+	}
+
+	//only the terrain of the pixel of the point hides it; the buffer is of the render scale
+	depth := depthOf(0)
+	hidden := tests[1]
+	x := int((hidden.pixelX + 0.5) * float64(render.Scale))
+	y := int((hidden.pixelY + 0.5) * float64(render.Scale))
+	depth.depth[y*depth.width+x] = windowDepth(20, properties.MaxVert)
+	for _, test := range tests[:3] {
+		latitude, longitude, elevation := gcs(test.ahead, test.right, test.up)
+		point := projectGCS(latitude, longitude, elevation, properties, properties.MaxVert,
+			cameraPerspective, camera.Intrinsics, render, depth)
+		if point.Occluded != (test.name == hidden.name) {
+			t.Errorf("%s: occluded %t by terrain in pixel %d, %d of the depth buffer", test.name, point.Occluded, x, y)
+		}
+	}
+}
+
+//windowDepth is the depth the context leaves for terrain metres along the view, 0 on the near plane
+//to 1 on the far plane of the normalized model
+func windowDepth(metres, maxVert float64) float64 {
+	z := metres / maxVert
+	return ((site.Far+site.Near-2*site.Near*site.Far/z)/(site.Far-site.Near) + 1) / 2
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//TIFF and GeoTIFF tags used by the DEM reader and the raster writer
+const (
+	tagImageWidth        = 256
+	tagImageLength       = 257
+	tagBitsPerSample     = 258
+	tagCompression       = 259
+	tagPhotometric       = 262
+	tagStripOffsets      = 273
+	tagSamplesPerPixel   = 277
+	tagRowsPerStrip      = 278
+	tagStripByteCounts   = 279
+	tagPlanarConfig      = 284
+	tagPredictor         = 317
+	tagTileWidth         = 322
+	tagTileLength        = 323
+	tagTileOffsets       = 324
+	tagTileByteCounts    = 325
+	tagExtraSamples      = 338
+	tagSampleFormat      = 339
+	tagModelPixelScale   = 33550
+	tagModelTiepoint     = 33922
+	tagModelTransform    = 34264
+	tagGeoKeyDirectory   = 34735
+	tagGDALNoData        = 42113
+	geoKeyModelType      = 1024
+	geoKeyRasterType     = 1025
+	geoKeyGeographicType = 2048
+	modelTypeProjected   = 1
+	modelTypeGeographic  = 2
+	rasterPixelIsArea    = 1
+	rasterPixelIsPoint   = 2
+	gcsWGS84             = 4326
+	sampleFormatUint     = 1
+	sampleFormatInt      = 2
+	sampleFormatFloat    = 3
+	compressionNone      = 1
+	compressionDeflate   = 8
+	compressionDeflatePK = 32946
+)
+
+//tiffField is one IFD entry, kept as raw bytes until its type is known
+type tiffField struct {
+	fieldType uint16
+	count     uint32
+	data      []byte
+}
+
+type tiffReader struct {
+	path   string
+	data   []byte
+	order  binary.ByteOrder
+	fields map[uint16]tiffField
+}
+
+//readGeoTIFF reads the first image of a single band, geographic (lat/lng) GeoTIFF
+func readGeoTIFF(path string) (*demRaster, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTIFFReader(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := t.uint(tagImageWidth, 0), t.uint(tagImageLength, 0)
+	if width < 2 || height < 2 {
+		return nil, fmt.Errorf("geotiff: %s has no image", path)
+	}
+	if samples := t.uint(tagSamplesPerPixel, 1); samples != 1 {
+		return nil, fmt.Errorf("geotiff: %s has %d bands, only single band DEMs are supported", path, samples)
+	}
+
+	raster := &demRaster{
+		name:  path,
+		rows:  height,
+		cols:  width,
+		posts: make([]float64, width*height),
+	}
+	if err := t.georeference(raster); err != nil {
+		return nil, err
+	}
+	if noData, ok := t.ascii(tagGDALNoData); ok {
+		value, err := strconv.ParseFloat(strings.TrimSpace(noData), 64)
+		if err == nil {
+			raster.noData = value
+			raster.hasNoData = true
+		}
+	}
+	if err := t.decode(raster); err != nil {
+		return nil, err
+	}
+	return raster, nil
+}
+
+func newTIFFReader(path string, data []byte) (*tiffReader, error) {
+	t := &tiffReader{path: path, data: data, fields: map[uint16]tiffField{}}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("geotiff: %s is not a TIFF file", path)
+	}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("geotiff: %s is not a TIFF file", path)
+	}
+	if magic := t.order.Uint16(data[2:]); magic != 42 {
+		if magic == 43 {
+			return nil, fmt.Errorf("geotiff: %s is a BigTIFF, which is not supported", path)
+		}
+		return nil, fmt.Errorf("geotiff: %s is not a TIFF file", path)
+	}
+
+	offset := int(t.order.Uint32(data[4:]))
+	if offset+2 > len(data) {
+		return nil, fmt.Errorf("geotiff: %s is truncated", path)
+	}
+	entries := int(t.order.Uint16(data[offset:]))
+	if offset+2+entries*12 > len(data) {
+		return nil, fmt.Errorf("geotiff: %s is truncated", path)
+	}
+	for i := 0; i < entries; i++ {
+		entry := data[offset+2+i*12:]
+		field := tiffField{
+			fieldType: t.order.Uint16(entry[2:]),
+			count:     t.order.Uint32(entry[4:]),
+		}
+		size := int(field.count) * tiffTypeSize(field.fieldType)
+		if size <= 4 {
+			field.data = entry[8 : 8+size]
+		} else {
+			valueOffset := int(t.order.Uint32(entry[8:]))
+			if valueOffset+size > len(data) {
+				return nil, fmt.Errorf("geotiff: %s is truncated", path)
+			}
+			field.data = data[valueOffset : valueOffset+size]
+		}
+		t.fields[t.order.Uint16(entry)] = field
+	}
+	return t, nil
+}
+
+func tiffTypeSize(fieldType uint16) int {
+	switch fieldType {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
+
+//uints reads a BYTE, SHORT or LONG field as ints
+func (t *tiffReader) uints(tag uint16) []int {
+	field, ok := t.fields[tag]
+	if !ok {
+		return nil
+	}
+	values := make([]int, field.count)
+	for i := range values {
+		switch field.fieldType {
+		case 1:
+			values[i] = int(field.data[i])
+		case 3:
+			values[i] = int(t.order.Uint16(field.data[i*2:]))
+		case 4:
+			values[i] = int(t.order.Uint32(field.data[i*4:]))
+		}
+	}
+	return values
+}
+
+func (t *tiffReader) uint(tag uint16, defaultValue int) int {
+	if values := t.uints(tag); len(values) > 0 {
+		return values[0]
+	}
+	return defaultValue
+}
+
+//doubles reads a DOUBLE field
+func (t *tiffReader) doubles(tag uint16) []float64 {
+	field, ok := t.fields[tag]
+	if !ok || field.fieldType != 12 {
+		return nil
+	}
+	values := make([]float64, field.count)
+	for i := range values {
+		values[i] = math.Float64frombits(t.order.Uint64(field.data[i*8:]))
+	}
+	return values
+}
+
+func (t *tiffReader) ascii(tag uint16) (string, bool) {
+	field, ok := t.fields[tag]
+	if !ok || field.fieldType != 2 {
+		return "", false
+	}
+	return strings.TrimRight(string(field.data), "\x00"), true
+}
+
+//geoKey looks a short value up in the GeoKeyDirectory
+func (t *tiffReader) geoKey(key int) (int, bool) {
+	directory := t.uints(tagGeoKeyDirectory)
+	if len(directory) < 4 {
+		return 0, false
+	}
+	for i := 0; i < directory[3] && 4+i*4+3 < len(directory); i++ {
+		entry := directory[4+i*4:]
+		if entry[0] == key && entry[1] == 0 {
+			return entry[3], true
+		}
+	}
+	return 0, false
+}
+
+//georeference places the raster posts; pixel-is-area images have their posts in the pixel centres
+func (t *tiffReader) georeference(raster *demRaster) error {
+	if modelType, ok := t.geoKey(geoKeyModelType); ok && modelType == modelTypeProjected {
+		return fmt.Errorf("geotiff: %s is projected; reproject it to lat/lng (EPSG:4326) first", t.path)
+	}
+	centre := 0.5
+	if rasterType, ok := t.geoKey(geoKeyRasterType); ok && rasterType == rasterPixelIsPoint {
+		centre = 0
+	}
+
+	scale := t.doubles(tagModelPixelScale)
+	tiepoint := t.doubles(tagModelTiepoint)
+	transform := t.doubles(tagModelTransform)
+	switch {
+	case len(scale) >= 2 && len(tiepoint) >= 6:
+		raster.lngStep = scale[0]
+		raster.latStep = scale[1]
+		raster.west = tiepoint[3] + (centre-tiepoint[0])*scale[0]
+		raster.north = tiepoint[4] - (centre-tiepoint[1])*scale[1]
+	case len(transform) >= 16:
+		if transform[1] != 0 || transform[4] != 0 {
+			return fmt.Errorf("geotiff: %s is rotated, which is not supported", t.path)
+		}
+		raster.lngStep = transform[0]
+		raster.latStep = -transform[5]
+		raster.west = transform[3] + centre*transform[0]
+		raster.north = transform[7] + centre*transform[5]
+	default:
+		return fmt.Errorf("geotiff: %s has no georeference", t.path)
+	}
+	if raster.lngStep <= 0 || raster.latStep <= 0 {
+		return fmt.Errorf("geotiff: %s is not north-up", t.path)
+	}
+	return nil
+}
+
+//decode reads the strips or tiles of the image into the raster posts
+func (t *tiffReader) decode(raster *demRaster) error {
+	bits := t.uint(tagBitsPerSample, 1)
+	format := t.uint(tagSampleFormat, sampleFormatUint)
+	compression := t.uint(tagCompression, compressionNone)
+	predictor := t.uint(tagPredictor, 1)
+	if compression != compressionNone && compression != compressionDeflate && compression != compressionDeflatePK {
+		return fmt.Errorf("geotiff: %s uses compression %d; only none and deflate are supported", t.path, compression)
+	}
+	if predictor != 1 && (predictor != 2 || format == sampleFormatFloat) {
+		return fmt.Errorf("geotiff: %s uses predictor %d, which is not supported", t.path, predictor)
+	}
+	sampleSize := bits / 8
+	if bits%8 != 0 || sampleSize == 0 {
+		return fmt.Errorf("geotiff: %s has %d bit samples, which is not supported", t.path, bits)
+	}
+
+	//strips are tiles as wide as the image
+	chunkWidth, chunkHeight := raster.cols, t.uint(tagRowsPerStrip, raster.rows)
+	offsets, counts := t.uints(tagStripOffsets), t.uints(tagStripByteCounts)
+	if _, tiled := t.fields[tagTileWidth]; tiled {
+		chunkWidth, chunkHeight = t.uint(tagTileWidth, 0), t.uint(tagTileLength, 0)
+		offsets, counts = t.uints(tagTileOffsets), t.uints(tagTileByteCounts)
+	}
+	if chunkWidth == 0 || chunkHeight == 0 || len(offsets) == 0 || len(offsets) != len(counts) {
+		return fmt.Errorf("geotiff: %s has no image data", t.path)
+	}
+	chunksAcross := (raster.cols + chunkWidth - 1) / chunkWidth
+
+	for chunk, offset := range offsets {
+		if offset+counts[chunk] > len(t.data) {
+			return fmt.Errorf("geotiff: %s is truncated", t.path)
+		}
+		block := t.data[offset : offset+counts[chunk]]
+		if compression != compressionNone {
+			inflater, err := zlib.NewReader(bytes.NewReader(block))
+			if err != nil {
+				return fmt.Errorf("geotiff: %s: %s", t.path, err)
+			}
+			block, err = ioutil.ReadAll(inflater)
+			inflater.Close()
+			if err != nil {
+				return fmt.Errorf("geotiff: %s: %s", t.path, err)
+			}
+		}
+
+		originRow := (chunk / chunksAcross) * chunkHeight
+		originCol := (chunk % chunksAcross) * chunkWidth
+		for y := 0; y < chunkHeight && originRow+y < raster.rows; y++ {
+			start := y * chunkWidth * sampleSize
+			if start+chunkWidth*sampleSize > len(block) {
+				return fmt.Errorf("geotiff: %s has a short image block", t.path)
+			}
+			line := block[start : start+chunkWidth*sampleSize]
+			if predictor == 2 {
+				undoHorizontalPredictor(line, sampleSize, t.order)
+			}
+			for x := 0; x < chunkWidth && originCol+x < raster.cols; x++ {
+				raster.posts[(originRow+y)*raster.cols+originCol+x] = t.sample(line[x*sampleSize:], bits, format)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *tiffReader) sample(b []byte, bits, format int) float64 {
+	switch {
+	case format == sampleFormatFloat && bits == 32:
+		return float64(math.Float32frombits(t.order.Uint32(b)))
+	case format == sampleFormatFloat && bits == 64:
+		return math.Float64frombits(t.order.Uint64(b))
+	case format == sampleFormatInt && bits == 8:
+		return float64(int8(b[0]))
+	case format == sampleFormatInt && bits == 16:
+		return float64(int16(t.order.Uint16(b)))
+	case format == sampleFormatInt && bits == 32:
+		return float64(int32(t.order.Uint32(b)))
+	case bits == 8:
+		return float64(b[0])
+	case bits == 16:
+		return float64(t.order.Uint16(b))
+	case bits == 32:
+		return float64(t.order.Uint32(b))
+	}
+	return math.NaN()
+}
+
+//undoHorizontalPredictor adds each sample to the one before it along the row
+func undoHorizontalPredictor(line []byte, sampleSize int, order binary.ByteOrder) {
+	for i := sampleSize; i+sampleSize <= len(line); i += sampleSize {
+		switch sampleSize {
+		case 1:
+			line[i] += line[i-1]
+		case 2:
+			order.PutUint16(line[i:], order.Uint16(line[i:])+order.Uint16(line[i-2:]))
+		case 4:
+			order.PutUint32(line[i:], order.Uint32(line[i:])+order.Uint32(line[i-4:]))
+		}
+	}
+}
+
+//writeFloatTIFF writes a float64 raster of bands samples per pixel, interleaved by pixel and row
+//by row; NaN is no data. extra are more fields, like the georeference of a GeoTIFF
+func writeFloatTIFF(path string, width, height, bands int, samples []float64, extra map[uint16]tiffField) error {
+	if len(samples) != width*height*bands {
+		return fmt.Errorf("tiff: %s: %d samples for %dx%d pixels of %d bands", path, len(samples), width, height, bands)
+	}
+	pixels := make([]byte, len(samples)*8)
+	for i, value := range samples {
+		binary.LittleEndian.PutUint64(pixels[i*8:], math.Float64bits(value))
+	}
+	fields := map[uint16]tiffField{
+		tagGDALNoData: {fieldType: 2, count: 4, data: []byte("nan\x00")},
+	}
+	for tag, field := range extra {
+		fields[tag] = field
+	}
+	//black is zero; the bands after the first are not colours
+	return writeTIFF(path, width, height, bands, 64, sampleFormatFloat, 1, make([]int, bands-1), pixels, fields)
+}
+
+//writeImageTIFF writes an image as 8-bit red, green, blue and alpha
+func writeImageTIFF(path string, picture *image.NRGBA, extra map[uint16]tiffField) error {
+	bounds := picture.Bounds()
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*4)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := picture.PixOffset(bounds.Min.X, y)
+		pixels = append(pixels, picture.Pix[row:row+bounds.Dx()*4]...)
+	}
+	//RGB, with alpha that is not premultiplied
+	return writeTIFF(path, bounds.Dx(), bounds.Dy(), 4, 8, sampleFormatUint, 2, []int{2}, pixels, extra)
+}
+
+//writeTIFF writes pixels, interleaved by pixel and row by row, as an uncompressed little endian
+//TIFF of a strip per row; extraSamples tells what the bands beyond the photometric ones are
+func writeTIFF(path string, width, height, bands, bits, sampleFormat, photometric int, extraSamples []int,
+	pixels []byte, extra map[uint16]tiffField) error {
+
+	shorts := func(values ...int) tiffField {
+		data := make([]byte, len(values)*2)
+		for i, value := range values {
+			binary.LittleEndian.PutUint16(data[i*2:], uint16(value))
+		}
+		return tiffField{fieldType: 3, count: uint32(len(values)), data: data}
+	}
+	longs := func(values ...int) tiffField {
+		data := make([]byte, len(values)*4)
+		for i, value := range values {
+			binary.LittleEndian.PutUint32(data[i*4:], uint32(value))
+		}
+		return tiffField{fieldType: 4, count: uint32(len(values)), data: data}
+	}
+	repeat := func(value, count int) []int {
+		values := make([]int, count)
+		for i := range values {
+			values[i] = value
+		}
+		return values
+	}
+
+	rowSize := width * bands * bits / 8
+	offsets := make([]int, height)
+	for row := range offsets {
+		offsets[row] = 8 + row*rowSize
+	}
+	fields := map[uint16]tiffField{
+		tagImageWidth:      longs(width),
+		tagImageLength:     longs(height),
+		tagBitsPerSample:   shorts(repeat(bits, bands)...),
+		tagCompression:     shorts(compressionNone),
+		tagPhotometric:     shorts(photometric),
+		tagStripOffsets:    longs(offsets...),
+		tagSamplesPerPixel: shorts(bands),
+		tagRowsPerStrip:    longs(1),
+		tagStripByteCounts: longs(repeat(rowSize, height)...),
+		tagPlanarConfig:    shorts(1), //interleaved by pixel
+		tagSampleFormat:    shorts(repeat(sampleFormat, bands)...),
+	}
+	if len(extraSamples) > 0 {
+		fields[tagExtraSamples] = shorts(extraSamples...)
+	}
+	for tag, field := range extra {
+		fields[tag] = field
+	}
+	tags := make([]int, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	//header, the strips, the directory and then the field values too long for it
+	directory := 8 + height*rowSize
+	data := make([]byte, directory+2+len(tags)*12+4)
+	copy(data, "II*\x00")
+	binary.LittleEndian.PutUint32(data[4:], uint32(directory))
+	copy(data[8:], pixels)
+	binary.LittleEndian.PutUint16(data[directory:], uint16(len(tags)))
+	for i, tag := range tags {
+		field := fields[uint16(tag)]
+		entry := data[directory+2+i*12:]
+		binary.LittleEndian.PutUint16(entry, uint16(tag))
+		binary.LittleEndian.PutUint16(entry[2:], field.fieldType)
+		binary.LittleEndian.PutUint32(entry[4:], field.count)
+		if len(field.data) <= 4 {
+			copy(entry[8:12], field.data)
+			continue
+		}
+		if len(data)%2 == 1 {
+			data = append(data, 0) //values start on a word boundary
+		}
+		binary.LittleEndian.PutUint32(entry[8:], uint32(len(data)))
+		data = append(data, field.data...)
+	}
+
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//geoTIFFFields georeference a north-up, or rotated, raster in WGS84 latitude and longitude; the
+//transform is that of a world file: longitude and latitude of a pixel centre are
+//	lng = a*column + b*row + c, lat = d*column + e*row + f
+func geoTIFFFields(a, b, c, d, e, f float64) map[uint16]tiffField {
+	doubles := func(values ...float64) tiffField {
+		data := make([]byte, len(values)*8)
+		for i, value := range values {
+			binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(value))
+		}
+		return tiffField{fieldType: 12, count: uint32(len(values)), data: data}
+	}
+	keys := []int{
+		1, 1, 0, 3, //version 1.1.0, 3 keys
+		geoKeyModelType, 0, 1, modelTypeGeographic,
+		geoKeyRasterType, 0, 1, rasterPixelIsArea,
+		geoKeyGeographicType, 0, 1, gcsWGS84,
+	}
+	data := make([]byte, len(keys)*2)
+	for i, key := range keys {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(key))
+	}
+	//pixel is area: the raster space of the transform has its origin at the corner of the first pixel
+	return map[uint16]tiffField{
+		tagModelTransform: doubles(
+			a, b, 0, c-a/2-b/2,
+			d, e, 0, f-d/2-e/2,
+			0, 0, 0, 0,
+			0, 0, 0, 1),
+		tagGeoKeyDirectory: {fieldType: 3, count: uint32(len(keys)), data: data},
+	}
+}
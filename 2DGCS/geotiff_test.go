@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+//testField is a field of a TIFF built by buildTIFF; text is written as ASCII, values in the type
+type testField struct {
+	tag, fieldType uint16
+	values         []float64
+	text           string
+}
+
+//buildTIFF lays out a TIFF of the byte order: the header, the image blocks, the directory and
+//the values too long for it. The offsets and byte counts of the blocks are filled in as LONGs
+func buildTIFF(order binary.ByteOrder, fields []testField, offsetsTag, countsTag uint16, blocks [][]byte) []byte {
+	data := []byte("II*\x00\x00\x00\x00\x00")
+	if order == binary.BigEndian {
+		data = []byte("MM\x00*\x00\x00\x00\x00")
+	}
+	var offsets, counts []float64
+	for _, block := range blocks {
+		offsets = append(offsets, float64(len(data)))
+		counts = append(counts, float64(len(block)))
+		data = append(data, block...)
+	}
+	fields = append(fields, testField{tag: offsetsTag, fieldType: 4, values: offsets},
+		testField{tag: countsTag, fieldType: 4, values: counts})
+	sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+
+	directory := len(data)
+	order.PutUint32(data[4:], uint32(directory))
+	data = append(data, make([]byte, 2+len(fields)*12+4)...)
+	order.PutUint16(data[directory:], uint16(len(fields)))
+	for i, field := range fields {
+		var value []byte
+		count := len(field.values)
+		switch field.fieldType {
+		case 2:
+			value = append([]byte(field.text), 0)
+			count = len(value)
+		case 3:
+			value = make([]byte, count*2)
+			for k, v := range field.values {
+				order.PutUint16(value[k*2:], uint16(v))
+			}
+		case 4:
+			value = make([]byte, count*4)
+			for k, v := range field.values {
+				order.PutUint32(value[k*4:], uint32(v))
+			}
+		case 12:
+			value = make([]byte, count*8)
+			for k, v := range field.values {
+				order.PutUint64(value[k*8:], math.Float64bits(v))
+			}
+		}
+		entry := data[directory+2+i*12:]
+		order.PutUint16(entry, field.tag)
+		order.PutUint16(entry[2:], field.fieldType)
+		order.PutUint32(entry[4:], uint32(count))
+		if len(value) <= 4 {
+			copy(entry[8:12], value)
+			continue
+		}
+		order.PutUint32(entry[8:], uint32(len(data)))
+		data = append(data, value...)
+	}
+	return data
+}
+
+func TestReadGeoTIFF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geotiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//3x2 float32 in a strip per row, pixel is area by default: the first post is in the middle of
+	//the first pixel, a tenth of a degree from the north-west corner at -80.5, 43.5
+	floats := make([][]byte, 2)
+	for row := range floats {
+		floats[row] = make([]byte, 3*4)
+		for col := 0; col < 3; col++ {
+			binary.LittleEndian.PutUint32(floats[row][col*4:], math.Float32bits(float32(10*(3*row+col))))
+		}
+	}
+	stripped := buildTIFF(binary.LittleEndian, []testField{
+		{tag: tagImageWidth, fieldType: 3, values: []float64{3}},
+		{tag: tagImageLength, fieldType: 3, values: []float64{2}},
+		{tag: tagBitsPerSample, fieldType: 3, values: []float64{32}},
+		{tag: tagSampleFormat, fieldType: 3, values: []float64{sampleFormatFloat}},
+		{tag: tagRowsPerStrip, fieldType: 3, values: []float64{1}},
+		{tag: tagModelPixelScale, fieldType: 12, values: []float64{0.1, 0.1, 0}},
+		{tag: tagModelTiepoint, fieldType: 12, values: []float64{0, 0, 0, -80.5, 43.5, 0}},
+	}, tagStripOffsets, tagStripByteCounts, floats)
+
+	//3x3 int16 in 2x2 deflated tiles with the horizontal predictor, big endian, pixel is point
+	//placed by a transform; -9999 is no data
+	var tiles [][]byte
+	for tile := 0; tile < 4; tile++ {
+		line := make([]byte, 2*2)
+		var block bytes.Buffer
+		deflater := zlib.NewWriter(&block)
+		for y := 0; y < 2; y++ {
+			previous := 0
+			for x := 0; x < 2; x++ {
+				row, col := tile/2*2+y, tile%2*2+x
+				value := 0
+				if row < 3 && col < 3 {
+					value = 100*row + col
+				}
+				if row == 2 && col == 2 {
+					value = -9999
+				}
+				binary.BigEndian.PutUint16(line[x*2:], uint16(value-previous))
+				previous = value
+			}
+			deflater.Write(line)
+		}
+		deflater.Close()
+		tiles = append(tiles, block.Bytes())
+	}
+	tiled := buildTIFF(binary.BigEndian, []testField{
+		{tag: tagImageWidth, fieldType: 4, values: []float64{3}},
+		{tag: tagImageLength, fieldType: 4, values: []float64{3}},
+		{tag: tagBitsPerSample, fieldType: 3, values: []float64{16}},
+		{tag: tagSampleFormat, fieldType: 3, values: []float64{sampleFormatInt}},
+		{tag: tagCompression, fieldType: 3, values: []float64{compressionDeflate}},
+		{tag: tagPredictor, fieldType: 3, values: []float64{2}},
+		{tag: tagTileWidth, fieldType: 3, values: []float64{2}},
+		{tag: tagTileLength, fieldType: 3, values: []float64{2}},
+		{tag: tagModelTransform, fieldType: 12, values: []float64{
+			0.5, 0, 0, 2,
+			0, -0.25, 0, 50,
+			0, 0, 0, 0,
+			0, 0, 0, 1}},
+		{tag: tagGeoKeyDirectory, fieldType: 3, values: []float64{
+			1, 1, 0, 2,
+			geoKeyModelType, 0, 1, modelTypeGeographic,
+			geoKeyRasterType, 0, 1, rasterPixelIsPoint}},
+		{tag: tagGDALNoData, fieldType: 2, text: "-9999"},
+	}, tagTileOffsets, tagTileByteCounts, tiles)
+
+	tests := []struct {
+		name             string
+		data             []byte
+		north, west      float64
+		latStep, lngStep float64
+		posts            []float64
+		points           [][3]float64 //lat, lng and the elevation there
+	}{
+		{"float32 strips", stripped, 43.45, -80.45, 0.1, 0.1, []float64{0, 10, 20, 30, 40, 50},
+			[][3]float64{{43.4, -80.4, 20}, {43.35, -80.3, 45}}},
+		{"int16 deflated tiles", tiled, 50, 2, 0.25, 0.5, []float64{0, 1, 2, 100, 101, 102, 200, 201, -9999},
+			[][3]float64{{49.875, 2.25, 50.5}, {49.5, 2.25, 200.5}, {49.75, 2.5, 101}}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "dem.tif")
+		if err := ioutil.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		raster, err := readGeoTIFF(path)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if math.Abs(raster.north-test.north) > 1e-9 || math.Abs(raster.west-test.west) > 1e-9 ||
+			math.Abs(raster.latStep-test.latStep) > 1e-9 || math.Abs(raster.lngStep-test.lngStep) > 1e-9 {
+			t.Errorf("%s: at %v, %v by %v, %v, want %v, %v by %v, %v", test.name, raster.north, raster.west,
+				raster.latStep, raster.lngStep, test.north, test.west, test.latStep, test.lngStep)
+		}
+		if len(raster.posts) != len(test.posts) {
+			t.Errorf("%s: %d posts, want %d", test.name, len(raster.posts), len(test.posts))
+			continue
+		}
+		for i, post := range raster.posts {
+			if post != test.posts[i] {
+				t.Errorf("%s: posts %v, want %v", test.name, raster.posts, test.posts)
+				break
+			}
+		}
+		for _, point := range test.points {
+			if elevation, ok := raster.elevation(point[0], point[1]); !ok || math.Abs(elevation-point[2]) > 1e-6 {
+				t.Errorf("%s: %v, %v at %v %v, want %v", test.name, point[0], point[1], elevation, ok, point[2])
+			}
+		}
+	}
+}
+
+func TestReadGeoTIFFFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geotiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bare := []testField{
+		{tag: tagImageWidth, fieldType: 3, values: []float64{2}},
+		{tag: tagImageLength, fieldType: 3, values: []float64{2}},
+		{tag: tagBitsPerSample, fieldType: 3, values: []float64{8}},
+	}
+	placed := append([]testField{
+		{tag: tagModelPixelScale, fieldType: 12, values: []float64{1, 1, 0}},
+		{tag: tagModelTiepoint, fieldType: 12, values: []float64{0, 0, 0, 10, 10, 0}},
+	}, bare...)
+	with := func(fields []testField, more ...testField) []testField {
+		return append(append([]testField{}, fields...), more...)
+	}
+	pixels := [][]byte{{1, 2, 3, 4}}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a TIFF", []byte("GIF89a\x00\x00")},
+		{"BigTIFF", []byte("II+\x00\x08\x00\x00\x00")},
+		{"truncated", buildTIFF(binary.LittleEndian, placed, tagStripOffsets, tagStripByteCounts, pixels)[:20]},
+		{"no georeference", buildTIFF(binary.LittleEndian, bare, tagStripOffsets, tagStripByteCounts, pixels)},
+		{"projected", buildTIFF(binary.LittleEndian, with(placed, testField{tag: tagGeoKeyDirectory, fieldType: 3,
+			values: []float64{1, 1, 0, 1, geoKeyModelType, 0, 1, modelTypeProjected}}), tagStripOffsets, tagStripByteCounts, pixels)},
+		{"rotated", buildTIFF(binary.LittleEndian, with(bare, testField{tag: tagModelTransform, fieldType: 12,
+			values: []float64{1, 0.1, 0, 0, 0.1, -1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}}), tagStripOffsets, tagStripByteCounts, pixels)},
+		{"south-up", buildTIFF(binary.LittleEndian, with(bare,
+			testField{tag: tagModelPixelScale, fieldType: 12, values: []float64{1, -1, 0}},
+			testField{tag: tagModelTiepoint, fieldType: 12, values: []float64{0, 0, 0, 10, 10, 0}}),
+			tagStripOffsets, tagStripByteCounts, pixels)},
+		{"two bands", buildTIFF(binary.LittleEndian, with(placed, testField{tag: tagSamplesPerPixel, fieldType: 3,
+			values: []float64{2}}), tagStripOffsets, tagStripByteCounts, [][]byte{make([]byte, 8)})},
+		{"LZW", buildTIFF(binary.LittleEndian, with(placed, testField{tag: tagCompression, fieldType: 3,
+			values: []float64{5}}), tagStripOffsets, tagStripByteCounts, pixels)},
+		{"short strip", buildTIFF(binary.LittleEndian, placed, tagStripOffsets, tagStripByteCounts, [][]byte{{1, 2, 3}})},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "dem.tif")
+		if err := ioutil.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readGeoTIFF(path); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+
+	//the fields of the failing files are good once the bad one is left out
+	path := filepath.Join(dir, "dem.tif")
+	if err := ioutil.WriteFile(path, buildTIFF(binary.LittleEndian, placed, tagStripOffsets, tagStripByteCounts, pixels), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if raster, err := readGeoTIFF(path); err != nil {
+		t.Error(err)
+	} else if raster.posts[3] != 4 || raster.north != 9.5 || raster.west != 10.5 {
+		t.Errorf("good file at %v, %v of posts %v", raster.north, raster.west, raster.posts)
+	}
+}
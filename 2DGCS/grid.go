@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//planGrid lays out the samples of the area as a regular grid stored row by row;
+//rows go south to north and columns west to east, edges included
+func planGrid(area site.AreaConfig) (vectors []*site.MapVector, rows, cols int) {
+	south, north := math.Min(area.LatStart, area.LatEnd), math.Max(area.LatStart, area.LatEnd)
+	west, east := math.Min(area.LngStart, area.LngEnd), math.Max(area.LngStart, area.LngEnd)
+
+	rows = site.Round((north-south)/area.ResolutionLat) + 1
+	cols = site.Round((east-west)/area.ResolutionLng) + 1
+	if rows < 2 {
+		rows = 2
+	}
+	if cols < 2 {
+		cols = 2
+	}
+
+	vectors = make([]*site.MapVector, 0, rows*cols)
+	for row := 0; row < rows; row++ {
+		lat := south + float64(row)*(north-south)/float64(rows-1)
+		for col := 0; col < cols; col++ {
+			lng := west + float64(col)*(east-west)/float64(cols-1)
+			vectors = append(vectors, plannedVector(lat, lng))
+		}
+	}
+	return vectors, rows, cols
+}
+
+//triangulateGrid meshes a rows x cols grid stored row by row. Every cell is split
+//into two triangles, both counter-clockwise in (column, row) order; for a grid from
+//planGrid that is counter-clockwise seen from above
+func triangulateGrid(rows, cols int) ([]*site.MapPrimitiveIndex, error) {
+	if rows < 2 || cols < 2 {
+		return nil, fmt.Errorf("triangulate: a %dx%d grid has no cells", rows, cols)
+	}
+
+	primitiveIndex := make([]*site.MapPrimitiveIndex, 0, (rows-1)*(cols-1)*2)
+	for row := 0; row < rows-1; row++ {
+		for col := 0; col < cols-1; col++ {
+			southWest := row*cols + col
+			southEast := southWest + 1
+			northWest := southWest + cols
+			northEast := northWest + 1
+
+			primitiveIndex = append(primitiveIndex,
+				&site.MapPrimitiveIndex{
+					PrimitiveBottom: southWest,
+					PrimitiveTop:    southEast,
+					PrimitiveLeft:   northWest,
+				},
+				&site.MapPrimitiveIndex{
+					PrimitiveBottom: northWest,
+					PrimitiveTop:    southEast,
+					PrimitiveLeft:   northEast,
+				})
+		}
+	}
+	return primitiveIndex, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestTriangulateGrid(t *testing.T) {
+	tests := []struct {
+		rows, cols int
+		fails      bool
+	}{
+		{rows: 2, cols: 2},
+		{rows: 2, cols: 5},
+		{rows: 5, cols: 2},
+		{rows: 3, cols: 3},
+		{rows: 4, cols: 7},
+		{rows: 1, cols: 3, fails: true},
+		{rows: 3, cols: 1, fails: true},
+		{rows: 0, cols: 0, fails: true},
+	}
+	for _, test := range tests {
+		primitiveIndex, err := triangulateGrid(test.rows, test.cols)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%dx%d: no error", test.rows, test.cols)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%dx%d: %s", test.rows, test.cols, err)
+		}
+		if want := 2 * (test.rows - 1) * (test.cols - 1); len(primitiveIndex) != want {
+			t.Errorf("%dx%d: %d triangles, want %d", test.rows, test.cols, len(primitiveIndex), want)
+		}
+
+		//directed edges; a shared edge is walked once each way when the winding is consistent
+		edges := make(map[[2]int]int)
+		used := make(map[int]bool)
+		for _, index := range primitiveIndex {
+			corners := [3]int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft}
+			if area := gridArea(corners, test.cols); area <= 0 {
+				t.Errorf("%dx%d: triangle %v is not counter-clockwise", test.rows, test.cols, corners)
+			}
+			for i, corner := range corners {
+				if corner < 0 || corner >= test.rows*test.cols {
+					t.Fatalf("%dx%d: corner %d outside the grid", test.rows, test.cols, corner)
+				}
+				used[corner] = true
+				edges[[2]int{corner, corners[(i+1)%3]}]++
+			}
+		}
+		if len(used) != test.rows*test.cols {
+			t.Errorf("%dx%d: %d of %d vertices in a triangle", test.rows, test.cols, len(used), test.rows*test.cols)
+		}
+		boundary := 0
+		for edge, count := range edges {
+			if count != 1 {
+				t.Errorf("%dx%d: edge %v walked %d times the same way", test.rows, test.cols, edge, count)
+			}
+			if edges[[2]int{edge[1], edge[0]}] == 0 {
+				boundary++
+			}
+		}
+		if want := 2*(test.rows-1) + 2*(test.cols-1); boundary != want {
+			t.Errorf("%dx%d: %d boundary edges, want %d", test.rows, test.cols, boundary, want)
+		}
+	}
+}
+
+//gridArea is twice the signed area of a triangle of grid indices in (column, row)
+func gridArea(corners [3]int, cols int) int {
+	x := func(i int) int { return corners[i] % cols }
+	y := func(i int) int { return corners[i] / cols }
+	return (x(1)-x(0))*(y(2)-y(0)) - (y(1)-y(0))*(x(2)-x(0))
+}
+
+func TestPlanGrid(t *testing.T) {
+	tests := []struct {
+		name       string
+		area       site.AreaConfig
+		rows, cols int
+	}{
+		{"default area", site.DefaultSceneConfig().Area, 111, 201},
+		{"north-west to south-east", site.AreaConfig{
+			LatStart: 43.45245, LngStart: -80.49600, LatEnd: 43.45135, LngEnd: -80.49400,
+			ResolutionLat: 0.00001, ResolutionLng: 0.00001,
+		}, 111, 201},
+		{"thinner than a sample", site.AreaConfig{
+			LatStart: 43.45135, LngStart: -80.49400, LatEnd: 43.451351, LngEnd: -80.494001,
+			ResolutionLat: 0.00001, ResolutionLng: 0.00001,
+		}, 2, 2},
+		{"resolution not dividing the area", site.AreaConfig{
+			LatStart: 43.45135, LngStart: -80.49400, LatEnd: 43.45245, LngEnd: -80.49600,
+			ResolutionLat: 0.0003, ResolutionLng: 0.0007,
+		}, 5, 4},
+	}
+	for _, test := range tests {
+		vectors, rows, cols := planGrid(test.area)
+		if rows != test.rows || cols != test.cols || len(vectors) != rows*cols {
+			t.Errorf("%s: %dx%d grid of %d vectors, want %dx%d", test.name, rows, cols, len(vectors), test.rows, test.cols)
+			continue
+		}
+
+		//the edges of the area are sampled, whichever way round it is given
+		south, north := test.area.LatStart, test.area.LatEnd
+		if south > north {
+			south, north = north, south
+		}
+		west, east := test.area.LngStart, test.area.LngEnd
+		if west > east {
+			west, east = east, west
+		}
+		corners := []struct {
+			vector   *site.MapVector
+			lat, lng float64
+		}{
+			{vectors[0], south, west},
+			{vectors[cols-1], south, east},
+			{vectors[(rows-1)*cols], north, west},
+			{vectors[rows*cols-1], north, east},
+		}
+		for _, corner := range corners {
+			if math.Abs(corner.vector.Latitude-corner.lat) > 1e-12 || math.Abs(corner.vector.Longtitude-corner.lng) > 1e-12 {
+				t.Errorf("%s: corner at %v, %v, want %v, %v", test.name,
+					corner.vector.Latitude, corner.vector.Longtitude, corner.lat, corner.lng)
+			}
+		}
+		for i, vector := range vectors {
+			if vector.Latitude < south-1e-12 || vector.Latitude > north+1e-12 ||
+				vector.Longtitude < west-1e-12 || vector.Longtitude > east+1e-12 {
+				t.Errorf("%s: vector %d at %v, %v outside the area", test.name, i, vector.Latitude, vector.Longtitude)
+			}
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//importMesh turns a georeferenced site mesh into its own scene file; every vertex gets its GCS
+//through the ENU frame of the mesh origin, so picking on it returns real-world positions like
+//picking on the downloaded terrain. A scene file of the same GCS and faces is kept as it is, so
+//the scene is rebuilt when the mesh or its georeference changed
+func importMesh(mesh site.MeshConfig, scenePath string) error {
+	axes, err := site.MeshAxes(mesh.Axes)
+	if err != nil {
+		return err
+	}
+
+	var positions [][3]float64
+	var faces [][3]int
+	switch strings.ToLower(filepath.Ext(mesh.File)) {
+	case ".obj":
+		positions, faces, err = readOBJ(mesh.File)
+	case ".ply":
+		positions, faces, err = readPLY(mesh.File)
+	default:
+		err = fmt.Errorf("mesh %s: unknown mesh type; use .obj or .ply", mesh.File)
+	}
+	if err != nil {
+		return err
+	}
+	if len(faces) == 0 {
+		return fmt.Errorf("mesh %s: no faces", mesh.File)
+	}
+
+	frame := site.NewENUFrame(mesh.Latitude, mesh.Longitude, mesh.Elevation)
+	compositeVector := make([]*site.MapVector, len(positions))
+	for i, position := range positions {
+		var enu [3]float64
+		for axis := 0; axis < 3; axis++ {
+			for k := 0; k < 3; k++ {
+				enu[k] += position[axis] * mesh.Scale * axes[axis][k]
+			}
+		}
+		lat, lng, elevation := frame.ToGCS(enu[0], enu[1], enu[2])
+		compositeVector[i] = &site.MapVector{
+			Latitude:   lat,
+			Longtitude: lng,
+			Elevation:  elevation,
+		}
+	}
+
+	//a mirroring axis convention turns the faces over
+	mirrored := axes[0][0]*(axes[1][1]*axes[2][2]-axes[1][2]*axes[2][1])-
+		axes[0][1]*(axes[1][0]*axes[2][2]-axes[1][2]*axes[2][0])+
+		axes[0][2]*(axes[1][0]*axes[2][1]-axes[1][1]*axes[2][0]) < 0
+	primitiveIndex := make([]*site.MapPrimitiveIndex, len(faces))
+	for i, face := range faces {
+		if mirrored {
+			face[1], face[2] = face[2], face[1]
+		}
+		primitiveIndex[i] = &site.MapPrimitiveIndex{
+			PrimitiveBottom: face[0],
+			PrimitiveTop:    face[1],
+			PrimitiveLeft:   face[2],
+		}
+	}
+
+	if sceneImported(scenePath, compositeVector, primitiveIndex) {
+		return nil
+	}
+	if _, _, _, err := site.BuildScene(compositeVector, primitiveIndex, scenePath); err != nil {
+		return err
+	}
+	fmt.Println(len(compositeVector), "vectors,", len(primitiveIndex), "primitives imported from", mesh.File, "into", scenePath)
+	return nil
+}
+
+//sceneImported tells if the scene file holds the vertices at the same GCS and the same faces
+func sceneImported(scenePath string, compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) bool {
+	_, sceneVector, sceneIndex, err := site.LoadScene(scenePath)
+	if err != nil || len(sceneVector) != len(compositeVector) || len(sceneIndex) != len(primitiveIndex) {
+		return false
+	}
+	for i, vector := range compositeVector {
+		if sceneVector[i].Latitude != vector.Latitude || sceneVector[i].Longtitude != vector.Longtitude ||
+			sceneVector[i].Elevation != vector.Elevation {
+			return false
+		}
+	}
+	for i, index := range primitiveIndex {
+		if *sceneIndex[i] != *index {
+			return false
+		}
+	}
+	return true
+}
+
+//readOBJ reads the vertices and faces of a Wavefront OBJ; polygons are split into fans
+func readOBJ(path string) ([][3]float64, [][3]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var positions [][3]float64
+	var faces [][3]int
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return nil, nil, fmt.Errorf("%s line %d: vertex needs x y z", path, line)
+			}
+			var position [3]float64
+			for k := range position {
+				position[k], err = strconv.ParseFloat(fields[k+1], 64)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s line %d: %s", path, line, err)
+				}
+			}
+			positions = append(positions, position)
+
+		case "f":
+			var polygon []int
+			for _, field := range fields[1:] {
+				index, err := strconv.Atoi(strings.SplitN(field, "/", 2)[0])
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s line %d: %s", path, line, err)
+				}
+				//counted from 1, or back from the last vertex when negative
+				if index < 0 {
+					index += len(positions)
+				} else {
+					index--
+				}
+				if index < 0 || index >= len(positions) {
+					return nil, nil, fmt.Errorf("%s line %d: face uses a vertex that is not there", path, line)
+				}
+				polygon = append(polygon, index)
+			}
+			for k := 2; k < len(polygon); k++ {
+				faces = append(faces, [3]int{polygon[0], polygon[k-1], polygon[k]})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return positions, faces, nil
+}
+
+type plyProperty struct {
+	name, valueType, countType string
+	list                       bool
+}
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+//readPLY reads the x, y, z of the vertex element and the vertex_indices of the face element
+//of an ascii or binary PLY; other elements and properties are skipped, polygons split into fans
+func readPLY(path string) ([][3]float64, [][3]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var format string
+	var elements []*plyElement
+	for first := true; ; first = false {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: header: %s", path, err)
+		}
+		fields := strings.Fields(line)
+		if first {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return nil, nil, fmt.Errorf("%s: not a PLY file", path)
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "end_header" {
+			break
+		}
+		switch {
+		case fields[0] == "format" && len(fields) >= 2:
+			format = fields[1]
+		case fields[0] == "element" && len(fields) == 3:
+			count, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: header: %s", path, err)
+			}
+			elements = append(elements, &plyElement{name: fields[1], count: count})
+		case fields[0] == "property" && len(elements) > 0:
+			element := elements[len(elements)-1]
+			if len(fields) == 5 && fields[1] == "list" {
+				element.properties = append(element.properties,
+					plyProperty{name: fields[4], countType: fields[2], valueType: fields[3], list: true})
+			} else if len(fields) == 3 {
+				element.properties = append(element.properties, plyProperty{name: fields[2], valueType: fields[1]})
+			} else {
+				return nil, nil, fmt.Errorf("%s: header: bad property %q", path, strings.TrimSpace(line))
+			}
+		}
+	}
+
+	next, err := plyValueReader(reader, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var positions [][3]float64
+	var faces [][3]int
+	for _, element := range elements {
+		for i := 0; i < element.count; i++ {
+			var position [3]float64
+			var polygon []int
+			for _, property := range element.properties {
+				if !property.list {
+					value, err := next(property.valueType)
+					if err != nil {
+						return nil, nil, fmt.Errorf("%s: %s %d: %s", path, element.name, i, err)
+					}
+					switch property.name {
+					case "x":
+						position[0] = value
+					case "y":
+						position[1] = value
+					case "z":
+						position[2] = value
+					}
+					continue
+				}
+				count, err := next(property.countType)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %s %d: %s", path, element.name, i, err)
+				}
+				for k := 0; k < int(count); k++ {
+					value, err := next(property.valueType)
+					if err != nil {
+						return nil, nil, fmt.Errorf("%s: %s %d: %s", path, element.name, i, err)
+					}
+					if property.name == "vertex_indices" || property.name == "vertex_index" {
+						polygon = append(polygon, int(value))
+					}
+				}
+			}
+			switch element.name {
+			case "vertex":
+				positions = append(positions, position)
+			case "face":
+				for k := 2; k < len(polygon); k++ {
+					faces = append(faces, [3]int{polygon[0], polygon[k-1], polygon[k]})
+				}
+			}
+		}
+	}
+
+	for _, face := range faces {
+		for _, index := range face {
+			if index < 0 || index >= len(positions) {
+				return nil, nil, fmt.Errorf("%s: face uses a vertex that is not there", path)
+			}
+		}
+	}
+	return positions, faces, nil
+}
+
+//plyValueReader reads the element values one after another as float64, whatever their type
+func plyValueReader(reader *bufio.Reader, format string) (func(valueType string) (float64, error), error) {
+	if format == "ascii" {
+		scanner := bufio.NewScanner(reader)
+		scanner.Split(bufio.ScanWords)
+		return func(string) (float64, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			return strconv.ParseFloat(scanner.Text(), 64)
+		}, nil
+	}
+
+	var order binary.ByteOrder
+	switch format {
+	case "binary_little_endian":
+		order = binary.LittleEndian
+	case "binary_big_endian":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unknown PLY format %q", format)
+	}
+	sizes := map[string]int{
+		"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+		"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+		"int": 4, "int32": 4, "uint": 4, "uint32": 4, "float": 4, "float32": 4,
+		"double": 8, "float64": 8,
+	}
+	buffer := make([]byte, 8)
+	return func(valueType string) (float64, error) {
+		size, ok := sizes[valueType]
+		if !ok {
+			return 0, fmt.Errorf("unknown PLY type %q", valueType)
+		}
+		if _, err := io.ReadFull(reader, buffer[:size]); err != nil {
+			return 0, err
+		}
+		switch valueType {
+		case "char", "int8":
+			return float64(int8(buffer[0])), nil
+		case "uchar", "uint8":
+			return float64(buffer[0]), nil
+		case "short", "int16":
+			return float64(int16(order.Uint16(buffer))), nil
+		case "ushort", "uint16":
+			return float64(order.Uint16(buffer)), nil
+		case "int", "int32":
+			return float64(int32(order.Uint32(buffer))), nil
+		case "uint", "uint32":
+			return float64(order.Uint32(buffer)), nil
+		case "float", "float32":
+			return float64(math.Float32frombits(order.Uint32(buffer))), nil
+		default:
+			return math.Float64frombits(order.Uint64(buffer)), nil
+		}
+	}, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestReadOBJ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meshimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	square := [][3]float64{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+	tests := []struct {
+		name      string
+		text      string
+		positions [][3]float64
+		faces     [][3]int
+		fails     bool
+	}{
+		{"triangles", "# a comment\nv 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\n\nf 1 2 3\nf 1 3 4\n",
+			square, [][3]int{{0, 1, 2}, {0, 2, 3}}, false},
+		{"texture and normal indices", "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nvt 0 0\nvn 0 0 1\nf 1/1/1 2/1/1 3//1\nf 1/1 3/1 4/1\n",
+			square, [][3]int{{0, 1, 2}, {0, 2, 3}}, false},
+		{"a quad in a fan", "v 0 0 0\nv 1 0 0\nv 1 1 0\nv 0 1 0\nf 1 2 3 4\n",
+			square, [][3]int{{0, 1, 2}, {0, 2, 3}}, false},
+		{"negative indices back from the last vertex", "v 0 0 0\nv 1 0 0\nv 1 1 0\nf -3 -2 -1\nv 0 1 0\nf -4 -2 -1\n",
+			[][3]float64{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}, [][3]int{{0, 1, 2}, {0, 2, 3}}, false},
+		{"a face before its vertices", "v 0 0 0\nf 1 2 3\nv 1 0 0\nv 1 1 0\n", nil, nil, true},
+		{"an index of 0", "v 0 0 0\nv 1 0 0\nv 1 1 0\nf 0 1 2\n", nil, nil, true},
+		{"a short vertex", "v 0 0\n", nil, nil, true},
+		{"a bad number", "v 0 0 x\n", nil, nil, true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "mesh.obj")
+		if err := ioutil.WriteFile(path, []byte(test.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		positions, faces, err := readOBJ(path)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(positions, test.positions) || !reflect.DeepEqual(faces, test.faces) {
+			t.Errorf("%s: %v and %v, want %v and %v", test.name, positions, faces, test.positions, test.faces)
+		}
+	}
+}
+
+func TestReadPLY(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meshimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//a quad and a triangle; the vertices have a colour between their coordinates, and a texture
+	//list comes before the indices of a face
+	header := func(format string) string {
+		return "ply\nformat " + format + " 1.0\ncomment made by hand\n" +
+			"element vertex 5\nproperty float x\nproperty float y\nproperty uchar red\nproperty double z\n" +
+			"element face 2\nproperty list uchar float texcoord\nproperty list uchar int vertex_indices\n" +
+			"element edge 1\nproperty int vertex1\nproperty int vertex2\nend_header\n"
+	}
+	positions := [][3]float64{{0, 0, 0}, {1, 0, 0.5}, {1, 1, 0}, {0, 1, 0}, {2, 0, -1.25}}
+	faces := [][]int{{0, 1, 2, 3}, {1, 4, 2}}
+	ascii := header("ascii") +
+		"0 0 255 0\n1 0 255 0.5\n1 1 255 0\n0 1 255 0\n2 0 255 -1.25\n" +
+		"2 0.5 0.5 4 0 1 2 3\n0 3 1 4 2\n" +
+		"0 1\n"
+	binaryPLY := func(order binary.ByteOrder, format string) string {
+		var body bytes.Buffer
+		body.WriteString(header(format))
+		for _, position := range positions {
+			binary.Write(&body, order, float32(position[0]))
+			binary.Write(&body, order, float32(position[1]))
+			body.WriteByte(255)
+			binary.Write(&body, order, position[2])
+		}
+		for _, face := range faces {
+			body.Write([]byte{1})
+			binary.Write(&body, order, float32(0.5))
+			body.WriteByte(byte(len(face)))
+			for _, index := range face {
+				binary.Write(&body, order, int32(index))
+			}
+		}
+		binary.Write(&body, order, []int32{0, 1})
+		return body.String()
+	}
+	fan := [][3]int{{0, 1, 2}, {0, 2, 3}, {1, 4, 2}}
+
+	tests := []struct {
+		name  string
+		text  string
+		fails bool
+	}{
+		{"ascii", ascii, false},
+		{"binary little endian", binaryPLY(binary.LittleEndian, "binary_little_endian"), false},
+		{"binary big endian", binaryPLY(binary.BigEndian, "binary_big_endian"), false},
+		{"not a PLY", "obj\n" + ascii[4:], true},
+		{"unknown format", header("utf8") + "0 0 0 0\n", true},
+		{"truncated", binaryPLY(binary.LittleEndian, "binary_little_endian")[:len(header("binary_little_endian"))+30], true},
+		{"a face of a vertex that is not there", header("ascii") +
+			"0 0 255 0\n1 0 255 0\n1 1 255 0\n0 1 255 0\n2 0 255 0\n0 3 0 1 5\n0 3 0 1 2\n0 1\n", true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "mesh.ply")
+		if err := ioutil.WriteFile(path, []byte(test.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		readPositions, readFaces, err := readPLY(path)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(readPositions, positions) || !reflect.DeepEqual(readFaces, fan) {
+			t.Errorf("%s: %v and %v, want %v and %v", test.name, readPositions, readFaces, positions, fan)
+		}
+	}
+}
+
+func TestImportMesh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meshimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	meshFile := filepath.Join(dir, "site.obj")
+	scenePath := filepath.Join(dir, "site.scene")
+
+	//a 10m square counter-clockwise seen from above in x east, y north, z up, one corner raised
+	if err := ioutil.WriteFile(meshFile, []byte("v 0 0 0\nv 10 0 0\nv 10 10 1\nv 0 10 0\nf 1 2 3 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mesh := site.MeshConfig{File: meshFile, Latitude: 43.45, Longitude: -80.49, Elevation: 330, Axes: "enu", Scale: 1}
+
+	//the faces of every axis convention face up: Y of the model points down, so their model
+	//normal has a negative Y
+	for _, axes := range []string{"enu", "neu", "wsu", "esu", "nwu"} {
+		mesh.Axes = axes
+		os.Remove(scenePath)
+		if err := importMesh(mesh, scenePath); err != nil {
+			t.Fatalf("%s: %s", axes, err)
+		}
+		_, compositeVector, primitiveIndex, err := site.LoadScene(scenePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, index := range primitiveIndex {
+			a, b, c := compositeVector[index.PrimitiveBottom], compositeVector[index.PrimitiveTop], compositeVector[index.PrimitiveLeft]
+			//Y of the cross product of the model edges, right handed
+			normalY := (c.VertX-a.VertX)*(b.VertZ-a.VertZ) - (b.VertX-a.VertX)*(c.VertZ-a.VertZ)
+			if normalY >= 0 {
+				t.Errorf("%s: face %v faces down", axes, *index)
+			}
+		}
+	}
+
+	//the vertices are placed by the georeference
+	mesh.Axes = "enu"
+	if err := importMesh(mesh, scenePath); err != nil {
+		t.Fatal(err)
+	}
+	_, compositeVector, _, err := site.LoadScene(scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := site.NewENUFrame(43.45, -80.49, 330)
+	if east, north, up := frame.ToENU(compositeVector[2].Latitude, compositeVector[2].Longtitude, compositeVector[2].Elevation); math.Abs(east-10) > 1e-6 || math.Abs(north-10) > 1e-6 || math.Abs(up-1) > 1e-6 {
+		t.Errorf("corner at %v, %v, %v of the origin, want 10, 10, 1", east, north, up)
+	}
+
+	//the same mesh and georeference keep the scene; a new georeference rebuilds it even though
+	//the mesh is older than the scene
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(meshFile, old.Add(-time.Hour), old.Add(-time.Hour))
+	os.Chtimes(scenePath, old, old)
+	if err := importMesh(mesh, scenePath); err != nil {
+		t.Fatal(err)
+	}
+	if imported, _ := os.Stat(scenePath); !imported.ModTime().Equal(old) {
+		t.Error("a scene of the same mesh rebuilt")
+	}
+	for _, change := range []func(*site.MeshConfig){
+		func(mesh *site.MeshConfig) { mesh.Latitude += 0.001 },
+		func(mesh *site.MeshConfig) { mesh.Elevation = 331 },
+		func(mesh *site.MeshConfig) { mesh.Axes = "neu" },
+		func(mesh *site.MeshConfig) { mesh.Scale = 0.5 },
+	} {
+		changed := mesh
+		change(&changed)
+		os.Chtimes(scenePath, old, old)
+		if err := importMesh(changed, scenePath); err != nil {
+			t.Fatal(err)
+		}
+		if imported, _ := os.Stat(scenePath); imported.ModTime().Equal(old) {
+			t.Errorf("the scene of %+v kept for %+v", mesh, changed)
+		}
+		importMesh(mesh, scenePath)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//orthoView looks straight down on the bounding box of the model, north up, at pixel metres a pixel
+type orthoView struct {
+	width, height    int
+	pixel            float64 //metres
+	centreX, centreZ float64 //metres in the model
+	matrix           fauxgl.Matrix
+}
+
+//newOrthoView fits the bounding box of the model; without a pixel size its longer side is longestSide pixels
+func newOrthoView(maxVert float64, compositeVector []*site.MapVector, pixel float64, longestSide int) orthoView {
+	minX, minY, minZ := math.Inf(1), math.Inf(1), math.Inf(1)
+	maxX, maxY, maxZ := math.Inf(-1), math.Inf(-1), math.Inf(-1)
+	for _, vector := range compositeVector {
+		minX, maxX = math.Min(minX, vector.VertX), math.Max(maxX, vector.VertX)
+		minY, maxY = math.Min(minY, vector.VertY), math.Max(maxY, vector.VertY)
+		minZ, maxZ = math.Min(minZ, vector.VertZ), math.Max(maxZ, vector.VertZ)
+	}
+	if pixel <= 0 {
+		pixel = math.Max(maxX-minX, maxZ-minZ) / float64(longestSide)
+	}
+	o := orthoView{
+		width:   int(math.Max(math.Ceil((maxZ-minZ)/pixel), 1)),
+		height:  int(math.Max(math.Ceil((maxX-minX)/pixel), 1)),
+		pixel:   pixel,
+		centreX: (minX + maxX) / 2,
+		centreZ: (minZ + maxZ) / 2,
+	}
+
+	//from above the highest ground, Y down, north up; to its right is east
+	eye := fauxgl.Vector{X: o.centreX / maxVert, Y: minY/maxVert - 0.1, Z: o.centreZ / maxVert}
+	view := fauxgl.LookAt(eye, eye.Add(fauxgl.Vector{X: 0, Y: 1, Z: 0}), fauxgl.Vector{X: 1, Y: 0, Z: 0})
+	halfWidth := float64(o.width) * pixel / 2 / maxVert
+	halfHeight := float64(o.height) * pixel / 2 / maxVert
+	o.matrix = view.Orthographic(-halfWidth, halfWidth, -halfHeight, halfHeight, 0.01, (maxY-minY)/maxVert+0.2)
+	return o
+}
+
+//model is where the centre of a pixel is in the model, in metres
+func (o orthoView) model(column, row float64) (x, z float64) {
+	east := (column + 0.5 - float64(o.width)/2) * o.pixel
+	north := (float64(o.height)/2 - row - 0.5) * o.pixel
+	return o.centreX + north, o.centreZ - east
+}
+
+//worldFile is the affine transform of a world file from pixel centres to longitude and latitude:
+//	lng = a*column + b*row + c, lat = d*column + e*row + f
+//the ENU frame is close enough to a plane over a site for its corners to fit it
+func (o orthoView) worldFile(properties *site.ModelProperties) (a, b, c, d, e, f float64) {
+	gcs := func(column, row float64) (float64, float64) {
+		x, z := o.model(column, row)
+		lat, lng, _ := properties.GCS(x, 0, z)
+		return lat, lng
+	}
+	right, down := math.Max(float64(o.width-1), 1), math.Max(float64(o.height-1), 1)
+	lat, lng := gcs(0, 0)
+	rightLat, rightLng := gcs(right, 0)
+	downLat, downLng := gcs(0, down)
+	return (rightLng - lng) / right, (downLng - lng) / down, lng,
+		(rightLat - lat) / right, (downLat - lat) / down, lat
+}
+
+//orthoCommand is "2DGCS ortho -mode lambert -o ortho.png": renders the terrain straight down over the
+//whole model, georeferenced by a world file next to a .png or as a GeoTIFF for a .tif
+func orthoCommand(args []string) {
+	commandFlags := flag.NewFlagSet("ortho", flag.ExitOnError)
+	mode := commandFlags.String("mode", "lambert", "solid, lambert, wireframe, edges or normal")
+	pixel := commandFlags.Float64("pixel", 0, "metres a pixel; the longer side of the model is the render width when 0")
+	output := commandFlags.String("o", "ortho.png", "image written; .png with a world file, or .tif as a GeoTIFF")
+	worldExtension := commandFlags.String("world", ".pgw", "extension of the world file of a .png, .pgw or .wld")
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+	if *mode == "heat" || *mode == "depth" {
+		log.Fatalf("fatal error: %s is a mode of the camera view only", *mode)
+	}
+
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	maxVert := model.properties.MaxVert
+	o := newOrthoView(maxVert, model.compositeVector, *pixel, model.scene.Render.Width)
+	render := model.scene.Render
+	render.Width, render.Height = o.width, o.height
+	rendered, err := terrainImage(*mode, maxVert, o.matrix, render, model.compositeVector, model.primitiveIndex)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	a, b, c, d, e, f := o.worldFile(model.properties)
+
+	switch strings.ToLower(filepath.Ext(*output)) {
+	case ".png":
+		if err := fauxgl.SavePNG(*output, rendered); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+		worldPath := strings.TrimSuffix(*output, filepath.Ext(*output)) + *worldExtension
+		world := fmt.Sprintf("%.12f\n%.12f\n%.12f\n%.12f\n%.12f\n%.12f\n", a, d, b, e, c, f)
+		if err := ioutil.WriteFile(worldPath, []byte(world), 0644); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+		fmt.Println("world file written to", worldPath)
+	case ".tif", ".tiff":
+		picture := image.NewNRGBA(image.Rect(0, 0, o.width, o.height))
+		draw.Draw(picture, picture.Bounds(), rendered, rendered.Bounds().Min, draw.Src)
+		if err := writeImageTIFF(*output, picture, geoTIFFFields(a, b, c, d, e, f)); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+	default:
+		log.Fatalf("fatal error: the orthographic render is written to .png or .tif, not %s", *output)
+	}
+	fmt.Printf("%dx%d %s render at %.3f metres a pixel written to %s\n", o.width, o.height, *mode, o.pixel, *output)
+}
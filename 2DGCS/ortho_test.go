@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestOrthoGeoreference(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ortho")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//a model 60m north to south and 80m west to east, with a hill in the middle; model X is north,
+	//Y down and Z west
+	properties := &site.ModelProperties{OriginLatitude: 43.45, OriginLongitude: -80.49, OriginElevation: 300, MaxVert: 100}
+	vector := func(x, y, z float64) *site.MapVector {
+		lat, lng, elevation := properties.GCS(x, y, z)
+		return &site.MapVector{VertX: x, VertY: y, VertZ: z, Latitude: lat, Longtitude: lng, Elevation: elevation}
+	}
+	northWest, northEast, southWest, southEast := vector(60, 0, 80), vector(60, 0, 0), vector(0, 0, 80), vector(0, 0, 0)
+	compositeVector := []*site.MapVector{northWest, northEast, southWest, southEast, vector(30, -5, 40)}
+
+	for _, test := range []struct {
+		pixel       float64
+		longestSide int
+	}{{2, 0}, {0, 40}} {
+		o := newOrthoView(properties.MaxVert, compositeVector, test.pixel, test.longestSide)
+		if o.width != 40 || o.height != 30 || o.pixel != 2 {
+			t.Fatalf("%v metres a pixel or %d on the longer side: %dx%d at %v metres, want 40x30 at 2",
+				test.pixel, test.longestSide, o.width, o.height, o.pixel)
+		}
+
+		//the corners of the model are the outer corners of the corner pixels: half a pixel past the
+		//centres of the world file, and whole pixels in the raster space of the GeoTIFF. An affine
+		//transform fits the ENU frame of the site within a centimetre, about 1e-7 degrees
+		corners := []struct {
+			name        string
+			vector      *site.MapVector
+			column, row float64
+		}{
+			{"north-west", northWest, 0, 0},
+			{"north-east", northEast, float64(o.width), 0},
+			{"south-west", southWest, 0, float64(o.height)},
+			{"south-east", southEast, float64(o.width), float64(o.height)},
+		}
+
+		//the camera matrix puts them on the corners of the image
+		for _, corner := range corners {
+			clip := o.matrix.MulPositionW(fauxgl.Vector{
+				X: corner.vector.VertX / properties.MaxVert,
+				Y: corner.vector.VertY / properties.MaxVert,
+				Z: corner.vector.VertZ / properties.MaxVert,
+			})
+			column := (clip.X/clip.W + 1) / 2 * float64(o.width)
+			row := (1 - clip.Y/clip.W) / 2 * float64(o.height)
+			if math.Abs(column-corner.column) > 1e-9 || math.Abs(row-corner.row) > 1e-9 || math.Abs(clip.Z/clip.W) > 1 {
+				t.Errorf("%s: rendered at %v, %v, want %v, %v", corner.name, column, row, corner.column, corner.row)
+			}
+		}
+
+		a, b, c, d, e, f := o.worldFile(properties)
+		if a <= 0 || e >= 0 {
+			t.Errorf("a world file of %v longitude and %v latitude a pixel is not north up", a, e)
+		}
+		for _, corner := range corners {
+			column, row := corner.column-0.5, corner.row-0.5
+			lng, lat := a*column+b*row+c, d*column+e*row+f
+			if math.Abs(lat-corner.vector.Latitude) > 1e-7 || math.Abs(lng-corner.vector.Longtitude) > 1e-7 {
+				t.Errorf("%s: the world file puts it at %.9f, %.9f, want %.9f, %.9f",
+					corner.name, lat, lng, corner.vector.Latitude, corner.vector.Longtitude)
+			}
+		}
+
+		path := filepath.Join(dir, "ortho.tif")
+		if err := writeImageTIFF(path, image.NewNRGBA(image.Rect(0, 0, o.width, o.height)), geoTIFFFields(a, b, c, d, e, f)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := newTIFFReader(path, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modelType, ok := reader.geoKey(geoKeyModelType); !ok || modelType != modelTypeGeographic {
+			t.Errorf("model type %d, want %d", modelType, modelTypeGeographic)
+		}
+		if rasterType, ok := reader.geoKey(geoKeyRasterType); !ok || rasterType != rasterPixelIsArea {
+			t.Errorf("raster type %d, want %d", rasterType, rasterPixelIsArea)
+		}
+		transform := reader.doubles(tagModelTransform)
+		if len(transform) != 16 {
+			t.Fatalf("a transform of %d values", len(transform))
+		}
+		for _, corner := range corners {
+			lng := transform[0]*corner.column + transform[1]*corner.row + transform[3]
+			lat := transform[4]*corner.column + transform[5]*corner.row + transform[7]
+			if math.Abs(lat-corner.vector.Latitude) > 1e-7 || math.Abs(lng-corner.vector.Longtitude) > 1e-7 {
+				t.Errorf("%s: the GeoTIFF puts it at %.9f, %.9f, want %.9f, %.9f",
+					corner.name, lat, lng, corner.vector.Latitude, corner.vector.Longtitude)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" //photos may be PNG
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//fuseImage blends the terrain rendered from the camera over its photo. The photo is scaled to the
+//render size the intrinsics are in, which needs it to be of the same shape, and every pixel of it
+//takes the render where the lens shows it, so a calibrated camera lines the terrain up with the
+//photo; alpha is how opaque the terrain is
+func fuseImage(photo, rendered image.Image, intrinsics site.IntrinsicsConfig, render site.RenderConfig, alpha float64) (*image.NRGBA, error) {
+	if bounds := photo.Bounds(); bounds.Dx() != render.Width || bounds.Dy() != render.Height {
+		//within a pixel of the render width at the render height is the same shape
+		width := float64(bounds.Dx()) * float64(render.Height) / float64(bounds.Dy())
+		if bounds.Empty() || math.Abs(width-float64(render.Width)) >= 1 {
+			return nil, fmt.Errorf("overlay: the photo is %dx%d, not of the shape of the %dx%d render; crop it or set the render size",
+				bounds.Dx(), bounds.Dy(), render.Width, render.Height)
+		}
+		photo = resize.Resize(uint(render.Width), uint(render.Height), photo, resize.Bilinear)
+	}
+	frame := image.Rect(0, 0, render.Width, render.Height)
+	fused := image.NewNRGBA(frame)
+	draw.Draw(fused, frame, photo, photo.Bounds().Min, draw.Src)
+	terrain := image.NewNRGBA(frame)
+	draw.Draw(terrain, frame, rendered, rendered.Bounds().Min, draw.Src)
+
+	for y := 0; y < render.Height; y++ {
+		for x := 0; x < render.Width; x++ {
+			under := fused.PixOffset(x, y)
+			fused.Pix[under+3] = 255
+
+			u, v := intrinsics.Undistort(float64(x), float64(y))
+			column, row := site.Round(u), site.Round(v)
+			if column < 0 || row < 0 || column >= render.Width || row >= render.Height {
+				continue
+			}
+			over := terrain.PixOffset(column, row)
+			weight := alpha * float64(terrain.Pix[over+3]) / 255
+			for k := 0; k < 3; k++ {
+				fused.Pix[under+k] = uint8(float64(fused.Pix[under+k])*(1-weight) + float64(terrain.Pix[over+k])*weight + 0.5)
+			}
+		}
+	}
+	return fused, nil
+}
+
+//overlayCommand is "2DGCS overlay -photo frame.jpg -mode wireframe -o fused.jpg": blends the terrain
+//over the camera photo to check the calibration by eye
+func overlayCommand(args []string) {
+	commandFlags := flag.NewFlagSet("overlay", flag.ExitOnError)
+	photoFile := commandFlags.String("photo", "", "frame of the camera, JPEG or PNG")
+	mode := commandFlags.String("mode", "wireframe", "solid, wireframe or depth coloured terrain over the photo")
+	alpha := commandFlags.Float64("alpha", 0.5, "opacity of the terrain, 0 to 1")
+	output := commandFlags.String("o", "fused.jpg", "fused image, .jpg or .png")
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+	if *photoFile == "" {
+		log.Fatal("fatal error: -photo is required")
+	}
+	if *alpha < 0 || *alpha > 1 {
+		log.Fatal("fatal error: -alpha must be between 0 and 1")
+	}
+	//the overlay modes are render modes by another name
+	renderMode, ok := map[string]string{"solid": "solid", "wireframe": "edges", "depth": "heat"}[*mode]
+	if !ok {
+		log.Fatalf("fatal error: unknown overlay mode %q; use solid, wireframe or depth", *mode)
+	}
+
+	file, err := os.Open(*photoFile)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	photo, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("fatal error: photo %s: %s", *photoFile, err)
+	}
+
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	view, err := model.cameraView(true)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	rendered, err := terrainImage(renderMode, view.maxVert, view.cameraPerspective, model.scene.Render,
+		view.compositeVector, view.primitiveIndex)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fused, err := fuseImage(photo, rendered, model.scene.Camera.Intrinsics, model.scene.Render, *alpha)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(*output)) {
+	case ".jpg", ".jpeg":
+		out, err := os.Create(*output)
+		if err == nil {
+			err = jpeg.Encode(out, fused, &jpeg.Options{Quality: 90})
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+	case ".png":
+		if err := fauxgl.SavePNG(*output, fused); err != nil {
+			log.Fatalf("fatal error: %s", err)
+		}
+	default:
+		log.Fatalf("fatal error: the fused image is written to .jpg or .png, not %s", *output)
+	}
+	fmt.Println(*mode, "overlay written to", *output)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestFuseImage(t *testing.T) {
+	render := site.RenderConfig{Width: 16, Height: 9, Scale: 1}
+	transparent := image.NewNRGBA(image.Rect(0, 0, render.Width, render.Height))
+
+	//a photo is scaled to the render when it is of its shape, within a pixel of its width
+	for _, test := range []struct {
+		width, height int
+		fails         bool
+	}{
+		{16, 9, false},
+		{32, 18, false},
+		{33, 19, false},
+		{17, 9, true},
+		{12, 9, true},
+		{9, 16, true},
+		{0, 0, true},
+	} {
+		fused, err := fuseImage(image.NewGray(image.Rect(0, 0, test.width, test.height)), transparent,
+			site.IntrinsicsConfig{}, render, 0.5)
+		if test.fails {
+			if err == nil {
+				t.Errorf("a %dx%d photo fused over a %dx%d render", test.width, test.height, render.Width, render.Height)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%dx%d: %s", test.width, test.height, err)
+			continue
+		}
+		if bounds := fused.Bounds(); bounds.Dx() != render.Width || bounds.Dy() != render.Height {
+			t.Errorf("a %dx%d photo fused to %v", test.width, test.height, bounds)
+		}
+	}
+
+	//a photo of twice the render, dark on the left half and light on the right, keeps its halves;
+	//the columns next to the middle are blended by the scaling
+	shade := func(x, width int) uint8 {
+		if x < width/2 {
+			return 40
+		}
+		return 200
+	}
+	photo := image.NewGray(image.Rect(0, 0, render.Width*2, render.Height*2))
+	for y := 0; y < photo.Bounds().Dy(); y++ {
+		for x := 0; x < photo.Bounds().Dx(); x++ {
+			photo.Pix[photo.PixOffset(x, y)] = shade(x, photo.Bounds().Dx())
+		}
+	}
+	fused, err := fuseImage(photo, transparent, site.IntrinsicsConfig{}, render, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < render.Height; y++ {
+		for _, x := range []int{0, 1, render.Width - 2, render.Width - 1} {
+			want := shade(x, render.Width)
+			if pixel := fused.Pix[fused.PixOffset(x, y):]; pixel[0] != want || pixel[1] != want || pixel[2] != want || pixel[3] != 255 {
+				t.Errorf("scaled pixel %d, %d is %v, want %d", x, y, pixel[:4], want)
+			}
+		}
+	}
+
+	//the terrain is blended over a grey photo by alpha and by its own opacity
+	grey := image.NewGray(image.Rect(0, 0, render.Width, render.Height))
+	for i := range grey.Pix {
+		grey.Pix[i] = 100
+	}
+	rendered := image.NewNRGBA(image.Rect(0, 0, render.Width, render.Height))
+	copy(rendered.Pix, []uint8{255, 0, 0, 255, 255, 0, 0, 128, 0, 0, 255, 0})
+	for _, test := range []struct {
+		alpha  float64
+		pixels [3][4]uint8 //the opaque, half opaque and transparent pixels of the terrain
+	}{
+		{0, [3][4]uint8{{100, 100, 100, 255}, {100, 100, 100, 255}, {100, 100, 100, 255}}},
+		{0.5, [3][4]uint8{{178, 50, 50, 255}, {139, 75, 75, 255}, {100, 100, 100, 255}}},
+		{1, [3][4]uint8{{255, 0, 0, 255}, {178, 50, 50, 255}, {100, 100, 100, 255}}},
+	} {
+		fused, err := fuseImage(grey, rendered, site.IntrinsicsConfig{}, render, test.alpha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x, want := range test.pixels {
+			pixel := fused.Pix[fused.PixOffset(x, 0):]
+			if got := [4]uint8{pixel[0], pixel[1], pixel[2], pixel[3]}; got != want {
+				t.Errorf("alpha %v: pixel %d is %v, want %v", test.alpha, x, got, want)
+			}
+		}
+		if pixel := fused.Pix[fused.PixOffset(render.Width-1, render.Height-1):]; pixel[0] != 100 || pixel[3] != 255 {
+			t.Errorf("alpha %v: the photo without terrain is %v", test.alpha, pixel[:4])
+		}
+	}
+}
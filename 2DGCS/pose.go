@@ -0,0 +1,603 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+//controlPoint is a pixel of the camera image and the GCS it shows, placed in the model
+type controlPoint struct {
+	pixelX, pixelY float64
+	vector         *site.MapVector
+	bearing        [3]float64 //unit ray of the undistorted pixel from the camera
+}
+
+//cameraPose takes model points in metres into the camera: x right, y down, z where it looks
+type cameraPose struct {
+	rotation    [3][3]float64
+	translation [3]float64
+}
+
+func (p cameraPose) toCamera(v *site.MapVector) [3]float64 {
+	var result [3]float64
+	for i := range result {
+		result[i] = p.rotation[i][0]*v.VertX + p.rotation[i][1]*v.VertY + p.rotation[i][2]*v.VertZ + p.translation[i]
+	}
+	return result
+}
+
+//reprojection is how many pixels off the pose sees a control point; behind the camera is infinitely off
+func (p cameraPose) reprojection(point *controlPoint, intrinsics site.IntrinsicsConfig) float64 {
+	x, y, ok := p.project(point.vector, intrinsics)
+	if !ok {
+		return math.Inf(1)
+	}
+	undistortedX, undistortedY := intrinsics.Undistort(point.pixelX, point.pixelY)
+	return math.Hypot(x-undistortedX, y-undistortedY)
+}
+
+func (p cameraPose) project(v *site.MapVector, intrinsics site.IntrinsicsConfig) (float64, float64, bool) {
+	c := p.toCamera(v)
+	if c[2] <= 0 {
+		return 0, 0, false
+	}
+	return intrinsics.Fx*c[0]/c[2] + intrinsics.Cx, intrinsics.Fy*c[1]/c[2] + intrinsics.Cy, true
+}
+
+//poseCommand is "2DGCS pose -gcp points.csv": solves where the camera is and where it looks
+//from ground control points, and writes it into the camera of the scene config; with -camera
+//into that camera of the registry
+func poseCommand(args []string) {
+	commandFlags := flag.NewFlagSet("pose", flag.ExitOnError)
+	gcpFile := commandFlags.String("gcp", "", "CSV of control points with pixelX, pixelY, latitude, longitude and elevation columns")
+	iterations := commandFlags.Int("iterations", 1000, "RANSAC samples of three control points")
+	threshold := commandFlags.Float64("threshold", 4, "reprojection error in pixels a control point counts as an inlier within")
+	output := commandFlags.String("o", "", "scene config the solved camera is written to; the -config file when empty")
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+	if *gcpFile == "" {
+		log.Fatal("fatal error: -gcp is required")
+	}
+	if *output == "" {
+		*output = *configFile
+	}
+	if *output == "" {
+		log.Fatal("fatal error: -o or -config is required")
+	}
+
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	scene, properties := model.scene, model.properties
+	points, err := readControlPoints(*gcpFile)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	if len(points) < 4 {
+		log.Fatalf("fatal error: %s: %d control points; at least 4 are needed", *gcpFile, len(points))
+	}
+
+	intrinsics := scene.Camera.Intrinsics
+	if !intrinsics.Calibrated() {
+		intrinsics = pinholeIntrinsics(scene.Render)
+	}
+	for _, point := range points {
+		properties.Localize(point.vector)
+		x, y := intrinsics.Undistort(point.pixelX, point.pixelY)
+		x, y = (x-intrinsics.Cx)/intrinsics.Fx, (y-intrinsics.Cy)/intrinsics.Fy
+		length := math.Sqrt(x*x + y*y + 1)
+		point.bearing = [3]float64{x / length, y / length, 1 / length}
+	}
+
+	pose, inliers, ok := solvePnP(points, intrinsics, *iterations, *threshold, rand.New(rand.NewSource(1)))
+	if !ok {
+		log.Fatal("fatal error: no camera pose fits the control points; check them or raise -threshold")
+	}
+
+	//report
+	for i, point := range points {
+		mark := ""
+		if !inliers[i] {
+			mark = " outlier"
+		}
+		fmt.Printf("control point %d: %.2f pixels%s\n", i+1, pose.reprojection(point, intrinsics), mark)
+	}
+	rms, count := reprojectionRMS(pose, points, inliers, intrinsics)
+	fmt.Printf("%d of %d control points fit; reprojection RMS %.2f pixels\n", count, len(points), rms)
+
+	scene.Camera = poseCamera(properties, pose, scene.Camera)
+	if err := savePoseCamera(*configFile, *output, scene); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fmt.Printf("camera at %.7f, %.7f, %.3f metres, LR %.3f, UD %.3f, roll %.3f degrees written to %s\n",
+		scene.Camera.Latitude, scene.Camera.Longitude, scene.Camera.Elevation,
+		scene.Camera.RotationLR, scene.Camera.RotationUD, scene.Camera.Roll, *output)
+}
+
+//savePoseCamera writes the config file to output with only the solved camera of the scene in
+//place of its own: the camera section, or the camera of the registry the scene uses. Flags and
+//defaults the file leaves out stay out of it
+func savePoseCamera(configFile, output string, scene *site.SceneConfig) error {
+	saved, err := site.LoadSceneConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if id := scene.CameraID(); id != "" {
+		camera := saved.Cameras[id]
+		camera.CameraConfig = scene.Camera
+		saved.Cameras[id] = camera
+	} else {
+		saved.Camera = scene.Camera
+	}
+	return site.SaveSceneConfig(output, saved)
+}
+
+//reprojectionRMS is the root mean square reprojection of the inliers and how many there are
+func reprojectionRMS(pose cameraPose, points []*controlPoint, inliers []bool, intrinsics site.IntrinsicsConfig) (float64, int) {
+	var squares float64
+	count := 0
+	for i, point := range points {
+		if inliers[i] {
+			reprojection := pose.reprojection(point, intrinsics)
+			squares += reprojection * reprojection
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return math.Sqrt(squares / float64(count)), count
+}
+
+//pinholeIntrinsics is the camera site.CameraModel makes of fovy when there is no calibration
+func pinholeIntrinsics(render site.RenderConfig) site.IntrinsicsConfig {
+	focal := float64(render.Height) / 2 / math.Tan(site.DegToRad(render.Fovy)/2)
+	return site.IntrinsicsConfig{
+		Fx: focal,
+		Fy: focal,
+		Cx: float64(render.Width)/2 - 0.5,
+		Cy: float64(render.Height)/2 - 0.5,
+	}
+}
+
+//poseCamera turns a solved pose into the camera config site.CameraModel builds its view from;
+//the height above the elevation is kept and the elevation is what is left of it
+func poseCamera(properties *site.ModelProperties, pose cameraPose, camera site.CameraConfig) site.CameraConfig {
+	r := pose.rotation
+	var centre [3]float64
+	for i := range centre {
+		centre[i] = -(r[0][i]*pose.translation[0] + r[1][i]*pose.translation[1] + r[2][i]*pose.translation[2])
+	}
+	lat, lng, elevation := properties.GCS(centre[0], centre[1], centre[2])
+	camera.Latitude = lat
+	camera.Longitude = lng
+	camera.Elevation = elevation - properties.OriginElevation - camera.Height
+
+	//the rows of the rotation are right, down and forward of the camera in the model
+	right, forward := r[0], r[2]
+	camera.RotationLR = site.RadToDeg(math.Atan2(-forward[0], forward[2]))
+	camera.RotationUD = site.RadToDeg(math.Atan2(-forward[1], math.Hypot(forward[0], forward[2])))
+	//without roll right would be level: forward x up, with up (0, -1, 0)
+	level := [3]float64{forward[2], 0, -forward[0]}
+	cross := [3]float64{
+		level[1]*right[2] - level[2]*right[1],
+		level[2]*right[0] - level[0]*right[2],
+		level[0]*right[1] - level[1]*right[0],
+	}
+	camera.Roll = site.RadToDeg(math.Atan2(cross[0]*forward[0]+cross[1]*forward[1]+cross[2]*forward[2],
+		level[0]*right[0]+level[1]*right[1]+level[2]*right[2]))
+	return camera
+}
+
+//solvePnP finds the pose most control points agree with: RANSAC over P3P of three points, then
+//refined on the inliers by least squares of the reprojection error
+func solvePnP(points []*controlPoint, intrinsics site.IntrinsicsConfig, iterations int, threshold float64,
+	random *rand.Rand) (cameraPose, []bool, bool) {
+
+	var best cameraPose
+	var bestInliers []bool
+	bestCount, bestError := 0, math.Inf(1)
+	score := func(pose cameraPose) ([]bool, int, float64) {
+		inliers := make([]bool, len(points))
+		count, sum := 0, 0.0
+		for i, point := range points {
+			if reprojection := pose.reprojection(point, intrinsics); reprojection <= threshold {
+				inliers[i] = true
+				count++
+				sum += reprojection
+			}
+		}
+		return inliers, count, sum
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		sample := random.Perm(len(points))[:3]
+		a, b, c := points[sample[0]], points[sample[1]], points[sample[2]]
+		for _, pose := range p3p(a, b, c) {
+			inliers, count, sum := score(pose)
+			if count > bestCount || (count == bestCount && sum < bestError) {
+				best, bestInliers, bestCount, bestError = pose, inliers, count, sum
+			}
+		}
+	}
+	//three points always fit the pose solved from them; a fourth has to confirm it
+	if bestCount < 4 {
+		return best, nil, false
+	}
+
+	for round := 0; round < 2; round++ {
+		var fit []*controlPoint
+		for i, point := range points {
+			if bestInliers[i] {
+				fit = append(fit, point)
+			}
+		}
+		best = refinePose(best, fit, intrinsics)
+		bestInliers, bestCount, _ = score(best)
+		if bestCount < 4 {
+			return best, nil, false
+		}
+	}
+	return best, bestInliers, true
+}
+
+//p3p solves the poses that see three points along their bearings (Grunert); up to four
+func p3p(p1, p2, p3 *controlPoint) []cameraPose {
+	distance := func(a, b *site.MapVector) float64 {
+		return math.Sqrt((a.VertX-b.VertX)*(a.VertX-b.VertX) + (a.VertY-b.VertY)*(a.VertY-b.VertY) + (a.VertZ-b.VertZ)*(a.VertZ-b.VertZ))
+	}
+	dot := func(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+	a, b, c := distance(p2.vector, p3.vector), distance(p1.vector, p3.vector), distance(p1.vector, p2.vector)
+	if a == 0 || b == 0 || c == 0 {
+		return nil
+	}
+	cosAlpha, cosBeta, cosGamma := dot(p2.bearing, p3.bearing), dot(p1.bearing, p3.bearing), dot(p1.bearing, p2.bearing)
+
+	a2, b2, c2 := a*a/(b*b), b*b/(b*b), c*c/(b*b)
+	amc := a2 - c2
+	apc := a2 + c2
+	coefficients := []float64{
+		(amc-1)*(amc-1) - 4*c2*cosAlpha*cosAlpha,
+		4 * (amc*(1-amc)*cosBeta - (1-apc)*cosAlpha*cosGamma + 2*c2*cosAlpha*cosAlpha*cosBeta),
+		2 * (amc*amc - 1 + 2*amc*amc*cosBeta*cosBeta + 2*(b2-c2)*cosAlpha*cosAlpha -
+			4*apc*cosAlpha*cosBeta*cosGamma + 2*(b2-a2)*cosGamma*cosGamma),
+		4 * (-amc*(1+amc)*cosBeta + 2*a2*cosGamma*cosGamma*cosBeta - (1-apc)*cosAlpha*cosGamma),
+		(1+amc)*(1+amc) - 4*a2*cosGamma*cosGamma,
+	}
+
+	var poses []cameraPose
+	for _, v := range polynomialRoots(coefficients) {
+		if v <= 0 {
+			continue
+		}
+		denominator := 2 * (cosGamma - v*cosAlpha)
+		if denominator == 0 {
+			continue
+		}
+		u := ((-1+amc)*v*v - 2*amc*cosBeta*v + 1 + amc) / denominator
+		if u <= 0 {
+			continue
+		}
+		s1 := c * c / (1 + u*u - 2*u*cosGamma)
+		if s1 <= 0 {
+			continue
+		}
+		s1 = math.Sqrt(s1)
+		var camera [3][3]float64
+		for k := 0; k < 3; k++ {
+			camera[0][k] = s1 * p1.bearing[k]
+			camera[1][k] = u * s1 * p2.bearing[k]
+			camera[2][k] = v * s1 * p3.bearing[k]
+		}
+		world := [3][3]float64{
+			{p1.vector.VertX, p1.vector.VertY, p1.vector.VertZ},
+			{p2.vector.VertX, p2.vector.VertY, p2.vector.VertZ},
+			{p3.vector.VertX, p3.vector.VertY, p3.vector.VertZ},
+		}
+		if pose, ok := alignTriangles(world, camera); ok {
+			poses = append(poses, pose)
+		}
+	}
+	return poses
+}
+
+//alignTriangles is the rigid motion taking the world triangle onto the camera one
+func alignTriangles(world, camera [3][3]float64) (cameraPose, bool) {
+	worldFrame, ok := triangleFrame(world)
+	if !ok {
+		return cameraPose{}, false
+	}
+	cameraFrame, ok := triangleFrame(camera)
+	if !ok {
+		return cameraPose{}, false
+	}
+	var pose cameraPose
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				pose.rotation[i][j] += cameraFrame[k][i] * worldFrame[k][j]
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		pose.translation[i] = camera[0][i] -
+			(pose.rotation[i][0]*world[0][0] + pose.rotation[i][1]*world[0][1] + pose.rotation[i][2]*world[0][2])
+	}
+	return pose, true
+}
+
+//triangleFrame is an orthonormal frame of a triangle: along its first edge, in its plane and normal to it
+func triangleFrame(t [3][3]float64) ([3][3]float64, bool) {
+	var frame [3][3]float64
+	edge1 := [3]float64{t[1][0] - t[0][0], t[1][1] - t[0][1], t[1][2] - t[0][2]}
+	edge2 := [3]float64{t[2][0] - t[0][0], t[2][1] - t[0][1], t[2][2] - t[0][2]}
+	normal := [3]float64{
+		edge1[1]*edge2[2] - edge1[2]*edge2[1],
+		edge1[2]*edge2[0] - edge1[0]*edge2[2],
+		edge1[0]*edge2[1] - edge1[1]*edge2[0],
+	}
+	length1 := math.Sqrt(edge1[0]*edge1[0] + edge1[1]*edge1[1] + edge1[2]*edge1[2])
+	lengthNormal := math.Sqrt(normal[0]*normal[0] + normal[1]*normal[1] + normal[2]*normal[2])
+	if length1 == 0 || lengthNormal < 1e-9*length1*length1 {
+		return frame, false
+	}
+	for k := 0; k < 3; k++ {
+		frame[0][k] = edge1[k] / length1
+		frame[2][k] = normal[k] / lengthNormal
+	}
+	frame[1] = [3]float64{
+		frame[2][1]*frame[0][2] - frame[2][2]*frame[0][1],
+		frame[2][2]*frame[0][0] - frame[2][0]*frame[0][2],
+		frame[2][0]*frame[0][1] - frame[2][1]*frame[0][0],
+	}
+	return frame, true
+}
+
+//refinePose minimizes the reprojection error by Levenberg-Marquardt over a small rotation
+//and the translation
+func refinePose(pose cameraPose, points []*controlPoint, intrinsics site.IntrinsicsConfig) cameraPose {
+	cost := func(pose cameraPose) float64 {
+		var sum float64
+		for _, point := range points {
+			e := pose.reprojection(point, intrinsics)
+			sum += e * e
+		}
+		return sum
+	}
+	current := cost(pose)
+	lambda := 1e-3
+	for iteration := 0; iteration < 50; iteration++ {
+		var normal [6][6]float64
+		var gradient [6]float64
+		for _, point := range points {
+			c := pose.toCamera(point.vector)
+			if c[2] <= 0 {
+				continue
+			}
+			observedX, observedY := intrinsics.Undistort(point.pixelX, point.pixelY)
+			residual := [2]float64{
+				intrinsics.Fx*c[0]/c[2] + intrinsics.Cx - observedX,
+				intrinsics.Fy*c[1]/c[2] + intrinsics.Cy - observedY,
+			}
+			//projection by camera point, and camera point by rotation (-[c]x) and translation (I)
+			projection := [2][3]float64{
+				{intrinsics.Fx / c[2], 0, -intrinsics.Fx * c[0] / (c[2] * c[2])},
+				{0, intrinsics.Fy / c[2], -intrinsics.Fy * c[1] / (c[2] * c[2])},
+			}
+			motion := [3][6]float64{
+				{0, c[2], -c[1], 1, 0, 0},
+				{-c[2], 0, c[0], 0, 1, 0},
+				{c[1], -c[0], 0, 0, 0, 1},
+			}
+			for row := 0; row < 2; row++ {
+				var jacobian [6]float64
+				for j := 0; j < 6; j++ {
+					for k := 0; k < 3; k++ {
+						jacobian[j] += projection[row][k] * motion[k][j]
+					}
+				}
+				for i := 0; i < 6; i++ {
+					gradient[i] += jacobian[i] * residual[row]
+					for j := 0; j < 6; j++ {
+						normal[i][j] += jacobian[i] * jacobian[j]
+					}
+				}
+			}
+		}
+
+		improved := false
+		for attempt := 0; attempt < 10 && !improved; attempt++ {
+			damped := normal
+			for i := 0; i < 6; i++ {
+				damped[i][i] += lambda * (normal[i][i] + 1e-12)
+			}
+			step, ok := solveLinear6(damped, gradient)
+			if !ok {
+				break
+			}
+			candidate := pose.updated(step)
+			if next := cost(candidate); next < current {
+				improved = current-next > 1e-12*current
+				pose, current = candidate, next
+				lambda = math.Max(lambda/10, 1e-9)
+				if !improved {
+					return pose
+				}
+			} else {
+				lambda *= 10
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+	return pose
+}
+
+//updated moves the pose back by step: a rotation vector applied before it and a translation
+func (p cameraPose) updated(step [6]float64) cameraPose {
+	rotation := rodrigues(-step[0], -step[1], -step[2])
+	var result cameraPose
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result.rotation[i][j] += rotation[i][k] * p.rotation[k][j]
+			}
+		}
+		for k := 0; k < 3; k++ {
+			result.translation[i] += rotation[i][k] * p.translation[k]
+		}
+		result.translation[i] -= step[3+i]
+	}
+	return result
+}
+
+func rodrigues(x, y, z float64) [3][3]float64 {
+	angle := math.Sqrt(x*x + y*y + z*z)
+	if angle == 0 {
+		return [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	}
+	x, y, z = x/angle, y/angle, z/angle
+	s, c := math.Sin(angle), math.Cos(angle)
+	t := 1 - c
+	return [3][3]float64{
+		{t*x*x + c, t*x*y - s*z, t*x*z + s*y},
+		{t*x*y + s*z, t*y*y + c, t*y*z - s*x},
+		{t*x*z - s*y, t*y*z + s*x, t*z*z + c},
+	}
+}
+
+//solveLinear6 solves the normal equations by Gaussian elimination with partial pivoting
+func solveLinear6(a [6][6]float64, b [6]float64) ([6]float64, bool) {
+	var x [6]float64
+	for column := 0; column < 6; column++ {
+		pivot := column
+		for row := column + 1; row < 6; row++ {
+			if math.Abs(a[row][column]) > math.Abs(a[pivot][column]) {
+				pivot = row
+			}
+		}
+		if a[pivot][column] == 0 {
+			return x, false
+		}
+		a[column], a[pivot] = a[pivot], a[column]
+		b[column], b[pivot] = b[pivot], b[column]
+		for row := column + 1; row < 6; row++ {
+			factor := a[row][column] / a[column][column]
+			for k := column; k < 6; k++ {
+				a[row][k] -= factor * a[column][k]
+			}
+			b[row] -= factor * b[column]
+		}
+	}
+	for row := 5; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < 6; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, true
+}
+
+//polynomialRoots finds the real roots of a polynomial, highest power first; between the
+//roots of its derivative it is monotonic, so every sign change there is bisected
+func polynomialRoots(coefficients []float64) []float64 {
+	for len(coefficients) > 0 && coefficients[0] == 0 {
+		coefficients = coefficients[1:]
+	}
+	degree := len(coefficients) - 1
+	if degree < 1 {
+		return nil
+	}
+	if degree == 1 {
+		return []float64{-coefficients[1] / coefficients[0]}
+	}
+
+	evaluate := func(x float64) float64 {
+		var y float64
+		for _, c := range coefficients {
+			y = y*x + c
+		}
+		return y
+	}
+	//every root is within the Cauchy bound
+	bound := 0.0
+	for _, c := range coefficients[1:] {
+		bound = math.Max(bound, math.Abs(c/coefficients[0]))
+	}
+	bound++
+
+	derivative := make([]float64, degree)
+	for i := range derivative {
+		derivative[i] = coefficients[i] * float64(degree-i)
+	}
+	edges := []float64{-bound}
+	for _, x := range polynomialRoots(derivative) {
+		if x > -bound && x < bound {
+			edges = append(edges, x)
+		}
+	}
+	edges = append(edges, bound)
+	sort.Float64s(edges)
+
+	var roots []float64
+	for i := 0; i+1 < len(edges); i++ {
+		low, high := edges[i], edges[i+1]
+		lowValue, highValue := evaluate(low), evaluate(high)
+		if lowValue == 0 {
+			roots = append(roots, low)
+			continue
+		}
+		if lowValue*highValue > 0 {
+			continue
+		}
+		for k := 0; k < 200 && high-low > 1e-15*math.Max(1, math.Abs(low)); k++ {
+			middle := (low + high) / 2
+			if middleValue := evaluate(middle); middleValue*lowValue > 0 {
+				low, lowValue = middle, middleValue
+			} else {
+				high = middle
+			}
+		}
+		roots = append(roots, (low+high)/2)
+	}
+	if evaluate(bound) == 0 {
+		roots = append(roots, bound)
+	}
+	return roots
+}
+
+//readControlPoints reads a CSV with a header naming the pixelX, pixelY, latitude, longitude
+//and elevation columns; pixels are of the camera image like the picks
+func readControlPoints(path string) ([]*controlPoint, error) {
+	var points []*controlPoint
+	columns := []csvColumn{pixelXColumn, pixelYColumn, latColumn, lngColumn, elevationColumn}
+	err := readCSVColumns(path, columns, func(line int, fields []string) error {
+		values, err := parseFloats(fields)
+		if err != nil {
+			return err
+		}
+		points = append(points, &controlPoint{
+			pixelX: values[0],
+			pixelY: values[1],
+			vector: &site.MapVector{
+				Latitude:   values[2],
+				Longtitude: values[3],
+				Elevation:  values[4],
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
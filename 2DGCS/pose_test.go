@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/nomnom-ray/fauxgl"
+
+	"github.com/nomnom-ray/golang/site"
+)
+
+func TestPolynomialRoots(t *testing.T) {
+	tests := []struct {
+		name         string
+		coefficients []float64
+		roots        []float64
+	}{
+		{"linear", []float64{2, -3}, []float64{1.5}},
+		{"quadratic", []float64{1, -1, -6}, []float64{-2, 3}},
+		{"quadratic without real roots", []float64{1, 0, 1}, nil},
+		{"cubic", []float64{1, -6, 11, -6}, []float64{1, 2, 3}},
+		{"quartic", []float64{1, -2.5, -4, 8.5, -3}, []float64{-2, 0.5, 1, 3}},
+		{"quartic of two real roots", []float64{1, 0, 0, 0, -16}, []float64{-2, 2}},
+		{"leading zeros", []float64{0, 0, 1, -2, 0}, []float64{0, 2}},
+		{"constant", []float64{5}, nil},
+	}
+	for _, test := range tests {
+		roots := polynomialRoots(test.coefficients)
+		sort.Float64s(roots)
+		if len(roots) != len(test.roots) {
+			t.Errorf("%s: roots %v, want %v", test.name, roots, test.roots)
+			continue
+		}
+		for i, root := range roots {
+			if math.Abs(root-test.roots[i]) > 1e-6 {
+				t.Errorf("%s: roots %v, want %v", test.name, roots, test.roots)
+				break
+			}
+		}
+	}
+}
+
+func TestPoseRoundTrip(t *testing.T) {
+	properties := &site.ModelProperties{
+		MinVertX: -300, MinVertY: 5, MinVertZ: -250, MaxVert: 600,
+		OriginLatitude: 43.45, OriginLongitude: -80.49, OriginElevation: 330,
+	}
+	render := site.RenderConfig{Width: 1280, Height: 720, Scale: 1, Fovy: 60}
+	tests := []struct {
+		name       string
+		intrinsics site.IntrinsicsConfig
+	}{
+		{"pinhole of fovy", site.IntrinsicsConfig{}},
+		{"calibrated", site.IntrinsicsConfig{Fx: 900, Fy: 905, Cx: 630, Cy: 370, K1: -0.2, K2: 0.05, P1: 0.001}},
+	}
+	for n, test := range tests {
+		camera := site.CameraConfig{
+			Latitude: 43.4505, Longitude: -80.4895, Elevation: 12, Height: 2.5,
+			RotationLR: -130, RotationUD: -15, Roll: 3, Intrinsics: test.intrinsics,
+		}
+		intrinsics := camera.Intrinsics
+		if !intrinsics.Calibrated() {
+			intrinsics = pinholeIntrinsics(render)
+		}
+		view := poseView(properties, camera, render)
+
+		//control points on the ground in view, with some noise and two far off
+		random := rand.New(rand.NewSource(int64(n)))
+		var points []*controlPoint
+		for len(points) < 14 {
+			vector := &site.MapVector{
+				Latitude:   camera.Latitude + (random.Float64()-0.5)*0.004,
+				Longtitude: camera.Longitude + (random.Float64()-0.5)*0.004,
+				Elevation:  properties.OriginElevation + random.Float64()*3,
+			}
+			properties.Localize(vector)
+			x, y, ok := posePixel(properties, view, render, vector)
+			if !ok || x < 0 || x > float64(render.Width-1) || y < 0 || y > float64(render.Height-1) {
+				continue
+			}
+			if camera.Intrinsics.Calibrated() {
+				x, y = intrinsics.Distort(x, y)
+			}
+			x += random.NormFloat64() * 0.3
+			y += random.NormFloat64() * 0.3
+			points = append(points, &controlPoint{pixelX: x, pixelY: y, vector: vector})
+		}
+		outliers := map[int]bool{3: true, 9: true}
+		points[3].pixelX += 80
+		points[9].pixelY -= 60
+		for _, point := range points {
+			x, y := intrinsics.Undistort(point.pixelX, point.pixelY)
+			x, y = (x-intrinsics.Cx)/intrinsics.Fx, (y-intrinsics.Cy)/intrinsics.Fy
+			length := math.Sqrt(x*x + y*y + 1)
+			point.bearing = [3]float64{x / length, y / length, 1 / length}
+		}
+
+		pose, inliers, ok := solvePnP(points, intrinsics, 300, 3, rand.New(rand.NewSource(1)))
+		if !ok {
+			t.Fatalf("%s: no pose", test.name)
+		}
+		for i, inlier := range inliers {
+			if inlier == outliers[i] {
+				t.Errorf("%s: control point %d inlier %v", test.name, i, inlier)
+			}
+		}
+		//the noise is 0.3 pixels each way
+		if rms, count := reprojectionRMS(pose, points, inliers, intrinsics); count != len(points)-len(outliers) || rms < 0.1 || rms > 1 {
+			t.Errorf("%s: reprojection RMS %.3f of %d control points", test.name, rms, count)
+		}
+
+		solved := poseCamera(properties, pose, camera)
+		const metre = 1.0 / 111000
+		if math.Abs(solved.Latitude-camera.Latitude) > 0.5*metre ||
+			math.Abs(solved.Longitude-camera.Longitude)*math.Cos(site.DegToRad(camera.Latitude)) > 0.5*metre ||
+			math.Abs(solved.Elevation-camera.Elevation) > 0.5 || solved.Height != camera.Height {
+			t.Errorf("%s: camera at %v, %v, %v, want %v, %v, %v", test.name,
+				solved.Latitude, solved.Longitude, solved.Elevation, camera.Latitude, camera.Longitude, camera.Elevation)
+		}
+		if math.Abs(solved.RotationLR-camera.RotationLR) > 0.3 || math.Abs(solved.RotationUD-camera.RotationUD) > 0.3 ||
+			math.Abs(solved.Roll-camera.Roll) > 0.3 {
+			t.Errorf("%s: camera turned %v, %v, %v, want %v, %v, %v", test.name,
+				solved.RotationLR, solved.RotationUD, solved.Roll, camera.RotationLR, camera.RotationUD, camera.Roll)
+		}
+
+		//the camera made of the pose sees the control points where the pose does
+		solvedView := poseView(properties, solved, render)
+		for i, point := range points {
+			x, y, ok := posePixel(properties, solvedView, render, point.vector)
+			wantX, wantY, _ := pose.project(point.vector, intrinsics)
+			if !ok || math.Hypot(x-wantX, y-wantY) > 0.01 {
+				t.Errorf("%s: control point %d at %.3f, %.3f, want %.3f, %.3f", test.name, i, x, y, wantX, wantY)
+			}
+		}
+	}
+}
+
+//poseView is the matrix site.CameraModel makes of a camera
+func poseView(properties *site.ModelProperties, camera site.CameraConfig, render site.RenderConfig) fauxgl.Matrix {
+	location := &site.MapVector{
+		Latitude:   camera.Latitude,
+		Longtitude: camera.Longitude,
+		Elevation:  camera.Elevation + properties.OriginElevation,
+	}
+	properties.Localize(location)
+	return site.CameraModel(properties.MaxVert, location, camera, render)
+}
+
+//posePixel is the undistorted pixel a camera matrix puts a model vector at
+func posePixel(properties *site.ModelProperties, view fauxgl.Matrix, render site.RenderConfig, v *site.MapVector) (float64, float64, bool) {
+	w := view.MulPositionW(fauxgl.Vector{X: v.VertX / properties.MaxVert, Y: v.VertY / properties.MaxVert, Z: v.VertZ / properties.MaxVert})
+	if w.W <= 0 {
+		return 0, 0, false
+	}
+	x := (w.X/w.W+1)/2*float64(render.Width) - 0.5
+	y := (1-w.Y/w.W)/2*float64(render.Height) - 0.5
+	return x, y, true
+}
+
+func TestSavePoseCamera(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "site.json")
+	output := filepath.Join(dir, "solved.json")
+	written := `{"render": {"width": 640, "height": 480},
+		"camera": {"latitude": 43.45, "longitude": -80.49, "elevation": 10},
+		"cameras": {"north": {"latitude": 43.46, "longitude": -80.49, "elevation": 20, "imageWidth": 320}}}`
+	if err := ioutil.WriteFile(configFile, []byte(written), 0644); err != nil {
+		t.Fatal(err)
+	}
+	solved := site.CameraConfig{Latitude: 43.451, Longitude: -80.491, Elevation: 12, RotationLR: 30, RotationUD: -10, Roll: 1}
+
+	for _, id := range []string{"", "north"} {
+		//the scene as the flags left it
+		scene, err := site.LoadSceneConfig(configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "" {
+			if err := scene.UseCamera(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		scene.Render.Height = 960
+		scene.Camera = solved
+		if err := savePoseCamera(configFile, output, scene); err != nil {
+			t.Fatal(err)
+		}
+
+		saved, err := site.LoadSceneConfig(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Render.Width != 640 || saved.Render.Height != 480 {
+			t.Errorf("camera %q: render %dx%d saved, want 640x480 of the file", id, saved.Render.Width, saved.Render.Height)
+		}
+		//the other camera is the one of the file
+		file, _ := site.LoadSceneConfig(configFile)
+		camera, other, wantOther := saved.Camera, saved.Cameras["north"].CameraConfig, file.Cameras["north"].CameraConfig
+		if id != "" {
+			camera, other, wantOther = saved.Cameras["north"].CameraConfig, saved.Camera, file.Camera
+		}
+		if camera != solved || other != wantOther {
+			t.Errorf("camera %q: saved %+v and %+v, want %+v and %+v", id, camera, other, solved, wantOther)
+		}
+		if saved.Cameras["north"].ImageWidth != 320 {
+			t.Errorf("camera %q: the image width of the registry is lost", id)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//16-bit depth PNGs are in centimetres, 0 where there is no terrain
+const depthPNGScale = 100
+
+//ambient light of the lambert shading, so terrain facing away from the light is not black
+const ambient = 0.25
+
+//normalShader colours the terrain by its normal in east, north and up, each from -1 to 1 as 0 to 1
+type normalShader struct {
+	matrix fauxgl.Matrix
+}
+
+func (s *normalShader) Vertex(v fauxgl.Vertex) fauxgl.Vertex {
+	v.Output = s.matrix.MulPositionW(v.Position)
+	return v
+}
+
+func (s *normalShader) Fragment(v fauxgl.Vertex) fauxgl.Color {
+	//the model is x north, y down, z west
+	normal := v.Normal.Normalize()
+	return fauxgl.Color{R: (1 - normal.Z) / 2, G: (1 + normal.X) / 2, B: (1 - normal.Y) / 2, A: 1}
+}
+
+//lambertShader shades the terrain by the angle between its normal and the light direction;
+//slopes facing away from the light only get the ambient light
+type lambertShader struct {
+	matrix fauxgl.Matrix
+	light  fauxgl.Vector
+	color  fauxgl.Color
+}
+
+func (s *lambertShader) Vertex(v fauxgl.Vertex) fauxgl.Vertex {
+	v.Output = s.matrix.MulPositionW(v.Position)
+	return v
+}
+
+func (s *lambertShader) Fragment(v fauxgl.Vertex) fauxgl.Color {
+	diffuse := math.Max(0, v.Normal.Normalize().Dot(s.light))
+	return s.color.MulScalar(ambient + (1-ambient)*diffuse).Alpha(1)
+}
+
+//renderImage renders the terrain from the camera in one of the modes and writes it to path:
+//	solid: the terrain in its colour, as projection renders it
+//	lambert: shaded by the light direction
+//	wireframe: the edges of the primitives over the lambert shading
+//	edges: only the edges of the primitives, those the terrain hides left out
+//	normal: the normal map, east, north and up in red, green and blue
+//	heat: coloured by depth, red nearest to blue farthest
+//	depth: metres along the view; a 16-bit .png in centimetres, or a float64 .tif with NaN where
+//	there is no terrain
+func renderImage(mode, path string, maxVert float64, cameraPerspective fauxgl.Matrix, render site.RenderConfig,
+	compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) error {
+
+	if mode == "depth" {
+		return writeDepth(path, renderDepth(maxVert, cameraPerspective, render, compositeVector, primitiveIndex), maxVert)
+	}
+	rendered, err := terrainImage(mode, maxVert, cameraPerspective, render, compositeVector, primitiveIndex)
+	if err != nil {
+		return err
+	}
+	return fauxgl.SavePNG(path, rendered)
+}
+
+//terrainImage is the terrain from the camera in one of the colour modes of renderImage, at the
+//render size; transparent where there is no terrain
+func terrainImage(mode string, maxVert float64, cameraPerspective fauxgl.Matrix, render site.RenderConfig,
+	compositeVector []*site.MapVector, primitiveIndex []*site.MapPrimitiveIndex) (image.Image, error) {
+
+	if mode == "heat" {
+		return heatImage(renderDepth(maxVert, cameraPerspective, render, compositeVector, primitiveIndex), maxVert), nil
+	}
+
+	mesh := fauxgl.NewTriangleMesh(modelTriangles(maxVert, compositeVector, primitiveIndex))
+	contextRender := fauxgl.NewContext(render.Width*render.Scale, render.Height*render.Scale)
+	contextRender.SetPickingFlag(false)
+	contextRender.ClearColorBufferWith(fauxgl.Transparent)
+	switch mode {
+	case "solid":
+		contextRender.Shader = fauxgl.NewSolidColorShader(cameraPerspective, color)
+	case "lambert", "wireframe":
+		contextRender.Shader = &lambertShader{cameraPerspective, light, color}
+	case "edges":
+		//the terrain only fills the depth buffer
+		contextRender.Shader = fauxgl.NewSolidColorShader(cameraPerspective, color)
+		contextRender.WriteColor = false
+	case "normal":
+		contextRender.Shader = &normalShader{cameraPerspective}
+	default:
+		return nil, fmt.Errorf("render: unknown mode %q; use solid, lambert, wireframe, edges, normal, heat or depth", mode)
+	}
+	contextRender.DrawMesh(mesh)
+
+	//the edges are drawn again over the terrain, a little nearer so they win the depth test
+	if mode == "wireframe" || mode == "edges" {
+		contextRender.Shader = fauxgl.NewSolidColorShader(cameraPerspective, fauxgl.Black)
+		contextRender.WriteColor = true
+		contextRender.Wireframe = true
+		contextRender.LineWidth = float64(render.Scale)
+		contextRender.DepthBias = -1e-5
+		contextRender.DrawMesh(mesh)
+	}
+
+	rendered := contextRender.Image()
+	return resize.Resize(uint(render.Width), uint(render.Height), rendered, resize.Bilinear), nil
+}
+
+//heatImage colours the depth from red at the nearest terrain to blue at the farthest
+func heatImage(depth *depthBuffer, maxVert float64) image.Image {
+	width, height, metres := depthMetres(depth, maxVert)
+	nearest, farthest := math.Inf(1), math.Inf(-1)
+	for _, value := range metres {
+		if !math.IsNaN(value) {
+			nearest, farthest = math.Min(nearest, value), math.Max(farthest, value)
+		}
+	}
+	heat := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, value := range metres {
+		if math.IsNaN(value) {
+			continue
+		}
+		t := 0.0
+		if farthest > nearest {
+			t = (value - nearest) / (farthest - nearest)
+		}
+		//the hue goes from red at 0 to blue at 240 degrees
+		hue := t * 4
+		var c [3]float64
+		switch {
+		case hue < 1:
+			c = [3]float64{1, hue, 0}
+		case hue < 2:
+			c = [3]float64{2 - hue, 1, 0}
+		case hue < 3:
+			c = [3]float64{0, 1, hue - 2}
+		default:
+			c = [3]float64{0, 4 - hue, 1}
+		}
+		heat.Pix[i*4], heat.Pix[i*4+1], heat.Pix[i*4+2], heat.Pix[i*4+3] =
+			uint8(c[0]*255), uint8(c[1]*255), uint8(c[2]*255), 255
+	}
+	return heat
+}
+
+//depthMetres is the depth of the centre sample of every pixel in metres, NaN where there is no terrain
+func depthMetres(depth *depthBuffer, maxVert float64) (int, int, []float64) {
+	width, height := depth.width/depth.scale, depth.height/depth.scale
+	metres := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			window := depth.depth[(y*depth.scale+depth.scale/2)*depth.width+x*depth.scale+depth.scale/2]
+			metres[y*width+x] = math.NaN()
+			if window <= 1 {
+				metres[y*width+x] = eyeDistance(window) * maxVert
+			}
+		}
+	}
+	return width, height, metres
+}
+
+//writeDepth writes the depth of the centre sample of every pixel
+func writeDepth(path string, depth *depthBuffer, maxVert float64) error {
+	width, height, metres := depthMetres(depth, maxVert)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tif", ".tiff":
+		return writeFloatTIFF(path, width, height, 1, metres, nil)
+	case ".png":
+		gray := image.NewGray16(image.Rect(0, 0, width, height))
+		for i, value := range metres {
+			if !math.IsNaN(value) {
+				gray.Pix[i*2], gray.Pix[i*2+1] = uint8(depthCentimetres(value)>>8), uint8(depthCentimetres(value))
+			}
+		}
+		return fauxgl.SavePNG(path, gray)
+	}
+	return fmt.Errorf("render: depth is written to .png or .tif, not %s", path)
+}
+
+//depthCentimetres is a depth in a 16-bit PNG; beyond 655.35 metres is the farthest it holds
+func depthCentimetres(metres float64) uint16 {
+	return uint16(math.Min(math.Round(metres*depthPNGScale), math.MaxUint16))
+}
+
+//renderCommand is "2DGCS render -mode lambert -o terrain.png": renders the terrain from the camera
+//in one of the modes of renderImage
+func renderCommand(args []string) {
+	commandFlags := flag.NewFlagSet("render", flag.ExitOnError)
+	mode := commandFlags.String("mode", "solid", "solid, lambert, wireframe, edges, normal, heat or depth")
+	output := commandFlags.String("o", "", "image written; .png, or .tif for depth in float metres; the mode.png when empty")
+	configFile, applySceneFlags := site.SceneFlags(commandFlags)
+	commandFlags.Parse(args)
+	if *output == "" {
+		*output = *mode + ".png"
+	}
+
+	model, err := loadSceneModel(*configFile, applySceneFlags)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	view, err := model.cameraView(true)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	if err := renderImage(*mode, *output, view.maxVert, view.cameraPerspective, model.scene.Render,
+		view.compositeVector, view.primitiveIndex); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fmt.Println(*mode, "render written to", *output)
+}
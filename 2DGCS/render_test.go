@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestDepthCentimetres(t *testing.T) {
+	tests := []struct {
+		metres      float64
+		centimetres uint16
+	}{
+		{0, 0},
+		{0.004, 0},
+		{0.006, 1},
+		{12.344, 1234},
+		{12.346, 1235},
+		{655.35, 65535},
+		{655.36, 65535},
+		{10000, 65535},
+	}
+	for _, test := range tests {
+		if centimetres := depthCentimetres(test.metres); centimetres != test.centimetres {
+			t.Errorf("%v metres in %d centimetres, want %d", test.metres, centimetres, test.centimetres)
+		}
+	}
+}
+
+func TestHeatImage(t *testing.T) {
+	const maxVert = 100
+	//four pixels of 2x2 samples; the samples off the centre are far away, and only the centre counts
+	newDepth := func(metres ...float64) *depthBuffer {
+		depth := &depthBuffer{width: len(metres) * 2, height: 2, scale: 2, depth: make([]float64, len(metres)*4)}
+		for i := range depth.depth {
+			depth.depth[i] = windowDepth(500, maxVert)
+		}
+		for x, value := range metres {
+			depth.depth[depth.width+x*2+1] = 2
+			if value > 0 {
+				depth.depth[depth.width+x*2+1] = windowDepth(value, maxVert)
+			}
+		}
+		return depth
+	}
+	red, green, blue := [4]uint8{255, 0, 0, 255}, [4]uint8{0, 255, 0, 255}, [4]uint8{0, 0, 255, 255}
+
+	tests := []struct {
+		name   string
+		metres []float64 //0 where there is no terrain
+		colors [][4]uint8
+	}{
+		{"near to far", []float64{10, 20, 30, 0}, [][4]uint8{red, green, blue, {}}},
+		{"far to near", []float64{0, 40, 25, 10}, [][4]uint8{{}, blue, green, red}},
+		{"flat", []float64{15, 15, 15, 15}, [][4]uint8{red, red, red, red}},
+		{"an eighth of the way", []float64{10, 12.5, 30, 10}, [][4]uint8{red, {255, 127, 0, 255}, blue, red}},
+	}
+	for _, test := range tests {
+		depth := newDepth(test.metres...)
+		width, height, metres := depthMetres(depth, maxVert)
+		if width != len(test.metres) || height != 1 {
+			t.Fatalf("%s: %dx%d pixels, want %dx1", test.name, width, height, len(test.metres))
+		}
+		for x, value := range metres {
+			if want := test.metres[x]; want == 0 && !math.IsNaN(value) || want > 0 && math.Abs(value-want) > 1e-6 {
+				t.Errorf("%s: pixel %d is %v metres away, want %v", test.name, x, value, want)
+			}
+		}
+
+		heat := heatImage(depth, maxVert)
+		if bounds := heat.Bounds(); bounds.Dx() != len(test.metres) || bounds.Dy() != 1 {
+			t.Fatalf("%s: heat image of %v, want %dx1", test.name, bounds, len(test.metres))
+		}
+		for x, want := range test.colors {
+			pixel := heat.(*image.NRGBA).Pix[x*4:]
+			if c := [4]uint8{pixel[0], pixel[1], pixel[2], pixel[3]}; c != want {
+				t.Errorf("%s: pixel %d is %v, want %v", test.name, x, c, want)
+			}
+		}
+	}
+}
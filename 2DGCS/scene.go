@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//sceneModel is what the commands start from: the scene config, with the flags of the command
+//applied, and the model of its scene
+type sceneModel struct {
+	scene           *site.SceneConfig
+	properties      *site.ModelProperties
+	compositeVector []*site.MapVector
+	primitiveIndex  []*site.MapPrimitiveIndex
+}
+
+//loadSceneModel loads the scene config of a command, applies its flags and loads the model
+func loadSceneModel(configFile string, applySceneFlags func(*site.SceneConfig) error) (*sceneModel, error) {
+	scene, err := site.LoadSceneConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySceneFlags(scene); err != nil {
+		return nil, err
+	}
+	return newSceneModel(scene)
+}
+
+//newSceneModel loads the model of a scene config
+func newSceneModel(scene *site.SceneConfig) (*sceneModel, error) {
+	vectorPath, primitivePath := scene.ModelFiles()
+	properties, compositeVector, primitiveIndex, err := site.LoadModel(vectorPath, primitivePath, scene.Scene)
+	if err != nil {
+		return nil, err
+	}
+	return &sceneModel{
+		scene:           scene,
+		properties:      properties,
+		compositeVector: compositeVector,
+		primitiveIndex:  primitiveIndex,
+	}, nil
+}
+
+//cameraView is the camera of the scene in the normalized model, and the terrain it renders
+type cameraView struct {
+	maxVert           float64
+	location          *site.MapVector
+	cameraPerspective fauxgl.Matrix
+	compositeVector   []*site.MapVector
+	primitiveIndex    []*site.MapPrimitiveIndex
+	terrain           *site.TerrainTree //nil for the whole model
+}
+
+//cameraView places the camera of the scene in the model; with lod the terrain is that of the
+//level of detail the camera sees, otherwise the whole model
+func (m *sceneModel) cameraView(lod bool) (*cameraView, error) {
+	maxVert := m.properties.MaxVert
+	location := site.Modeller(m.properties, &site.MapVector{
+		Latitude:   m.scene.Camera.Latitude,
+		Longtitude: m.scene.Camera.Longitude,
+		Elevation:  m.scene.Camera.Elevation,
+	})
+	view := &cameraView{
+		maxVert:           maxVert,
+		location:          location,
+		cameraPerspective: site.CameraModel(maxVert, location, m.scene.Camera, m.scene.Render),
+		compositeVector:   m.compositeVector,
+		primitiveIndex:    m.primitiveIndex,
+	}
+	if !lod {
+		return view, nil
+	}
+	terrain, err := site.NewTerrain(m.scene.Scene, m.properties, m.compositeVector, m.primitiveIndex, m.scene.LOD)
+	if err != nil {
+		return nil, err
+	}
+	view.compositeVector = terrain.CompositeVector
+	view.primitiveIndex = terrain.SelectLOD(site.CameraEye(location, m.scene.Camera), view.cameraPerspective, maxVert)
+	view.terrain = terrain
+	return view, nil
+}
+
+//scenePick maps a pick on the level of detail back to the scene: the primitive of the whole model
+//under it, numbered as in the scene file and the lookup, and the GCS on that primitive
+func (v *cameraView) scenePick(picked *pickedPoint) (*pickedPoint, bool) {
+	if v.terrain == nil {
+		return picked, true
+	}
+	primitive, position, gcs, ok := v.terrain.ScenePick(picked.Position, v.maxVert)
+	if !ok {
+		return nil, false
+	}
+	scenePicked := *picked
+	scenePicked.PrimitiveID, scenePicked.Position, scenePicked.GCS = primitive, position, gcs
+	return &scenePicked, true
+}
+
+//sceneCommand is "2DGCS scene [-o resultNormModel.scene]": converts a model built
+//into resultNormModel.csv and resultNormModelProperties.csv to the scene file
+func sceneCommand(args []string) {
+	commandFlags := flag.NewFlagSet("scene", flag.ExitOnError)
+	model := commandFlags.String("model", "resultNormModel.csv", "normalized model CSV")
+	primitives := commandFlags.String("primitives", "resultPrimativeModel.csv", "primitive index CSV")
+	propertiesFile := commandFlags.String("properties", "resultNormModelProperties.csv", "model properties CSV")
+	output := commandFlags.String("o", "resultNormModel.scene", "scene file")
+	commandFlags.Parse(args)
+
+	properties, err := site.LoadModelProperties(*propertiesFile)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	compositeVector, primitiveIndex, err := site.LoadVectorModel(*model, *primitives)
+	if err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	if err := site.SaveScene(*output, properties, compositeVector, primitiveIndex); err != nil {
+		log.Fatalf("fatal error: %s", err)
+	}
+	fmt.Println(len(compositeVector), "vectors,", len(primitiveIndex), "primitives written to", *output)
+}
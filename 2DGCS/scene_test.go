@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nomnom-ray/fauxgl"
+	"github.com/nomnom-ray/golang/site"
+)
+
+//a pick is on the level of detail the camera sees, and mapped back to the whole model: the primitive
+//of the scene under where the ray meets the terrain and the GCS on it, which is where the ray meets
+//the scene wherever the terrain was not thinned out
+func TestPickingOnTheLevelOfDetail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the south half of the grid is flat, which the level of detail decimates; the north half rolls
+	vectors, rows, cols := planGrid(site.AreaConfig{
+		LatStart: 43.4500, LngStart: -80.4900, LatEnd: 43.4504, LngEnd: -80.4895,
+		ResolutionLat: 0.00005, ResolutionLng: 0.00005,
+	})
+	for i, vector := range vectors {
+		vector.Elevation = 330
+		if row := i / cols; row > rows/2 {
+			vector.Elevation += 1 + math.Sin(float64(row)/2)*math.Cos(float64(i%cols)/3)
+		}
+	}
+	primitiveIndex, err := triangulateGrid(rows, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scene := site.DefaultSceneConfig()
+	scene.Scene = filepath.Join(dir, "grid.scene")
+	scene.Camera = overhead
+	scene.Render = site.RenderConfig{Width: 160, Height: 90, Scale: 1, Fovy: 60, Picker: "raycast"}
+	properties, vectors, primitiveIndex, err := site.BuildScene(vectors, primitiveIndex, scene.Scene)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := &sceneModel{scene: scene, properties: properties, compositeVector: vectors, primitiveIndex: primitiveIndex}
+
+	view, err := model.cameraView(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(view.primitiveIndex) >= len(primitiveIndex) {
+		t.Fatalf("the level of detail keeps %d of %d primitives; the test needs a thinned out terrain",
+			len(view.primitiveIndex), len(primitiveIndex))
+	}
+	triangles := modelTriangles(view.maxVert, view.compositeVector, view.primitiveIndex)
+	bvh := newTriangleBVH(triangles)
+
+	position := func(i int) fauxgl.Vector {
+		vector := vectors[i]
+		return fauxgl.Vector{X: vector.VertX / view.maxVert, Y: vector.VertY / view.maxVert, Z: vector.VertZ / view.maxVert}
+	}
+	inverse := view.cameraPerspective.Inverse()
+	hits, flat, same := 0, 0, 0
+	for y := 0; y < scene.Render.Height; y += 6 {
+		for x := 0; x < scene.Render.Width; x += 8 {
+			//the nearest primitive of the scene the ray of the pixel meets, by brute force
+			origin, direction := site.PixelRay(inverse, float64(x), float64(y), scene.Render)
+			want, nearest := -1, math.Inf(1)
+			var wantGCS fauxgl.Vector
+			for i, index := range primitiveIndex {
+				weights, distance, ok := site.Barycentric(origin, direction, position(index.PrimitiveBottom),
+					position(index.PrimitiveTop), position(index.PrimitiveLeft))
+				if !ok || distance < 0 || distance >= nearest || weights.X < 0 || weights.Y < 0 || weights.Z < 0 {
+					continue
+				}
+				a, b, c := vectors[index.PrimitiveBottom], vectors[index.PrimitiveTop], vectors[index.PrimitiveLeft]
+				want, nearest = i, distance
+				wantGCS = fauxgl.Vector{
+					X: weights.X*a.Latitude + weights.Y*b.Latitude + weights.Z*c.Latitude,
+					Y: weights.X*a.Elevation + weights.Y*b.Elevation + weights.Z*c.Elevation,
+					Z: weights.X*a.Longtitude + weights.Y*b.Longtitude + weights.Z*c.Longtitude,
+				}
+			}
+
+			picked, ok := picking(scene.Render.Picker, float64(x), float64(y), triangles, bvh, nil,
+				view.cameraPerspective, scene.Render)
+			if ok {
+				picked, ok = view.scenePick(picked)
+			}
+			if ok != (want >= 0) {
+				t.Errorf("pixel %d, %d: picked %t, the ray meets primitive %d", x, y, ok, want)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			hits++
+			//on flat ground the terrain is the scene; elsewhere the pick is off by what the terrain
+			//was thinned out by
+			if wantGCS.Y == 330 && picked.GCS.Y == 330 {
+				flat++
+				if picked.PrimitiveID == want {
+					same++
+				}
+				if math.Abs(picked.GCS.X-wantGCS.X) > 1e-9 || math.Abs(picked.GCS.Z-wantGCS.Z) > 1e-9 {
+					t.Errorf("flat pixel %d, %d picks primitive %d at %v, want %d at %v", x, y,
+						picked.PrimitiveID, picked.GCS, want, wantGCS)
+				}
+			} else if picked.PrimitiveID >= len(primitiveIndex) || math.Abs(picked.GCS.X-wantGCS.X) > 1e-6 ||
+				math.Abs(picked.GCS.Y-wantGCS.Y) > 2*scene.LOD.MaxError || math.Abs(picked.GCS.Z-wantGCS.Z) > 1e-6 {
+				t.Errorf("pixel %d, %d picks primitive %d at %v, want about %v", x, y,
+					picked.PrimitiveID, picked.GCS, wantGCS)
+			}
+		}
+	}
+	//at an edge the primitive next to the one the ray meets is as good
+	if hits == 0 || flat == 0 || same < flat*9/10 {
+		t.Fatalf("%d pixels pick the grid, %d of them on flat ground, %d of those the primitive the ray meets",
+			hits, flat, same)
+	}
+}
@@ -0,0 +1,94 @@
+package site
+
+import (
+	"math"
+
+	"github.com/nomnom-ray/fauxgl"
+)
+
+const (
+	degRadConversion = math.Pi / 180
+
+	Near = 0.001 // near clipping plane
+	Far  = 10.0  // far clipping plane
+)
+
+//CameraModel is the matrix from the model normalized by maxVert to the image of the camera
+func CameraModel(maxVert float64, cameraLocation *MapVector, camera CameraConfig, render RenderConfig) fauxgl.Matrix {
+	// camera and projection parameters to create a single matrix
+	cameraRotationLR := camera.RotationLR //-ve rotates camera clockwise in degrees
+	cameraRotationUD := camera.RotationUD //-ve rotates camera downwards in degrees
+
+	cameraPosition := CameraEye(cameraLocation, camera).DivScalar(maxVert)
+	cameraViewDirection := fauxgl.Vector{
+		X: 0,
+		Y: 0,
+		Z: 1,
+	}
+	cameraUp := fauxgl.Vector{
+		X: 0,
+		Y: -1,
+		Z: 0,
+	}
+	cameraViewDirection = fauxgl.QuatRotate(
+		DegToRad(cameraRotationLR), cameraUp).Rotate(cameraViewDirection)
+	cameraViewDirection = fauxgl.QuatRotate(
+		DegToRad(cameraRotationUD), cameraViewDirection.Cross(cameraUp)).Rotate(cameraViewDirection)
+	cameraUp = fauxgl.QuatRotate(
+		DegToRad(camera.Roll), cameraViewDirection).Rotate(cameraUp)
+	cameraView := fauxgl.LookAt(cameraPosition, (cameraPosition).Add(cameraViewDirection), cameraUp)
+	var cameraPerspective fauxgl.Matrix
+	if camera.Intrinsics.Calibrated() {
+		cameraPerspective = camera.Intrinsics.Perspective(cameraView, render)
+	} else {
+		cameraPerspective = cameraView.Perspective(render.Fovy, render.AspectRatio(), Near, Far)
+	}
+
+	// camera := fauxgl.LookAt(cameraPosition, (cameraPosition).Add(cameraViewDirection), cameraUp)
+	// perspective := fauxgl.PerspectiveGL(fovy, imageAspectRatio, near, far)
+	// cameraPerspective := perspective.Mul(camera).Scale(fauxgl.Vector{10, 10, 1})
+
+	// pretty.Println("camera location:", cameraLocation)
+
+	return cameraPerspective
+}
+
+//CameraEye is where the camera is in the model in metres, its height above the ground
+//reference of its location; Y is down
+func CameraEye(cameraLocation *MapVector, camera CameraConfig) fauxgl.Vector {
+	return fauxgl.Vector{
+		X: cameraLocation.VertX,
+		Y: cameraLocation.VertY - camera.Height,
+		Z: cameraLocation.VertZ,
+	}
+}
+
+//Modeller places the camera in the model; its elevation is from the lowest ground point
+func Modeller(properties *ModelProperties, cameraLocation *MapVector) *MapVector {
+	cameraLocation.Elevation += properties.OriginElevation
+	properties.Localize(cameraLocation)
+	return cameraLocation
+}
+
+//LoadModel reads the scene file a command works on, once. A scene built from a vectors and a
+//primitives CSV is rebuilt first whenever the vectors changed; without them it is read as it is
+func LoadModel(vectorPath, primitivePath, scenePath string) (*ModelProperties, []*MapVector, []*MapPrimitiveIndex, error) {
+	if vectorPath != "" && ModelIsStale(vectorPath, scenePath) {
+		return BuildModel(vectorPath, primitivePath, scenePath)
+	}
+	return LoadScene(scenePath)
+}
+
+//DegToRad turns degrees into radians
+func DegToRad(d float64) float64 { return d * degRadConversion }
+
+//RadToDeg turns radians into degrees
+func RadToDeg(r float64) float64 { return r / degRadConversion }
+
+//Round is f rounded half away from zero
+func Round(f float64) int {
+	if math.Abs(f) < 0.5 {
+		return 0
+	}
+	return int(f + math.Copysign(0.5, f))
+}
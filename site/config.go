@@ -0,0 +1,490 @@
+package site
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//SceneConfig is everything that differs between sites and cameras;
+//read from a YAML or JSON file and then overridden by flags
+type SceneConfig struct {
+	Area   AreaConfig   `json:"area" yaml:"area"`
+	Render RenderConfig `json:"render" yaml:"render"`
+	Camera CameraConfig `json:"camera" yaml:"camera"`
+	LOD    LODConfig    `json:"lod" yaml:"lod"`
+	Mesh   MeshConfig   `json:"mesh" yaml:"mesh"`
+
+	Scene     string                       `json:"scene" yaml:"scene"`     //scene file the terrain is loaded from
+	Cameras   map[string]NamedCameraConfig `json:"cameras" yaml:"cameras"` //registry of the cameras of the site by ID
+	camera    string                       //ID of the camera of the registry in the camera section; empty for its own
+	flags     func(*SceneConfig)           //the flags given of the site, which adjust any camera of the registry put in
+	unflagged *SceneConfig                 //the config before the flags, which another camera of the registry is put in
+	ownScene  bool                         //the scene is that of the camera of the registry, read as it is
+}
+
+//BuiltScene is the scene file the downloaded or imported terrain is built into unless scene is set
+const BuiltScene = "resultNormModel.scene"
+
+//AreaConfig is the sampled tile; south-east to north-west,
+//lat goes south north; long east west
+type AreaConfig struct {
+	LatStart      float64 `json:"latStart" yaml:"latStart"`
+	LngStart      float64 `json:"lngStart" yaml:"lngStart"`
+	LatEnd        float64 `json:"latEnd" yaml:"latEnd"`
+	LngEnd        float64 `json:"lngEnd" yaml:"lngEnd"`
+	ResolutionLat float64 `json:"resolutionLat" yaml:"resolutionLat"` //degrees
+	ResolutionLng float64 `json:"resolutionLng" yaml:"resolutionLng"` //degrees
+}
+
+type RenderConfig struct {
+	Width  int     `json:"width" yaml:"width"`
+	Height int     `json:"height" yaml:"height"`
+	Scale  int     `json:"scale" yaml:"scale"`   //optional supersampling
+	Fovy   float64 `json:"fovy" yaml:"fovy"`     //vertical field of view in degrees
+	Picker string  `json:"picker" yaml:"picker"` //"raster", "raycast", or "compare" to ray cast and check against raster
+}
+
+type CameraConfig struct {
+	Latitude   float64 `json:"latitude" yaml:"latitude"`
+	Longitude  float64 `json:"longitude" yaml:"longitude"`
+	Elevation  float64 `json:"elevation" yaml:"elevation"`   //metres above the lowest ground point in the model
+	Height     float64 `json:"height" yaml:"height"`         //metres of the camera above its elevation
+	RotationLR float64 `json:"rotationLR" yaml:"rotationLR"` //-ve rotates camera clockwise in degrees
+	RotationUD float64 `json:"rotationUD" yaml:"rotationUD"` //-ve rotates camera downwards in degrees
+	Roll       float64 `json:"roll" yaml:"roll"`             //+ve rolls camera clockwise in degrees, seen from behind
+
+	Intrinsics IntrinsicsConfig `json:"intrinsics" yaml:"intrinsics"`
+}
+
+//NamedCameraConfig is a camera of the registry: its pose and intrinsics as in the camera section,
+//and the image and scene it picks on; an image size, fovy or scene left out is that of the config
+type NamedCameraConfig struct {
+	CameraConfig `yaml:",inline"`
+
+	ImageWidth  int     `json:"imageWidth" yaml:"imageWidth"`
+	ImageHeight int     `json:"imageHeight" yaml:"imageHeight"`
+	Fovy        float64 `json:"fovy" yaml:"fovy"`   //vertical field of view in degrees without fx and fy
+	Scene       string  `json:"scene" yaml:"scene"` //scene file the camera looks at
+}
+
+//IntrinsicsConfig is the calibration of the camera in pixels of the image at the render
+//width and height, with the origin at the centre of the top left pixel; without fx and fy
+//the camera is an ideal pinhole with the fovy of the render
+type IntrinsicsConfig struct {
+	Fx float64 `json:"fx" yaml:"fx"` //focal length
+	Fy float64 `json:"fy" yaml:"fy"`
+	Cx float64 `json:"cx" yaml:"cx"` //principal point
+	Cy float64 `json:"cy" yaml:"cy"`
+	K1 float64 `json:"k1" yaml:"k1"` //Brown-Conrady radial distortion
+	K2 float64 `json:"k2" yaml:"k2"`
+	K3 float64 `json:"k3" yaml:"k3"`
+	P1 float64 `json:"p1" yaml:"p1"` //Brown-Conrady tangential distortion
+	P2 float64 `json:"p2" yaml:"p2"`
+}
+
+//LODConfig is how the terrain is thinned out for rendering and picking: decimated to MaxError,
+//then tiled into a quadtree; a tile nearer to the camera than Distance tile sizes is split
+//into its quarters, a farther one is simplified. Picks and lookups are mapped back to the
+//primitive IDs of the scene file and the GCS of the whole model under them
+type LODConfig struct {
+	MaxError       float64 `json:"maxError" yaml:"maxError"`             //metres of elevation; 0 keeps every vector
+	Distance       float64 `json:"distance" yaml:"distance"`             //tile sizes; 0 renders everything at full resolution
+	TileCells      int     `json:"tileCells" yaml:"tileCells"`           //vectors across a simplified tile
+	LeafPrimitives int     `json:"leafPrimitives" yaml:"leafPrimitives"` //a tile with no more is not split
+}
+
+//MeshConfig is a site mesh picked on instead of the downloaded terrain, with its georeference
+type MeshConfig struct {
+	File      string  `json:"file" yaml:"file"`           //.obj or .ply; the terrain is downloaded when empty
+	Latitude  float64 `json:"latitude" yaml:"latitude"`   //GCS of the mesh origin
+	Longitude float64 `json:"longitude" yaml:"longitude"` //GCS of the mesh origin
+	Elevation float64 `json:"elevation" yaml:"elevation"` //GCS of the mesh origin in metres
+	Axes      string  `json:"axes" yaml:"axes"`           //where x, y and z point: e/w, n/s, u/d; "enu" is x east, y north, z up
+	Scale     float64 `json:"scale" yaml:"scale"`         //metres per mesh unit
+}
+
+//MeshAxes reads an axis convention like "enu" or "eus" into the ENU direction of mesh x, y and z
+func MeshAxes(axes string) ([3][3]float64, error) {
+	directions := map[rune][3]float64{
+		'e': {1, 0, 0}, 'w': {-1, 0, 0},
+		'n': {0, 1, 0}, 's': {0, -1, 0},
+		'u': {0, 0, 1}, 'd': {0, 0, -1},
+	}
+	var result [3][3]float64
+	var used [3]bool
+	if len(axes) != 3 {
+		return result, fmt.Errorf("config: mesh axes %q must be three of e/w, n/s and u/d", axes)
+	}
+	for i, letter := range strings.ToLower(axes) {
+		direction, ok := directions[letter]
+		if !ok {
+			return result, fmt.Errorf("config: mesh axes %q must be three of e/w, n/s and u/d", axes)
+		}
+		for k, component := range direction {
+			if component != 0 && used[k] {
+				return result, fmt.Errorf("config: mesh axes %q point two axes the same way", axes)
+			}
+			used[k] = used[k] || component != 0
+		}
+		result[i] = direction
+	}
+	return result, nil
+}
+
+func DefaultSceneConfig() *SceneConfig {
+	return &SceneConfig{
+		Area: AreaConfig{
+			LatStart:      43.45135,
+			LngStart:      -80.49400,
+			LatEnd:        43.45245,
+			LngEnd:        -80.49600,
+			ResolutionLat: 0.00001,
+			ResolutionLng: 0.00001,
+		},
+		Render: RenderConfig{
+			Width:  600,
+			Height: 600,
+			Scale:  4,
+			Fovy:   90.0,
+			Picker: "raster",
+		},
+		Camera: CameraConfig{
+			Latitude:   43.4515683,
+			Longitude:  -80.4959493,
+			Elevation:  2.775,
+			Height:     2.5,
+			RotationLR: float64(-90) - 90,
+			RotationUD: -20.0,
+		},
+		LOD: LODConfig{
+			MaxError:       0.05,
+			Distance:       8,
+			TileCells:      32,
+			LeafPrimitives: 4096,
+		},
+		Mesh: MeshConfig{
+			Axes:  "enu",
+			Scale: 1,
+		},
+		Scene: BuiltScene,
+	}
+}
+
+func (r RenderConfig) AspectRatio() float64 {
+	return float64(r.Width) / float64(r.Height)
+}
+
+//UseCamera puts the camera of the registry with the ID in the camera section,
+//and looks at its image and scene
+func (c *SceneConfig) UseCamera(id string) error {
+	camera, ok := c.Cameras[id]
+	if !ok {
+		return fmt.Errorf("config: no camera %q in the registry", id)
+	}
+	c.Camera = camera.CameraConfig
+	if camera.ImageWidth > 0 {
+		c.Render.Width = camera.ImageWidth
+	}
+	if camera.ImageHeight > 0 {
+		c.Render.Height = camera.ImageHeight
+	}
+	if camera.Fovy > 0 {
+		c.Render.Fovy = camera.Fovy
+	}
+	if camera.Scene != "" {
+		c.ownScene = camera.Scene != c.Scene
+		c.Scene = camera.Scene
+	}
+	c.camera = id
+	return nil
+}
+
+//ModelFiles are the vectors and primitives CSVs the scene file is built from, those of the download;
+//empty for an imported mesh or a scene of a camera of the registry, which are read as they are
+func (c *SceneConfig) ModelFiles() (vectorPath, primitivePath string) {
+	if c.Mesh.File != "" || c.ownScene {
+		return "", ""
+	}
+	return VectorModelFile, PrimitiveModelFile
+}
+
+//ForCamera is a copy of the config with the camera of the registry with the ID in its camera section;
+//an empty ID or that of -camera keeps the camera section. The flags of the camera section, its pose,
+//image and scene, are of the camera of -camera; any other camera is only adjusted by those of the site
+func (c *SceneConfig) ForCamera(id string) (*SceneConfig, error) {
+	config := *c
+	if id == "" || id == c.camera {
+		return &config, nil
+	}
+	if c.unflagged != nil {
+		config = *c.unflagged
+	}
+	if err := config.UseCamera(id); err != nil {
+		return nil, err
+	}
+	if c.flags != nil {
+		c.flags(&config)
+	}
+	config.flags, config.unflagged = c.flags, c.unflagged
+	return &config, config.validate()
+}
+
+//CameraID is the ID of the camera of the registry in the camera section; empty for its own
+func (c *SceneConfig) CameraID() string {
+	return c.camera
+}
+
+//LoadSceneConfig reads a .yaml/.yml or .json file over the defaults;
+//fields missing from the file keep their default
+func LoadSceneConfig(path string) (*SceneConfig, error) {
+	config := DefaultSceneConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, config)
+	default:
+		err = fmt.Errorf("unknown config file type; use .yaml or .json")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+	return config, config.validate()
+}
+
+//SaveSceneConfig writes the whole config as .yaml/.yml or .json
+func SaveSceneConfig(path string, config *SceneConfig) error {
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		data, err = json.MarshalIndent(config, "", "  ")
+	case ".yaml", ".yml":
+		data, err = yaml.Marshal(config)
+	default:
+		err = fmt.Errorf("unknown config file type; use .yaml or .json")
+	}
+	if err != nil {
+		return fmt.Errorf("config %s: %s", path, err)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (c *SceneConfig) validate() error {
+	if c.Area.ResolutionLat <= 0 || c.Area.ResolutionLng <= 0 {
+		return fmt.Errorf("config: sample resolution must be positive")
+	}
+	if c.Area.LatStart == c.Area.LatEnd || c.Area.LngStart == c.Area.LngEnd {
+		return fmt.Errorf("config: area is empty")
+	}
+	if c.Render.Width <= 0 || c.Render.Height <= 0 || c.Render.Scale <= 0 {
+		return fmt.Errorf("config: render size and scale must be positive")
+	}
+	if c.Render.Fovy <= 0 || c.Render.Fovy >= 180 {
+		return fmt.Errorf("config: fovy must be between 0 and 180 degrees")
+	}
+	if picker := c.Render.Picker; picker != "raster" && picker != "raycast" && picker != "compare" {
+		return fmt.Errorf("config: unknown picker %q; use raster, raycast or compare", picker)
+	}
+	if intrinsics := c.Camera.Intrinsics; (intrinsics.Fx != 0 || intrinsics.Fy != 0) && (intrinsics.Fx <= 0 || intrinsics.Fy <= 0) {
+		return fmt.Errorf("config: camera fx and fy must both be positive")
+	}
+	if c.Scene == "" {
+		return fmt.Errorf("config: scene file is empty")
+	}
+	for id, camera := range c.Cameras {
+		if id == "" {
+			return fmt.Errorf("config: a camera of the registry has no ID")
+		}
+		if camera.ImageWidth < 0 || camera.ImageHeight < 0 {
+			return fmt.Errorf("config: camera %q: image size must not be negative", id)
+		}
+		if camera.Fovy < 0 || camera.Fovy >= 180 {
+			return fmt.Errorf("config: camera %q: fovy must be between 0 and 180 degrees", id)
+		}
+		if intrinsics := camera.Intrinsics; (intrinsics.Fx != 0 || intrinsics.Fy != 0) && (intrinsics.Fx <= 0 || intrinsics.Fy <= 0) {
+			return fmt.Errorf("config: camera %q: fx and fy must both be positive", id)
+		}
+	}
+	if c.LOD.MaxError < 0 || c.LOD.Distance < 0 || c.LOD.TileCells <= 0 || c.LOD.LeafPrimitives <= 0 {
+		return fmt.Errorf("config: lod error and distance must not be negative, tile cells and leaf primitives must be positive")
+	}
+	if c.Mesh.File != "" {
+		//the built scene is that of the download
+		if c.Scene == BuiltScene {
+			return fmt.Errorf("config: a mesh needs a scene file of its own; set scene")
+		}
+		if _, err := MeshAxes(c.Mesh.Axes); err != nil {
+			return err
+		}
+		if c.Mesh.Scale <= 0 {
+			return fmt.Errorf("config: mesh scale must be positive")
+		}
+	}
+	return nil
+}
+
+//SceneFlags registers a flag for every config value; apply only overrides the flags that were given
+func SceneFlags(flags *flag.FlagSet) (configFile *string, apply func(*SceneConfig) error) {
+	defaults := DefaultSceneConfig()
+	given := *defaults
+	configFile = flags.String("config", "", "scene config file (.yaml or .json)")
+	cameraID := flags.String("camera", "", "ID of the camera of the registry to use instead of the camera section; the camera flags adjust it alone")
+
+	flags.Float64Var(&given.Area.LatStart, "lat-start", defaults.Area.LatStart, "south edge of the area")
+	flags.Float64Var(&given.Area.LngStart, "lng-start", defaults.Area.LngStart, "east edge of the area")
+	flags.Float64Var(&given.Area.LatEnd, "lat-end", defaults.Area.LatEnd, "north edge of the area")
+	flags.Float64Var(&given.Area.LngEnd, "lng-end", defaults.Area.LngEnd, "west edge of the area")
+	flags.Float64Var(&given.Area.ResolutionLat, "resolution-lat", defaults.Area.ResolutionLat, "latitude sample spacing in degrees")
+	flags.Float64Var(&given.Area.ResolutionLng, "resolution-lng", defaults.Area.ResolutionLng, "longitude sample spacing in degrees")
+	flags.IntVar(&given.Render.Width, "width", defaults.Render.Width, "image width")
+	flags.IntVar(&given.Render.Height, "height", defaults.Render.Height, "image height")
+	flags.IntVar(&given.Render.Scale, "supersampling", defaults.Render.Scale, "supersampling factor")
+	flags.Float64Var(&given.Render.Fovy, "fovy", defaults.Render.Fovy, "vertical field of view in degrees")
+	flags.StringVar(&given.Render.Picker, "picker", defaults.Render.Picker, "raster, raycast, or compare to ray cast and check against raster")
+	flags.StringVar(&given.Scene, "scene", defaults.Scene, "scene file the terrain is loaded from")
+	flags.Float64Var(&given.Camera.Latitude, "camera-lat", defaults.Camera.Latitude, "camera latitude")
+	flags.Float64Var(&given.Camera.Longitude, "camera-lng", defaults.Camera.Longitude, "camera longitude")
+	flags.Float64Var(&given.Camera.Elevation, "camera-elevation", defaults.Camera.Elevation, "camera elevation in metres above the lowest ground point")
+	flags.Float64Var(&given.Camera.Height, "camera-height", defaults.Camera.Height, "camera height in metres above its elevation")
+	flags.Float64Var(&given.Camera.RotationLR, "camera-lr", defaults.Camera.RotationLR, "camera rotation left/right in degrees")
+	flags.Float64Var(&given.Camera.RotationUD, "camera-ud", defaults.Camera.RotationUD, "camera rotation up/down in degrees")
+	flags.Float64Var(&given.Camera.Roll, "camera-roll", defaults.Camera.Roll, "camera roll in degrees")
+	flags.Float64Var(&given.Camera.Intrinsics.Fx, "camera-fx", defaults.Camera.Intrinsics.Fx, "camera focal length in pixels; fovy is used when 0")
+	flags.Float64Var(&given.Camera.Intrinsics.Fy, "camera-fy", defaults.Camera.Intrinsics.Fy, "camera focal length in pixels; fovy is used when 0")
+	flags.Float64Var(&given.Camera.Intrinsics.Cx, "camera-cx", defaults.Camera.Intrinsics.Cx, "camera principal point in pixels")
+	flags.Float64Var(&given.Camera.Intrinsics.Cy, "camera-cy", defaults.Camera.Intrinsics.Cy, "camera principal point in pixels")
+	flags.Float64Var(&given.Camera.Intrinsics.K1, "camera-k1", defaults.Camera.Intrinsics.K1, "camera radial distortion")
+	flags.Float64Var(&given.Camera.Intrinsics.K2, "camera-k2", defaults.Camera.Intrinsics.K2, "camera radial distortion")
+	flags.Float64Var(&given.Camera.Intrinsics.K3, "camera-k3", defaults.Camera.Intrinsics.K3, "camera radial distortion")
+	flags.Float64Var(&given.Camera.Intrinsics.P1, "camera-p1", defaults.Camera.Intrinsics.P1, "camera tangential distortion")
+	flags.Float64Var(&given.Camera.Intrinsics.P2, "camera-p2", defaults.Camera.Intrinsics.P2, "camera tangential distortion")
+	flags.Float64Var(&given.LOD.MaxError, "lod-error", defaults.LOD.MaxError, "metres of elevation the terrain may be decimated by; 0 keeps every vector")
+	flags.Float64Var(&given.LOD.Distance, "lod-distance", defaults.LOD.Distance, "tile sizes from the camera a terrain tile is simplified at; 0 is full resolution")
+	flags.IntVar(&given.LOD.TileCells, "lod-cells", defaults.LOD.TileCells, "vectors across a simplified terrain tile")
+	flags.IntVar(&given.LOD.LeafPrimitives, "lod-leaf", defaults.LOD.LeafPrimitives, "primitives in a terrain tile that is not split")
+	flags.StringVar(&given.Mesh.File, "mesh", defaults.Mesh.File, "OBJ or PLY site mesh to pick on instead of the downloaded terrain")
+	flags.Float64Var(&given.Mesh.Latitude, "mesh-lat", defaults.Mesh.Latitude, "latitude of the mesh origin")
+	flags.Float64Var(&given.Mesh.Longitude, "mesh-lng", defaults.Mesh.Longitude, "longitude of the mesh origin")
+	flags.Float64Var(&given.Mesh.Elevation, "mesh-elevation", defaults.Mesh.Elevation, "elevation of the mesh origin in metres")
+	flags.StringVar(&given.Mesh.Axes, "mesh-axes", defaults.Mesh.Axes, "where mesh x, y and z point, e.g. enu or eus")
+	flags.Float64Var(&given.Mesh.Scale, "mesh-scale", defaults.Mesh.Scale, "metres per mesh unit")
+
+	//the flags of the camera section are of one camera: its pose, image and scene
+	cameraFlag := func(name string) bool {
+		return strings.HasPrefix(name, "camera-") || name == "width" || name == "height" || name == "fovy" || name == "scene"
+	}
+	override := func(config *SceneConfig, camera bool) {
+		flags.Visit(func(f *flag.Flag) {
+			if cameraFlag(f.Name) && !camera {
+				return
+			}
+			switch f.Name {
+			case "lat-start":
+				config.Area.LatStart = given.Area.LatStart
+			case "lng-start":
+				config.Area.LngStart = given.Area.LngStart
+			case "lat-end":
+				config.Area.LatEnd = given.Area.LatEnd
+			case "lng-end":
+				config.Area.LngEnd = given.Area.LngEnd
+			case "resolution-lat":
+				config.Area.ResolutionLat = given.Area.ResolutionLat
+			case "resolution-lng":
+				config.Area.ResolutionLng = given.Area.ResolutionLng
+			case "width":
+				config.Render.Width = given.Render.Width
+			case "height":
+				config.Render.Height = given.Render.Height
+			case "supersampling":
+				config.Render.Scale = given.Render.Scale
+			case "fovy":
+				config.Render.Fovy = given.Render.Fovy
+			case "picker":
+				config.Render.Picker = given.Render.Picker
+			case "scene":
+				config.Scene = given.Scene
+			case "camera-lat":
+				config.Camera.Latitude = given.Camera.Latitude
+			case "camera-lng":
+				config.Camera.Longitude = given.Camera.Longitude
+			case "camera-elevation":
+				config.Camera.Elevation = given.Camera.Elevation
+			case "camera-height":
+				config.Camera.Height = given.Camera.Height
+			case "camera-lr":
+				config.Camera.RotationLR = given.Camera.RotationLR
+			case "camera-ud":
+				config.Camera.RotationUD = given.Camera.RotationUD
+			case "camera-roll":
+				config.Camera.Roll = given.Camera.Roll
+			case "camera-fx":
+				config.Camera.Intrinsics.Fx = given.Camera.Intrinsics.Fx
+			case "camera-fy":
+				config.Camera.Intrinsics.Fy = given.Camera.Intrinsics.Fy
+			case "camera-cx":
+				config.Camera.Intrinsics.Cx = given.Camera.Intrinsics.Cx
+			case "camera-cy":
+				config.Camera.Intrinsics.Cy = given.Camera.Intrinsics.Cy
+			case "camera-k1":
+				config.Camera.Intrinsics.K1 = given.Camera.Intrinsics.K1
+			case "camera-k2":
+				config.Camera.Intrinsics.K2 = given.Camera.Intrinsics.K2
+			case "camera-k3":
+				config.Camera.Intrinsics.K3 = given.Camera.Intrinsics.K3
+			case "camera-p1":
+				config.Camera.Intrinsics.P1 = given.Camera.Intrinsics.P1
+			case "camera-p2":
+				config.Camera.Intrinsics.P2 = given.Camera.Intrinsics.P2
+			case "lod-error":
+				config.LOD.MaxError = given.LOD.MaxError
+			case "lod-distance":
+				config.LOD.Distance = given.LOD.Distance
+			case "lod-cells":
+				config.LOD.TileCells = given.LOD.TileCells
+			case "lod-leaf":
+				config.LOD.LeafPrimitives = given.LOD.LeafPrimitives
+			case "mesh":
+				config.Mesh.File = given.Mesh.File
+			case "mesh-lat":
+				config.Mesh.Latitude = given.Mesh.Latitude
+			case "mesh-lng":
+				config.Mesh.Longitude = given.Mesh.Longitude
+			case "mesh-elevation":
+				config.Mesh.Elevation = given.Mesh.Elevation
+			case "mesh-axes":
+				config.Mesh.Axes = given.Mesh.Axes
+			case "mesh-scale":
+				config.Mesh.Scale = given.Mesh.Scale
+			}
+		})
+	}
+	apply = func(config *SceneConfig) error {
+		unflagged := *config
+		//the camera of the registry first, for the camera flags to adjust
+		if *cameraID != "" {
+			if err := config.UseCamera(*cameraID); err != nil {
+				return err
+			}
+		}
+		override(config, true)
+		config.flags = func(config *SceneConfig) { override(config, false) }
+		config.unflagged = &unflagged
+		return config.validate()
+	}
+	return configFile, apply
+}
@@ -0,0 +1,96 @@
+package site
+
+import (
+	"flag"
+	"testing"
+)
+
+//testRegistry is the default config with two cameras of the registry: north with an image and
+//scene of its own, south with those of the config
+func testRegistry() *SceneConfig {
+	config := DefaultSceneConfig()
+	config.Cameras = map[string]NamedCameraConfig{
+		"north": {
+			CameraConfig: CameraConfig{Latitude: 43.4516, Longitude: -80.4890, Height: 6, RotationUD: -15},
+			ImageWidth:   1280, ImageHeight: 720, Fovy: 50, Scene: "north.scene",
+		},
+		"south": {
+			CameraConfig: CameraConfig{Latitude: 43.4501, Longitude: -80.4893, Height: 4, RotationLR: 180},
+		},
+	}
+	return config
+}
+
+func applyFlags(t *testing.T, config *SceneConfig, args ...string) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, apply := SceneFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCameraFlags(t *testing.T) {
+	defaults := DefaultSceneConfig()
+	config := testRegistry()
+	applyFlags(t, config, "-camera", "north", "-camera-lat", "43.46", "-camera-k1", "-0.1", "-scene", "flagged.scene",
+		"-width", "640", "-lod-error", "0.2", "-picker", "raycast")
+
+	//the camera of -camera is adjusted by every flag
+	if config.CameraID() != "north" || config.Camera.Latitude != 43.46 || config.Camera.Height != 6 ||
+		config.Camera.Intrinsics.K1 != -0.1 || config.Scene != "flagged.scene" ||
+		config.Render.Width != 640 || config.Render.Height != 720 || config.LOD.MaxError != 0.2 {
+		t.Errorf("-camera north: camera %q %+v, scene %s, render %+v, lod %+v", config.CameraID(), config.Camera,
+			config.Scene, config.Render, config.LOD)
+	}
+	north, err := config.ForCamera("north")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if north.Camera != config.Camera || north.Scene != config.Scene || north.Render != config.Render {
+		t.Errorf("north for itself: camera %+v, scene %s, render %+v", north.Camera, north.Scene, north.Render)
+	}
+
+	//another camera of the registry only by those of the site
+	south, err := config.ForCamera("south")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if south.CameraID() != "south" || south.Camera != config.Cameras["south"].CameraConfig {
+		t.Errorf("south: camera %q %+v", south.CameraID(), south.Camera)
+	}
+	if south.Scene != defaults.Scene || south.Render.Width != defaults.Render.Width || south.Render.Height != defaults.Render.Height ||
+		south.Render.Fovy != defaults.Render.Fovy {
+		t.Errorf("south: scene %s, render %+v; want those of the config", south.Scene, south.Render)
+	}
+	if south.LOD.MaxError != 0.2 || south.Render.Picker != "raycast" {
+		t.Errorf("south: lod %+v, picker %s; want those of the flags", south.LOD, south.Render.Picker)
+	}
+	if _, err := config.ForCamera("west"); err == nil {
+		t.Error("a camera not in the registry is found")
+	}
+
+	//without -camera the camera flags are of the camera section, and of no camera of the registry
+	config = testRegistry()
+	applyFlags(t, config, "-camera-lat", "43.46", "-fovy", "30")
+	if config.Camera.Latitude != 43.46 || config.Render.Fovy != 30 {
+		t.Errorf("camera section: camera %+v, render %+v", config.Camera, config.Render)
+	}
+	if section, err := config.ForCamera(""); err != nil || section.Camera != config.Camera {
+		t.Errorf("camera section for itself: camera %+v, error %v", section.Camera, err)
+	}
+	for _, id := range []string{"north", "south"} {
+		camera, err := config.ForCamera(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if camera.Camera != config.Cameras[id].CameraConfig {
+			t.Errorf("%s: camera %+v", id, camera.Camera)
+		}
+		if id == "south" && camera.Render.Fovy != defaults.Render.Fovy {
+			t.Errorf("%s: fovy %v", id, camera.Render.Fovy)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package site
+
+import (
+	"math"
+
+	"github.com/StefanSchroeder/Golang-Ellipsoid/ellipsoid"
+)
+
+//ENUFrame is a local East-North-Up frame in metres, tangent to the WGS84 ellipsoid at an origin
+type ENUFrame struct {
+	ellipsoidConfig                ellipsoid.Ellipsoid
+	originX, originY, originZ      float64 //ECEF of the origin
+	sinLat, cosLat, sinLng, cosLng float64
+}
+
+func NewENUFrame(lat, lng, elevation float64) *ENUFrame {
+	frame := &ENUFrame{
+		ellipsoidConfig: ellipsoid.Init(
+			"WGS84",
+			ellipsoid.Degrees,
+			ellipsoid.Meter,
+			ellipsoid.LongitudeIsSymmetric,
+			ellipsoid.BearingIsSymmetric),
+		sinLat: math.Sin(DegToRad(lat)),
+		cosLat: math.Cos(DegToRad(lat)),
+		sinLng: math.Sin(DegToRad(lng)),
+		cosLng: math.Cos(DegToRad(lng)),
+	}
+	frame.originX, frame.originY, frame.originZ = frame.ellipsoidConfig.ToECEF(lat, lng, elevation)
+	return frame
+}
+
+func (f *ENUFrame) ToENU(lat, lng, elevation float64) (east, north, up float64) {
+	x, y, z := f.ellipsoidConfig.ToECEF(lat, lng, elevation)
+	dx, dy, dz := x-f.originX, y-f.originY, z-f.originZ
+
+	east = -f.sinLng*dx + f.cosLng*dy
+	north = -f.sinLat*f.cosLng*dx - f.sinLat*f.sinLng*dy + f.cosLat*dz
+	up = f.cosLat*f.cosLng*dx + f.cosLat*f.sinLng*dy + f.sinLat*dz
+	return east, north, up
+}
+
+//ToModel gives the model axes the camera works in: X north, Y down, Z west;
+//right handed, and down matches the camera's up vector of (0, -1, 0)
+func (f *ENUFrame) ToModel(lat, lng, elevation float64) (x, y, z float64) {
+	east, north, up := f.ToENU(lat, lng, elevation)
+	return north, -up, -east
+}
+
+//ToGCS is the inverse of ToENU
+func (f *ENUFrame) ToGCS(east, north, up float64) (lat, lng, elevation float64) {
+	dx := -f.sinLng*east - f.sinLat*f.cosLng*north + f.cosLat*f.cosLng*up
+	dy := f.cosLng*east - f.sinLat*f.sinLng*north + f.cosLat*f.sinLng*up
+	dz := f.cosLat*north + f.sinLat*up
+	return f.ellipsoidConfig.ToLLA(f.originX+dx, f.originY+dy, f.originZ+dz)
+}
@@ -0,0 +1,51 @@
+package site
+
+import (
+	"math"
+	"testing"
+)
+
+func TestENURoundTrip(t *testing.T) {
+	const lat, lng, elevation = 43.0, -80.5, 300.0
+	frame := NewENUFrame(lat, lng, elevation)
+
+	//a kilometre north along the meridian and a kilometre east along the parallel of the origin,
+	//by the WGS84 radii of curvature at 43 degrees
+	const a, flattening = 6378137.0, 1 / 298.257223563
+	e2 := flattening * (2 - flattening)
+	sin := math.Sin(DegToRad(lat))
+	meridian := a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+	primeVertical := a / math.Sqrt(1-e2*sin*sin)
+	pointLat := lat + 1000/meridian/degRadConversion
+	pointLng := lng + 1000/(primeVertical*math.Cos(DegToRad(lat)))/degRadConversion
+
+	//the ground curves away under the tangent plane by about d²/2R, 16 centimetres at 1.4 kilometres;
+	//east and north are about a decimetre off by the parallel narrowing to the north and the curve
+	east, north, up := frame.ToENU(pointLat, pointLng, elevation)
+	if math.Abs(east-1000) > 0.5 || math.Abs(north-1000) > 0.5 || math.Abs(up+0.157) > 0.01 {
+		t.Errorf("the point a kilometre north-east is at %.3f east, %.3f north, %.3f up", east, north, up)
+	}
+	if x, y, z := frame.ToModel(pointLat, pointLng, elevation); x != north || y != -up || z != -east {
+		t.Errorf("the point is at %.3f, %.3f, %.3f in the model; want north, down and west", x, y, z)
+	}
+
+	for _, test := range []struct {
+		name                string
+		lat, lng, elevation float64
+	}{
+		{"origin", lat, lng, elevation},
+		{"north-east", pointLat, pointLng, elevation},
+		{"south-west above", lat - 0.009, lng - 0.012, elevation + 250},
+		{"below", pointLat, lng, elevation - 40},
+	} {
+		east, north, up := frame.ToENU(test.lat, test.lng, test.elevation)
+		backLat, backLng, backElevation := frame.ToGCS(east, north, up)
+		if math.Abs(backLat-test.lat) > 1e-9 || math.Abs(backLng-test.lng) > 1e-9 || math.Abs(backElevation-test.elevation) > 1e-4 {
+			t.Errorf("%s: %.9f, %.9f, %.4f is at %.4f, %.4f, %.4f and back at %.9f, %.9f, %.4f", test.name,
+				test.lat, test.lng, test.elevation, east, north, up, backLat, backLng, backElevation)
+		}
+	}
+	if east, north, up := frame.ToENU(lat, lng, elevation); math.Abs(east) > 1e-6 || math.Abs(north) > 1e-6 || math.Abs(up) > 1e-6 {
+		t.Errorf("the origin is at %v, %v, %v", east, north, up)
+	}
+}
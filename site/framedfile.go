@@ -0,0 +1,80 @@
+package site
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+//the scene and the lookup are framed files; all little endian:
+//	magic of 8 bytes, version uint32
+//	the body of the file
+//	CRC-32 (IEEE) of everything before it as uint32
+const frameHeaderSize = 8 + 4
+
+//frameFormat is the magic and version of a kind of framed file
+type frameFormat struct {
+	kind    string //names the file in errors
+	magic   string
+	version uint32
+}
+
+//newFrame is a framed file with room for a body of bodySize bytes; the body is written into,
+//then the whole file saved with saveFrame
+func (f frameFormat) newFrame(bodySize int) (data, body []byte) {
+	data = make([]byte, frameHeaderSize+bodySize+4)
+	copy(data, f.magic)
+	binary.LittleEndian.PutUint32(data[len(f.magic):], f.version)
+	return data, data[frameHeaderSize : frameHeaderSize+bodySize]
+}
+
+//saveFrame checksums a framed file and writes it through a temporary file in one go
+func saveFrame(path string, data []byte) error {
+	end := len(data) - 4
+	binary.LittleEndian.PutUint32(data[end:], crc32.ChecksumIEEE(data[:end]))
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//load reads the whole framed file with a single read and checks it before its body is decoded
+func (f frameFormat) load(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkHeader(data); err != nil {
+		return nil, fmt.Errorf("%s %s: %s", f.kind, path, err)
+	}
+	if len(data) < frameHeaderSize+4 {
+		return nil, fmt.Errorf("%s %s: truncated", f.kind, path)
+	}
+	end := len(data) - 4
+	if crc32.ChecksumIEEE(data[:end]) != binary.LittleEndian.Uint32(data[end:]) {
+		return nil, fmt.Errorf("%s %s: checksum mismatch; the file is damaged", f.kind, path)
+	}
+	return data[frameHeaderSize:end], nil
+}
+
+//readHeader tells if a file starts with the magic and version this build reads
+func (f frameFormat) readHeader(reader io.Reader) error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fmt.Errorf("not a %s file", f.kind)
+	}
+	return f.checkHeader(header)
+}
+
+func (f frameFormat) checkHeader(data []byte) error {
+	if len(data) < frameHeaderSize || string(data[:len(f.magic)]) != f.magic {
+		return fmt.Errorf("not a %s file", f.kind)
+	}
+	if version := binary.LittleEndian.Uint32(data[len(f.magic):]); version != f.version {
+		return fmt.Errorf("version %d; this build reads version %d", version, f.version)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package site
+
+import (
+	"math"
+
+	"github.com/nomnom-ray/fauxgl"
+)
+
+func (c IntrinsicsConfig) Calibrated() bool {
+	return c.Fx > 0 && c.Fy > 0
+}
+
+//Perspective projects the view like the calibrated camera: the frustum through the edges of
+//the image as the pinhole of fx, fy, cx, cy sees them. Pixel centres are at .5 in the render
+func (c IntrinsicsConfig) Perspective(view fauxgl.Matrix, render RenderConfig) fauxgl.Matrix {
+	width, height := float64(render.Width), float64(render.Height)
+	cx, cy := c.Cx+0.5, c.Cy+0.5
+	return view.Frustum(
+		-Near*cx/c.Fx, Near*(width-cx)/c.Fx,
+		-Near*(height-cy)/c.Fy, Near*cy/c.Fy,
+		Near, Far)
+}
+
+//Undistort turns a pixel of the camera image into the pixel of the pinhole camera the model
+//is rendered with; the Brown-Conrady model has no closed inverse, so it is iterated
+func (c IntrinsicsConfig) Undistort(u, v float64) (float64, float64) {
+	if !c.Calibrated() {
+		return u, v
+	}
+	distortedX, distortedY := (u-c.Cx)/c.Fx, (v-c.Cy)/c.Fy
+	x, y := distortedX, distortedY
+	for i := 0; i < 20; i++ {
+		r2 := x*x + y*y
+		radial := 1 + c.K1*r2 + c.K2*r2*r2 + c.K3*r2*r2*r2
+		tangentialX := 2*c.P1*x*y + c.P2*(r2+2*x*x)
+		tangentialY := c.P1*(r2+2*y*y) + 2*c.P2*x*y
+		nextX, nextY := (distortedX-tangentialX)/radial, (distortedY-tangentialY)/radial
+		converged := math.Abs(nextX-x) < 1e-12 && math.Abs(nextY-y) < 1e-12
+		x, y = nextX, nextY
+		if converged {
+			break
+		}
+	}
+	return x*c.Fx + c.Cx, y*c.Fy + c.Cy
+}
+
+//Distort is the other way: the pixel of the camera image a pixel of the pinhole render is seen at
+func (c IntrinsicsConfig) Distort(u, v float64) (float64, float64) {
+	if !c.Calibrated() {
+		return u, v
+	}
+	x, y := (u-c.Cx)/c.Fx, (v-c.Cy)/c.Fy
+	r2 := x*x + y*y
+	radial := 1 + c.K1*r2 + c.K2*r2*r2 + c.K3*r2*r2*r2
+	distortedX := x*radial + 2*c.P1*x*y + c.P2*(r2+2*x*x)
+	distortedY := y*radial + c.P1*(r2+2*y*y) + 2*c.P2*x*y
+	return distortedX*c.Fx + c.Cx, distortedY*c.Fy + c.Cy
+}
@@ -0,0 +1,48 @@
+package site
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLensRoundTrip(t *testing.T) {
+	//a wide lens of a 640x480 image with barrel distortion and a little tangential
+	lens := IntrinsicsConfig{
+		Fx: 500, Fy: 505, Cx: 321.2, Cy: 238.7,
+		K1: -0.21, K2: 0.048, K3: -0.006, P1: 0.0012, P2: -0.0007,
+	}
+	for _, pixel := range [][2]float64{
+		{321.2, 238.7},
+		{400, 300},
+		{100, 50},
+		{600, 440},
+		{0, 0},
+		{639, 0},
+		{0, 479},
+		{639, 479},
+	} {
+		u, v := lens.Distort(pixel[0], pixel[1])
+		if x, y := lens.Undistort(u, v); math.Abs(x-pixel[0]) > 1e-6 || math.Abs(y-pixel[1]) > 1e-6 {
+			t.Errorf("pixel %v is seen at %.3f, %.3f, undistorted back to %.9f, %.9f", pixel, u, v, x, y)
+		}
+		x, y := lens.Undistort(pixel[0], pixel[1])
+		if u, v := lens.Distort(x, y); math.Abs(u-pixel[0]) > 1e-6 || math.Abs(v-pixel[1]) > 1e-6 {
+			t.Errorf("pixel %v undistorts to %.3f, %.3f, distorted back to %.9f, %.9f", pixel, x, y, u, v)
+		}
+	}
+
+	if u, v := lens.Distort(0, 0); math.Hypot(u, v) < 10 {
+		t.Errorf("the corner is only seen at %.3f, %.3f; the test needs a lens that distorts", u, v)
+	}
+
+	//without fx and fy the camera is a pinhole and the distortion is left out
+	pinhole := IntrinsicsConfig{Cx: 320, Cy: 240, K1: -0.21, K2: 0.048, K3: -0.006, P1: 0.0012, P2: -0.0007}
+	for _, pixel := range [][2]float64{{0, 0}, {12.5, 400.25}, {639, 479}} {
+		if u, v := pinhole.Distort(pixel[0], pixel[1]); u != pixel[0] || v != pixel[1] {
+			t.Errorf("uncalibrated: pixel %v distorts to %v, %v", pixel, u, v)
+		}
+		if u, v := pinhole.Undistort(pixel[0], pixel[1]); u != pixel[0] || v != pixel[1] {
+			t.Errorf("uncalibrated: pixel %v undistorts to %v, %v", pixel, u, v)
+		}
+	}
+}
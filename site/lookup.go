@@ -0,0 +1,301 @@
+package site
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nomnom-ray/fauxgl"
+)
+
+//the lookup raster is baked once per camera next to the scene; a framed file of magic
+//"2DGCSLUT" whose body is, all little endian:
+//	width uint32, height uint32
+//	the camera it was baked for: its matrix by row, k1, k2, k3, p1, p2 and the LOD error,
+//	distance, tile cells and leaf primitives of the terrain it saw as 25 float64
+//	per pixel of the camera image, row by row, the primitive of the scene as int32, -1 when
+//	nothing is seen, and Latitude, Elevation, Longtitude as float64
+const (
+	lookupMagic   = "2DGCSLUT"
+	lookupVersion = 1
+	lookupKeySize = 25
+)
+
+var lookupFormat = frameFormat{kind: "lookup", magic: lookupMagic, version: lookupVersion}
+
+//GeoLookup is what the camera sees at every pixel of its image: the scene primitive and the
+//latitude, elevation and longitude on it, the way a vertex texture holds them
+type GeoLookup struct {
+	Width, Height int
+	key           []float64 //camera it was baked for
+	Primitive     []int32
+	gcs           []float64
+}
+
+//Pick is the primitive and the GCS seen at a pixel of the camera image
+func (l *GeoLookup) Pick(x, y int) (int, fauxgl.Vector, bool) {
+	if x < 0 || y < 0 || x >= l.Width || y >= l.Height {
+		return 0, fauxgl.Vector{}, false
+	}
+	i := y*l.Width + x
+	if l.Primitive[i] < 0 {
+		return 0, fauxgl.Vector{}, false
+	}
+	return int(l.Primitive[i]), fauxgl.Vector{X: l.gcs[i*3], Y: l.gcs[i*3+1], Z: l.gcs[i*3+2]}, true
+}
+
+//lookupKey tells cameras apart: the same matrix and lens on the same level of detail see the same pixels
+func lookupKey(cameraPerspective fauxgl.Matrix, intrinsics IntrinsicsConfig, lod LODConfig) []float64 {
+	m := cameraPerspective
+	return []float64{
+		m.X00, m.X01, m.X02, m.X03,
+		m.X10, m.X11, m.X12, m.X13,
+		m.X20, m.X21, m.X22, m.X23,
+		m.X30, m.X31, m.X32, m.X33,
+		intrinsics.K1, intrinsics.K2, intrinsics.K3, intrinsics.P1, intrinsics.P2,
+		lod.MaxError, lod.Distance, float64(lod.TileCells), float64(lod.LeafPrimitives),
+	}
+}
+
+//idShader colours every fragment with the primitive ID its vertices carry; the depth test
+//leaves the colour of the nearest primitive in every pixel
+type idShader struct {
+	matrix fauxgl.Matrix
+}
+
+func (s *idShader) Vertex(v fauxgl.Vertex) fauxgl.Vertex {
+	v.Output = s.matrix.MulPositionW(v.Position)
+	return v
+}
+
+func (s *idShader) Fragment(v fauxgl.Vertex) fauxgl.Color {
+	return v.Color
+}
+
+//primitiveColor spreads primitive i over red, green and blue as i+1, so transparent is none;
+//every channel is in the middle of its byte to come back whole
+func primitiveColor(i int) fauxgl.Color {
+	id := i + 1
+	return fauxgl.Color{
+		R: (float64(id>>16&0xff) + 0.5) / 255,
+		G: (float64(id>>8&0xff) + 0.5) / 255,
+		B: (float64(id&0xff) + 0.5) / 255,
+		A: 1,
+	}
+}
+
+//bakeLookup renders the primitive IDs of the terrain at the level of detail the camera sees once,
+//then finds where the ray of every pixel of the camera image meets the primitive seen there, and
+//maps it back to the primitive of the scene and the GCS under it. With a lens the image pixel is
+//undistorted first, so the raster is in the pixels of the photo
+func bakeLookup(maxVert float64, cameraPerspective fauxgl.Matrix, intrinsics IntrinsicsConfig, render RenderConfig,
+	terrain *TerrainTree, primitiveIndex []*MapPrimitiveIndex) *GeoLookup {
+
+	position := func(i int) fauxgl.Vector {
+		vector := terrain.CompositeVector[i]
+		return fauxgl.Vector{X: vector.VertX / maxVert, Y: vector.VertY / maxVert, Z: vector.VertZ / maxVert}
+	}
+	var triangles []*fauxgl.Triangle
+	for i, index := range primitiveIndex {
+		triangle := fauxgl.NewTriangle(
+			fauxgl.Vertex{Position: position(index.PrimitiveBottom), Color: primitiveColor(i)},
+			fauxgl.Vertex{Position: position(index.PrimitiveTop), Color: primitiveColor(i)},
+			fauxgl.Vertex{Position: position(index.PrimitiveLeft), Color: primitiveColor(i)})
+		triangle.PrimitiveID = i
+		triangle.FixNormals()
+		triangles = append(triangles, triangle)
+	}
+	contextRender := fauxgl.NewContext(render.Width, render.Height)
+	contextRender.SetPickingFlag(false)
+	contextRender.ClearColorBufferWith(fauxgl.Transparent)
+	contextRender.Shader = &idShader{cameraPerspective}
+	contextRender.DrawMesh(fauxgl.NewTriangleMesh(triangles))
+
+	lookup := &GeoLookup{
+		Width:     render.Width,
+		Height:    render.Height,
+		key:       lookupKey(cameraPerspective, intrinsics, terrain.lod),
+		Primitive: make([]int32, render.Width*render.Height),
+		gcs:       make([]float64, render.Width*render.Height*3),
+	}
+	inverse := cameraPerspective.Inverse()
+	for y := 0; y < lookup.Height; y++ {
+		for x := 0; x < lookup.Width; x++ {
+			i := y*lookup.Width + x
+			lookup.Primitive[i] = -1
+			u, v := intrinsics.Undistort(float64(x), float64(y))
+			column, row := Round(u), Round(v)
+			if column < 0 || row < 0 || column >= lookup.Width || row >= lookup.Height {
+				continue
+			}
+			c := contextRender.ColorBuffer.NRGBAAt(column, row)
+			id := int(c.R)<<16 | int(c.G)<<8 | int(c.B)
+			if c.A == 0 || id == 0 || id > len(primitiveIndex) {
+				continue
+			}
+
+			//the pixel may be just past an edge of the primitive rendered there, so its plane is not clipped
+			origin, direction := PixelRay(inverse, u, v, render)
+			index := primitiveIndex[id-1]
+			_, distance, ok := Barycentric(origin, direction, position(index.PrimitiveBottom),
+				position(index.PrimitiveTop), position(index.PrimitiveLeft))
+			if !ok {
+				continue
+			}
+			primitive, _, gcs, ok := terrain.ScenePick(origin.Add(direction.MulScalar(distance)), maxVert)
+			if !ok {
+				continue
+			}
+			lookup.Primitive[i] = int32(primitive)
+			lookup.gcs[i*3], lookup.gcs[i*3+1], lookup.gcs[i*3+2] = gcs.X, gcs.Y, gcs.Z
+		}
+	}
+	return lookup
+}
+
+//PixelRay is the ray through the centre of pixel u, v of the render, from the near to the far
+//plane in the normalized model; inverse is the inverse of the camera matrix
+func PixelRay(inverse fauxgl.Matrix, u, v float64, render RenderConfig) (fauxgl.Vector, fauxgl.Vector) {
+	ndcX := (u+0.5)/float64(render.Width)*2 - 1
+	ndcY := 1 - (v+0.5)/float64(render.Height)*2
+	nearPoint := inverse.MulPositionW(fauxgl.Vector{X: ndcX, Y: ndcY, Z: -1})
+	farPoint := inverse.MulPositionW(fauxgl.Vector{X: ndcX, Y: ndcY, Z: 1})
+	origin := nearPoint.Vector().DivScalar(nearPoint.W)
+	return origin, farPoint.Vector().DivScalar(farPoint.W).Sub(origin)
+}
+
+//Barycentric is where the ray meets the plane of p1, p2, p3 (Moller-Trumbore): the weights of
+//the three points there, and how far along the direction it is; false when the ray is in the plane
+func Barycentric(origin, direction, p1, p2, p3 fauxgl.Vector) (fauxgl.Vector, float64, bool) {
+	edge1, edge2 := p2.Sub(p1), p3.Sub(p1)
+	h := direction.Cross(edge2)
+	determinant := edge1.Dot(h)
+	if math.Abs(determinant) < 1e-15 {
+		return fauxgl.Vector{}, 0, false
+	}
+	s := origin.Sub(p1)
+	u := s.Dot(h) / determinant
+	q := s.Cross(edge1)
+	v := direction.Dot(q) / determinant
+	t := edge2.Dot(q) / determinant
+	return fauxgl.Vector{X: 1 - u - v, Y: u, Z: v}, t, true
+}
+
+//saveLookup writes the lookup through a temporary file in one go
+func saveLookup(path string, lookup *GeoLookup) error {
+	pixels := lookup.Width * lookup.Height
+	data, body := lookupFormat.newFrame(2*4 + lookupKeySize*8 + pixels*(4+3*8))
+	binary.LittleEndian.PutUint32(body, uint32(lookup.Width))
+	binary.LittleEndian.PutUint32(body[4:], uint32(lookup.Height))
+	offset := 8
+	for _, value := range lookup.key {
+		binary.LittleEndian.PutUint64(body[offset:], math.Float64bits(value))
+		offset += 8
+	}
+	for i := 0; i < pixels; i++ {
+		binary.LittleEndian.PutUint32(body[offset:], uint32(lookup.Primitive[i]))
+		offset += 4
+		for _, value := range lookup.gcs[i*3 : i*3+3] {
+			binary.LittleEndian.PutUint64(body[offset:], math.Float64bits(value))
+			offset += 8
+		}
+	}
+	return saveFrame(path, data)
+}
+
+//loadLookup reads the lookup file and decodes the raster
+func loadLookup(path string) (*GeoLookup, error) {
+	body, err := lookupFormat.load(path)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := 2*4 + lookupKeySize*8
+	if len(body) < headerSize {
+		return nil, fmt.Errorf("lookup %s: truncated", path)
+	}
+
+	lookup := &GeoLookup{
+		Width:  int(binary.LittleEndian.Uint32(body)),
+		Height: int(binary.LittleEndian.Uint32(body[4:])),
+		key:    make([]float64, lookupKeySize),
+	}
+	pixels := lookup.Width * lookup.Height
+	if len(body) != headerSize+pixels*(4+3*8) {
+		return nil, fmt.Errorf("lookup %s: size does not match its header", path)
+	}
+	offset := 8
+	for k := range lookup.key {
+		lookup.key[k] = math.Float64frombits(binary.LittleEndian.Uint64(body[offset:]))
+		offset += 8
+	}
+	lookup.Primitive = make([]int32, pixels)
+	lookup.gcs = make([]float64, pixels*3)
+	for i := 0; i < pixels; i++ {
+		lookup.Primitive[i] = int32(binary.LittleEndian.Uint32(body[offset:]))
+		offset += 4
+		for k := 0; k < 3; k++ {
+			lookup.gcs[i*3+k] = math.Float64frombits(binary.LittleEndian.Uint64(body[offset:]))
+			offset += 8
+		}
+	}
+	return lookup, nil
+}
+
+//CameraLookup reads the lookup of the scene's camera, and bakes it again from the model of its scene
+//file when there is none, it is older than the scene file, or it was baked for another camera or
+//level of detail
+func CameraLookup(path string, scene *SceneConfig, properties *ModelProperties,
+	compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex) (*GeoLookup, error) {
+
+	maxVert := properties.MaxVert
+	cameraLocation := Modeller(properties, &MapVector{
+		Latitude:   scene.Camera.Latitude,
+		Longtitude: scene.Camera.Longitude,
+		Elevation:  scene.Camera.Elevation,
+	})
+	cameraPerspective := CameraModel(maxVert, cameraLocation, scene.Camera, scene.Render)
+	key := lookupKey(cameraPerspective, scene.Camera.Intrinsics, scene.LOD)
+
+	baked, err := os.Stat(path)
+	built, sceneErr := os.Stat(scene.Scene)
+	if err == nil && sceneErr == nil && !baked.ModTime().Before(built.ModTime()) {
+		lookup, err := loadLookup(path)
+		if err == nil && lookup.Width == scene.Render.Width && lookup.Height == scene.Render.Height &&
+			sameKey(lookup.key, key) {
+			return lookup, nil
+		}
+	}
+
+	terrain, err := NewTerrain(scene.Scene, properties, compositeVector, primitiveIndex, scene.LOD)
+	if err != nil {
+		return nil, err
+	}
+	lookup := bakeLookup(maxVert, cameraPerspective, scene.Camera.Intrinsics, scene.Render,
+		terrain, terrain.SelectLOD(CameraEye(cameraLocation, scene.Camera), cameraPerspective, maxVert))
+	return lookup, saveLookup(path, lookup)
+}
+
+//LookupPath is where the lookup of the camera is baked, next to the scene file it looks at:
+//named after the scene for the camera section's own, after its ID for a camera of the registry
+func (c *SceneConfig) LookupPath() string {
+	name := c.camera
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(c.Scene), filepath.Ext(c.Scene))
+	}
+	return filepath.Join(filepath.Dir(c.Scene), name+".lookup")
+}
+
+func sameKey(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package site
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nomnom-ray/fauxgl"
+)
+
+//brutePick is the nearest primitive the ray of a pixel meets, tried against every one, and the
+//GCS interpolated on it
+func brutePick(inverse fauxgl.Matrix, x, y int, render RenderConfig, maxVert float64,
+	compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex) (int, fauxgl.Vector, bool) {
+
+	position := func(i int) fauxgl.Vector {
+		vector := compositeVector[i]
+		return fauxgl.Vector{X: vector.VertX / maxVert, Y: vector.VertY / maxVert, Z: vector.VertZ / maxVert}
+	}
+	origin, direction := PixelRay(inverse, float64(x), float64(y), render)
+	picked, nearest := -1, math.Inf(1)
+	var gcs fauxgl.Vector
+	for i, index := range primitiveIndex {
+		weights, distance, ok := Barycentric(origin, direction, position(index.PrimitiveBottom),
+			position(index.PrimitiveTop), position(index.PrimitiveLeft))
+		if !ok || distance < 0 || distance >= nearest || weights.X < 0 || weights.Y < 0 || weights.Z < 0 {
+			continue
+		}
+		a, b, c := compositeVector[index.PrimitiveBottom], compositeVector[index.PrimitiveTop], compositeVector[index.PrimitiveLeft]
+		picked, nearest = i, distance
+		gcs = fauxgl.Vector{
+			X: weights.X*a.Latitude + weights.Y*b.Latitude + weights.Z*c.Latitude,
+			Y: weights.X*a.Elevation + weights.Y*b.Elevation + weights.Z*c.Elevation,
+			Z: weights.X*a.Longtitude + weights.Y*b.Longtitude + weights.Z*c.Longtitude,
+		}
+	}
+	return picked, gcs, picked >= 0
+}
+
+func TestCameraLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	properties, compositeVector, primitiveIndex := testGrid(32, 32, rolling)
+	scene := DefaultSceneConfig()
+	scene.Scene = filepath.Join(dir, "grid.scene")
+	//20 metres over the middle of the grid, looking down
+	scene.Camera = CameraConfig{Latitude: 43.45014, Longitude: -80.48981, Height: 20, RotationUD: -80}
+	scene.Render = RenderConfig{Width: 64, Height: 48, Scale: 1, Fovy: 40, Picker: "raycast"}
+	//at full resolution, in the tiles of the terrain and mapped back to the scene
+	scene.LOD = LODConfig{TileCells: 8, LeafPrimitives: 256}
+	if err := SaveScene(scene.Scene, properties, compositeVector, primitiveIndex); err != nil {
+		t.Fatal(err)
+	}
+	earlier := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(scene.Scene, earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+	path := scene.LookupPath()
+	if path != filepath.Join(dir, "grid.lookup") {
+		t.Errorf("the lookup of the camera section is at %s", path)
+	}
+
+	lookup, err := CameraLookup(path, scene, properties, compositeVector, primitiveIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup.Width != scene.Render.Width || lookup.Height != scene.Render.Height {
+		t.Fatalf("the lookup is %dx%d for a %dx%d image", lookup.Width, lookup.Height, scene.Render.Width, scene.Render.Height)
+	}
+
+	//every pixel picks what the ray meets; at an edge the raster may give the primitive next to
+	//it, whose plane meets the ray close by
+	location := Modeller(properties, &MapVector{Latitude: scene.Camera.Latitude, Longtitude: scene.Camera.Longitude})
+	inverse := CameraModel(properties.MaxVert, location, scene.Camera, scene.Render).Inverse()
+	hits, same := 0, 0
+	for y := 0; y < lookup.Height; y++ {
+		for x := 0; x < lookup.Width; x++ {
+			primitive, gcs, ok := lookup.Pick(x, y)
+			want, wantGCS, wantOK := brutePick(inverse, x, y, scene.Render, properties.MaxVert, compositeVector, primitiveIndex)
+			if ok != wantOK {
+				t.Errorf("pixel %d, %d: picked %t, the ray meets primitive %d", x, y, ok, want)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			hits++
+			if primitive == want {
+				same++
+			}
+			if math.Abs(gcs.X-wantGCS.X) > 1e-7 || math.Abs(gcs.Y-wantGCS.Y) > 0.01 || math.Abs(gcs.Z-wantGCS.Z) > 1e-7 {
+				t.Errorf("pixel %d, %d picks primitive %d at %v, want %d at %v", x, y, primitive, gcs, want, wantGCS)
+			}
+		}
+	}
+	if hits < lookup.Width*lookup.Height/2 || same < hits*9/10 {
+		t.Errorf("%d of %d pixels pick the grid, %d of them the primitive the ray meets", hits,
+			lookup.Width*lookup.Height, same)
+	}
+	if _, _, ok := lookup.Pick(-1, 0); ok {
+		t.Error("a pixel left of the image picks")
+	}
+	if _, _, ok := lookup.Pick(0, lookup.Height); ok {
+		t.Error("a pixel below the image picks")
+	}
+
+	//the lookup is saved and read back as it was
+	loaded, err := loadLookup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameKey(loaded.key, lookup.key) {
+		t.Errorf("the key is %v, baked %v", loaded.key, lookup.key)
+	}
+	for i := range lookup.Primitive {
+		if loaded.Primitive[i] != lookup.Primitive[i] || !sameKey(loaded.gcs[i*3:i*3+3], lookup.gcs[i*3:i*3+3]) {
+			t.Fatalf("pixel %d is primitive %d at %v, baked %d at %v", i, loaded.Primitive[i], loaded.gcs[i*3:i*3+3],
+				lookup.Primitive[i], lookup.gcs[i*3:i*3+3])
+		}
+	}
+
+	//a marked lookup tells if it is read or baked again
+	mark := func() {
+		marked := *lookup
+		marked.Primitive = make([]int32, len(lookup.Primitive))
+		for i := range marked.Primitive {
+			marked.Primitive[i] = 7
+		}
+		if err := saveLookup(path, &marked); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string, scene *SceneConfig) bool {
+		lookup, err := CameraLookup(path, scene, properties, compositeVector, primitiveIndex)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		primitive, _, _ := lookup.Pick(scene.Render.Width/2, scene.Render.Height/2)
+		return primitive == 7
+	}
+
+	mark()
+	if !read("same camera", scene) {
+		t.Error("the lookup of the same camera and scene is baked again")
+	}
+
+	changed := *scene
+	changed.Camera.RotationLR += 5
+	if read("turned camera", &changed) {
+		t.Error("the lookup of a camera turned is read")
+	}
+	mark()
+	changed = *scene
+	changed.Camera.Intrinsics = IntrinsicsConfig{Fx: 70, Fy: 70, Cx: 32, Cy: 24, K1: -0.1}
+	if read("lens", &changed) {
+		t.Error("the lookup of a camera with another lens is read")
+	}
+	mark()
+	changed = *scene
+	changed.Render.Width, changed.Render.Height = 80, 60
+	if read("image size", &changed) {
+		t.Error("the lookup of another image size is read")
+	}
+
+	//a decimated terrain still picks the primitives of the scene, the GCS off by no more than
+	//the rolling ground moves under the LOD error
+	mark()
+	changed = *scene
+	changed.LOD = DefaultSceneConfig().LOD
+	if read("level of detail", &changed) {
+		t.Error("the lookup of another level of detail is read")
+	}
+	decimated, err := CameraLookup(path, &changed, properties, compositeVector, primitiveIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits = 0
+	for y := 0; y < decimated.Height; y++ {
+		for x := 0; x < decimated.Width; x++ {
+			primitive, gcs, ok := decimated.Pick(x, y)
+			_, wantGCS, wantOK := brutePick(inverse, x, y, scene.Render, properties.MaxVert, compositeVector, primitiveIndex)
+			if !ok || !wantOK {
+				continue
+			}
+			hits++
+			if primitive >= len(primitiveIndex) || math.Abs(gcs.X-wantGCS.X) > 1e-6 ||
+				math.Abs(gcs.Y-wantGCS.Y) > changed.LOD.MaxError || math.Abs(gcs.Z-wantGCS.Z) > 1e-6 {
+				t.Errorf("decimated: pixel %d, %d picks primitive %d at %v, want about %v", x, y, primitive, gcs, wantGCS)
+			}
+		}
+	}
+	if hits < decimated.Width*decimated.Height/2 {
+		t.Errorf("decimated: %d of %d pixels pick the grid", hits, decimated.Width*decimated.Height)
+	}
+
+	//a scene built again after the lookup was baked
+	mark()
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(scene.Scene, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if read("scene built again", scene) {
+		t.Error("the lookup of a scene built again is read")
+	}
+}
@@ -0,0 +1,237 @@
+//Package site is the model of a site and the cameras picking on it, shared by 2DGCS and socketGCS:
+//its config, scene file, terrain LOD and lookup rasters
+package site
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/gocarina/gocsv"
+)
+
+//MapVector is a vertex of the model and the GCS it is at
+type MapVector struct {
+	VertX, VertY, VertZ             float64
+	Latitude, Longtitude, Elevation float64
+}
+
+//MapPrimitiveIndex is a triangle of the model by the indices of its vectors
+type MapPrimitiveIndex struct {
+	PrimitiveBottom, PrimitiveTop, PrimitiveLeft int
+}
+
+//ModelProperties is the header of the scene file. The model is in metres
+//in the local frame of the origin; MinVert* is the reference every vertex is localized to:
+//the south and east edges for X and Z and the lowest ground point for Y, which points down.
+//MaxVert* is the extent of the localized model
+type ModelProperties struct {
+	MaxVertX, MaxVertY, MaxVertZ, MaxVert            float64
+	MinVertX, MinVertY, MinVertZ                     float64
+	OriginLatitude, OriginLongitude, OriginElevation float64
+
+	frame *ENUFrame
+}
+
+//VectorModelFile and PrimitiveModelFile are the CSVs the download and the triangulator write the
+//terrain to, which the scene of the config is built from
+const (
+	VectorModelFile    = "resultVectorModel.csv"
+	PrimitiveModelFile = "resultPrimativeModel.csv"
+)
+
+//ModelIsStale tells if the scene file has to be rebuilt from the vectors CSV: it is missing, older than
+//the vectors or of another version; without the vectors whatever was built before is used
+func ModelIsStale(vectorPath, scenePath string) bool {
+	vectors, err := os.Stat(vectorPath)
+	if err != nil {
+		return false
+	}
+	built, err := os.Stat(scenePath)
+	if err != nil || built.ModTime().Before(vectors.ModTime()) {
+		return true
+	}
+	//a scene of another version
+	file, err := os.Open(scenePath)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	return sceneFormat.readHeader(file) != nil
+}
+
+//BuildModel builds the scene file from the GCS vectors and primitives CSVs
+func BuildModel(vectorPath, primitivePath, scenePath string) (*ModelProperties, []*MapVector, []*MapPrimitiveIndex, error) {
+	compositeVector, primitiveIndex, err := LoadVectorModel(vectorPath, primitivePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return BuildScene(compositeVector, primitiveIndex, scenePath)
+}
+
+//BuildScene turns the GCS vectors into a cartesian model in metres: every vertex goes into
+//the East-North-Up frame of the centre of the data at its lowest elevation, and is localized
+//to the edges and lowest ground point; the largest extent is what projection normalizes by.
+//Latitude, longitude and elevation stay on every vertex for picking
+func BuildScene(compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex,
+	scenePath string) (*ModelProperties, []*MapVector, []*MapPrimitiveIndex, error) {
+
+	if len(compositeVector) == 0 {
+		return nil, nil, nil, fmt.Errorf("scene %s: no vectors", scenePath)
+	}
+
+	minLat, maxLat := compositeVector[0].Latitude, compositeVector[0].Latitude
+	minLng, maxLng := compositeVector[0].Longtitude, compositeVector[0].Longtitude
+	minElevation := compositeVector[0].Elevation
+	for _, vector := range compositeVector {
+		minLat, maxLat = math.Min(minLat, vector.Latitude), math.Max(maxLat, vector.Latitude)
+		minLng, maxLng = math.Min(minLng, vector.Longtitude), math.Max(maxLng, vector.Longtitude)
+		minElevation = math.Min(minElevation, vector.Elevation)
+	}
+	properties := &ModelProperties{
+		MinVertX:        math.Inf(1),
+		MinVertY:        math.Inf(-1),
+		MinVertZ:        math.Inf(1),
+		OriginLatitude:  (minLat + maxLat) / 2,
+		OriginLongitude: (minLng + maxLng) / 2,
+		OriginElevation: minElevation,
+	}
+	properties.frame = NewENUFrame(properties.OriginLatitude, properties.OriginLongitude, properties.OriginElevation)
+
+	//finds the edges and the lowest ground point; Y points down
+	for _, vector := range compositeVector {
+		vector.VertX, vector.VertY, vector.VertZ = properties.frame.ToModel(
+			vector.Latitude, vector.Longtitude, vector.Elevation)
+		properties.MinVertX = math.Min(properties.MinVertX, vector.VertX)
+		properties.MinVertY = math.Max(properties.MinVertY, vector.VertY)
+		properties.MinVertZ = math.Min(properties.MinVertZ, vector.VertZ)
+	}
+
+	//localize the area using the reference of each component
+	for _, vector := range compositeVector {
+		vector.VertX -= properties.MinVertX
+		vector.VertY -= properties.MinVertY
+		vector.VertZ -= properties.MinVertZ
+		properties.MaxVertX = math.Max(properties.MaxVertX, vector.VertX)
+		properties.MaxVertY = math.Max(properties.MaxVertY, -vector.VertY)
+		properties.MaxVertZ = math.Max(properties.MaxVertZ, vector.VertZ)
+	}
+	properties.MaxVert = math.Max(math.Max(properties.MaxVertX, properties.MaxVertZ), properties.MaxVertY)
+	if properties.MaxVert == 0 {
+		return nil, nil, nil, fmt.Errorf("scene %s: all vectors are at one point", scenePath)
+	}
+	return properties, compositeVector, primitiveIndex, SaveScene(scenePath, properties, compositeVector, primitiveIndex)
+}
+
+//Localize places a GCS vector in the model the same way BuildModel placed the terrain
+func (p *ModelProperties) Localize(vector *MapVector) {
+	x, y, z := p.enu().ToModel(vector.Latitude, vector.Longtitude, vector.Elevation)
+	vector.VertX = x - p.MinVertX
+	vector.VertY = y - p.MinVertY
+	vector.VertZ = z - p.MinVertZ
+}
+
+//GCS is where a point of the model is; the inverse of Localize
+func (p *ModelProperties) GCS(x, y, z float64) (lat, lng, elevation float64) {
+	//model axes are north, down and west
+	return p.enu().ToGCS(-(z + p.MinVertZ), x+p.MinVertX, -(y + p.MinVertY))
+}
+
+//enu is the frame of the origin; properties put together by hand get theirs on first use
+func (p *ModelProperties) enu() *ENUFrame {
+	if p.frame == nil {
+		p.frame = NewENUFrame(p.OriginLatitude, p.OriginLongitude, p.OriginElevation)
+	}
+	return p.frame
+}
+
+//LoadModelProperties reads the single row of resultNormModelProperties.csv
+//of a model built before the scene file
+func LoadModelProperties(path string) (*ModelProperties, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	row, err := csv.NewReader(file).Read()
+	if err != nil {
+		return nil, fmt.Errorf("model properties %s: %s", path, err)
+	}
+	if len(row) < 10 {
+		return nil, fmt.Errorf("model properties %s: %d values instead of 10; rebuild the model", path, len(row))
+	}
+	var values [10]float64
+	for i := range values {
+		values[i], err = strconv.ParseFloat(row[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("model properties %s: %s", path, err)
+		}
+	}
+	properties := &ModelProperties{
+		MaxVertX:        values[0],
+		MaxVertY:        values[1],
+		MaxVertZ:        values[2],
+		MaxVert:         values[3],
+		MinVertX:        values[4],
+		MinVertY:        values[5],
+		MinVertZ:        values[6],
+		OriginLatitude:  values[7],
+		OriginLongitude: values[8],
+		OriginElevation: values[9],
+	}
+	properties.frame = NewENUFrame(properties.OriginLatitude, properties.OriginLongitude, properties.OriginElevation)
+	return properties, nil
+}
+
+//LoadVectorModel reads a model as vectors and the primitives indexing into them
+func LoadVectorModel(vectorPath, primitivePath string) ([]*MapVector, []*MapPrimitiveIndex, error) {
+	compositeVector := []*MapVector{}
+	primitiveIndex := []*MapPrimitiveIndex{}
+
+	clientsFile, err := os.Open(vectorPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clientsFile.Close()
+	if err := gocsv.UnmarshalFile(clientsFile, &compositeVector); err != nil {
+		return nil, nil, fmt.Errorf("model %s: %s", vectorPath, err)
+	}
+	clientsFile2, err := os.Open(primitivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clientsFile2.Close()
+	if err := gocsv.UnmarshalFile(clientsFile2, &primitiveIndex); err != nil {
+		return nil, nil, fmt.Errorf("model %s: %s", primitivePath, err)
+	}
+	for _, index := range primitiveIndex {
+		for _, i := range []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft} {
+			if i < 0 || i >= len(compositeVector) {
+				return nil, nil, fmt.Errorf("model %s: primitive vertex %d of %d vectors", primitivePath, i, len(compositeVector))
+			}
+		}
+	}
+	return compositeVector, primitiveIndex, nil
+}
+
+//WriteVectorModel writes a mesh as VectorModelFile and PrimitiveModelFile, the CSVs BuildModel
+//builds the model of, whether it was downloaded or not
+func WriteVectorModel(compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex) error {
+	clientsFile, err := os.Create(VectorModelFile)
+	if err != nil {
+		return err
+	}
+	defer clientsFile.Close()
+	if err := gocsv.MarshalFile(&compositeVector, clientsFile); err != nil {
+		return err
+	}
+	clientsFile2, err := os.Create(PrimitiveModelFile)
+	if err != nil {
+		return err
+	}
+	defer clientsFile2.Close()
+	return gocsv.MarshalFile(&primitiveIndex, clientsFile2)
+}
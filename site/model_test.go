@@ -0,0 +1,141 @@
+package site
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//knownGrid is rows by cols vectors of GCS only, from the south-west corner north and east in
+//steps of degrees, the ground rising by rise metres a row and a metre a column
+func knownGrid(rows, cols int, step, rise float64) ([]*MapVector, []*MapPrimitiveIndex) {
+	var compositeVector []*MapVector
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			compositeVector = append(compositeVector, &MapVector{
+				Latitude:   43.45 + float64(row)*step,
+				Longtitude: -80.49 + float64(col)*step*2/3,
+				Elevation:  330 + float64(row)*rise + float64(col),
+			})
+		}
+	}
+	var primitiveIndex []*MapPrimitiveIndex
+	for row := 0; row < rows-1; row++ {
+		for col := 0; col < cols-1; col++ {
+			southWest := row*cols + col
+			primitiveIndex = append(primitiveIndex,
+				&MapPrimitiveIndex{PrimitiveBottom: southWest, PrimitiveTop: southWest + 1, PrimitiveLeft: southWest + cols},
+				&MapPrimitiveIndex{PrimitiveBottom: southWest + cols, PrimitiveTop: southWest + 1, PrimitiveLeft: southWest + cols + 1})
+		}
+	}
+	return compositeVector, primitiveIndex
+}
+
+func TestBuildScene(t *testing.T) {
+	dir, err := ioutil.TempDir("", "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name       string
+		step, rise float64
+		maxVert    func(p *ModelProperties) float64
+	}{
+		//about 200 by 146 metres, rising 7 metres: the north extent normalizes
+		{"wide", 0.0009, 2, func(p *ModelProperties) float64 { return p.MaxVertX }},
+		//about 2 by 1.5 metres, rising 23 metres: the height normalizes
+		{"steep", 0.000009, 10, func(p *ModelProperties) float64 { return p.MaxVertY }},
+	} {
+		compositeVector, primitiveIndex := knownGrid(3, 4, test.step, test.rise)
+		path := filepath.Join(dir, test.name+".scene")
+		properties, built, _, err := BuildScene(compositeVector, primitiveIndex, path)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		//the origin is the centre of the data at its lowest elevation
+		if math.Abs(properties.OriginLatitude-(43.45+test.step)) > 1e-12 ||
+			math.Abs(properties.OriginLongitude-(-80.49+test.step)) > 1e-12 || properties.OriginElevation != 330 {
+			t.Errorf("%s: the origin is %v, %v, %v", test.name, properties.OriginLatitude, properties.OriginLongitude,
+				properties.OriginElevation)
+		}
+
+		//metres of a degree of latitude and of longitude at the middle of the grid, WGS84
+		middle := (43.45 + test.step) * math.Pi / 180
+		northMetres := 111132.954 - 559.822*math.Cos(2*middle) + 1.175*math.Cos(4*middle)
+		eastMetres := 111412.84*math.Cos(middle) - 93.5*math.Cos(3*middle) + 0.118*math.Cos(5*middle)
+
+		//the south and east edges are half the extent from the origin, the lowest ground at it; the
+		//tangent plane of the frame leaves the corners some millimetres off the ground
+		north, east := test.step*2*northMetres, test.step*2*eastMetres
+		if math.Abs(properties.MinVertX+north/2) > 0.05 || math.Abs(properties.MinVertZ+east/2) > 0.05 ||
+			math.Abs(properties.MinVertY) > 0.005 {
+			t.Errorf("%s: the reference is %v, %v, %v, want about %v, 0, %v", test.name, properties.MinVertX,
+				properties.MinVertY, properties.MinVertZ, -north/2, -east/2)
+		}
+		height := 2*test.rise + 3
+		if math.Abs(properties.MaxVertX-north) > 0.05 || math.Abs(properties.MaxVertZ-east) > 0.05 ||
+			math.Abs(properties.MaxVertY-height) > 0.005 {
+			t.Errorf("%s: the extent is %v, %v, %v, want about %v, %v, %v", test.name, properties.MaxVertX,
+				properties.MaxVertY, properties.MaxVertZ, north, height, east)
+		}
+		if properties.MaxVert != test.maxVert(properties) ||
+			properties.MaxVert != math.Max(math.Max(properties.MaxVertX, properties.MaxVertY), properties.MaxVertZ) {
+			t.Errorf("%s: normalized by %v of the extent %v, %v, %v", test.name, properties.MaxVert,
+				properties.MaxVertX, properties.MaxVertY, properties.MaxVertZ)
+		}
+
+		//localized, the model starts at 0 on every axis and stays within the extent; the lowest
+		//ground, the south-west corner, is at the bottom of the west edge
+		minX, maxY, minZ := math.Inf(1), math.Inf(-1), math.Inf(1)
+		for _, vector := range built {
+			minX, maxY, minZ = math.Min(minX, vector.VertX), math.Max(maxY, vector.VertY), math.Min(minZ, vector.VertZ)
+			if vector.VertX > properties.MaxVertX || -vector.VertY > properties.MaxVertY || vector.VertZ > properties.MaxVertZ {
+				t.Errorf("%s: vector %+v is outside the extent", test.name, *vector)
+			}
+		}
+		if minX != 0 || maxY != 0 || minZ != 0 {
+			t.Errorf("%s: the localized model starts at %v, %v, %v", test.name, minX, maxY, minZ)
+		}
+		corner := built[0]
+		if math.Abs(corner.VertX) > 0.005 || math.Abs(corner.VertY) > 0.005 || math.Abs(corner.VertZ-properties.MaxVertZ) > 0.005 {
+			t.Errorf("%s: the south-west corner is at %v, %v, %v", test.name, corner.VertX, corner.VertY, corner.VertZ)
+		}
+
+		//what is saved is what was built
+		loaded, _, _, err := LoadScene(path)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if loaded.values() != properties.values() {
+			t.Errorf("%s: saved %v, built %v", test.name, loaded.values(), properties.values())
+		}
+	}
+
+	for _, test := range []struct {
+		name            string
+		compositeVector []*MapVector
+		err             string
+	}{
+		{"empty", nil, "no vectors"},
+		{"one point", []*MapVector{
+			{Latitude: 43.45, Longtitude: -80.49, Elevation: 330},
+			{Latitude: 43.45, Longtitude: -80.49, Elevation: 330},
+			{Latitude: 43.45, Longtitude: -80.49, Elevation: 330},
+		}, "all vectors are at one point"},
+	} {
+		path := filepath.Join(dir, strings.Replace(test.name, " ", "-", -1)+".scene")
+		primitiveIndex := []*MapPrimitiveIndex{{PrimitiveBottom: 0, PrimitiveTop: 1, PrimitiveLeft: 2}}
+		if _, _, _, err := BuildScene(test.compositeVector, primitiveIndex, path); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: builds with error %v, want %q", test.name, err, test.err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: a scene is saved: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package site
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nomnom-ray/fauxgl"
+)
+
+//TerrainTree is a quadtree of square tiles over the X/Z extent of the model; every tile
+//holds its primitives simplified to the tile's level, a leaf holds them at full resolution.
+//A primitive belongs to the one tile of each level its centroid is in
+type TerrainTree struct {
+	root            *terrainTile
+	CompositeVector []*MapVector
+	lod             LODConfig
+	scene           *sceneGrid //the whole model picks on the terrain are mapped back to
+
+	uses    []int  //primitives using each vector; a vector used outside a tile is on its border
+	outline []bool //vectors on the outline of the whole terrain
+}
+
+type terrainTile struct {
+	minX, minZ, size float64 //metres in the model
+	minY, maxY       float64
+	boxMin, boxMax   fauxgl.Vector //bounds of its primitives, which may reach past the tile
+	primitiveIndex   []*MapPrimitiveIndex
+	children         []*terrainTile //nil at a leaf
+}
+
+//a tile is not split any further than this, whatever the primitives in it
+const maxTileDepth = 16
+
+//NewTerrain builds the quadtree of the terrain of the scene file, decimated to the LOD error;
+//picks on it are mapped back to the primitives of the scene by ScenePick
+func NewTerrain(scenePath string, properties *ModelProperties,
+	compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex, lod LODConfig) (*TerrainTree, error) {
+
+	scene := newSceneGrid(compositeVector, primitiveIndex)
+	if lod.MaxError > 0 {
+		var err error
+		compositeVector, primitiveIndex, err = decimated(scenePath, properties, compositeVector, primitiveIndex, lod.MaxError)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t := newTerrainTree(compositeVector, primitiveIndex, lod)
+	t.scene = scene
+	return t, nil
+}
+
+//decimated reads the terrain of the scene file decimated to maxError from the scene file it was
+//kept in, and decimates it and keeps it when there is none or it is older than the scene file
+func decimated(scenePath string, properties *ModelProperties, compositeVector []*MapVector,
+	primitiveIndex []*MapPrimitiveIndex, maxError float64) ([]*MapVector, []*MapPrimitiveIndex, error) {
+
+	path := decimatedPath(scenePath, maxError)
+	kept, err := os.Stat(path)
+	built, sceneErr := os.Stat(scenePath)
+	if err == nil && sceneErr == nil && !kept.ModTime().Before(built.ModTime()) {
+		if _, keptVector, keptIndex, err := LoadScene(path); err == nil {
+			return keptVector, keptIndex, nil
+		}
+	}
+
+	compositeVector, primitiveIndex = simplifyMesh(compositeVector, primitiveIndex, maxError)
+	return compositeVector, primitiveIndex, SaveScene(path, properties, compositeVector, primitiveIndex)
+}
+
+//decimatedPath is where the terrain of a scene file decimated to maxError is kept:
+//next to it, named after it and the error, e.g. resultNormModel.lod0.05.scene
+func decimatedPath(scenePath string, maxError float64) string {
+	extension := filepath.Ext(scenePath)
+	return fmt.Sprintf("%s.lod%g%s", strings.TrimSuffix(scenePath, extension), maxError, extension)
+}
+
+func newTerrainTree(compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex, lod LODConfig) *TerrainTree {
+	minX, minZ := math.Inf(1), math.Inf(1)
+	maxX, maxZ := math.Inf(-1), math.Inf(-1)
+	for _, vector := range compositeVector {
+		minX, maxX = math.Min(minX, vector.VertX), math.Max(maxX, vector.VertX)
+		minZ, maxZ = math.Min(minZ, vector.VertZ), math.Max(maxZ, vector.VertZ)
+	}
+
+	t := &TerrainTree{
+		CompositeVector: compositeVector,
+		lod:             lod,
+		uses:            make([]int, len(compositeVector)),
+		outline:         make([]bool, len(compositeVector)),
+	}
+	edges := make(map[[2]int]int)
+	for _, index := range primitiveIndex {
+		triangle := []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft}
+		for k, i := range triangle {
+			t.uses[i]++
+			edge := [2]int{i, triangle[(k+1)%3]}
+			if edge[0] > edge[1] {
+				edge[0], edge[1] = edge[1], edge[0]
+			}
+			edges[edge]++
+		}
+	}
+	//an edge of only one primitive is on the outline
+	for edge, count := range edges {
+		if count == 1 {
+			t.outline[edge[0]], t.outline[edge[1]] = true, true
+		}
+	}
+
+	if len(primitiveIndex) > 0 {
+		t.root = t.buildTile(minX, minZ, math.Max(math.Max(maxX-minX, maxZ-minZ), 1e-9), 0, primitiveIndex)
+	}
+	return t
+}
+
+func (t *TerrainTree) buildTile(minX, minZ, size float64, depth int, primitiveIndex []*MapPrimitiveIndex) *terrainTile {
+
+	tile := &terrainTile{minX: minX, minZ: minZ, size: size,
+		boxMin: fauxgl.Vector{X: math.Inf(1), Y: math.Inf(1), Z: math.Inf(1)},
+		boxMax: fauxgl.Vector{X: math.Inf(-1), Y: math.Inf(-1), Z: math.Inf(-1)},
+	}
+	for _, index := range primitiveIndex {
+		for _, i := range []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft} {
+			vector := t.CompositeVector[i]
+			position := fauxgl.Vector{X: vector.VertX, Y: vector.VertY, Z: vector.VertZ}
+			tile.boxMin, tile.boxMax = tile.boxMin.Min(position), tile.boxMax.Max(position)
+		}
+	}
+	tile.minY, tile.maxY = tile.boxMin.Y, tile.boxMax.Y
+	if len(primitiveIndex) <= t.lod.LeafPrimitives || depth >= maxTileDepth {
+		tile.primitiveIndex = primitiveIndex
+		return tile
+	}
+	tile.primitiveIndex = t.simplify(tile, primitiveIndex)
+
+	//split by centroid into the south-east, north-east, south-west and north-west quarters
+	half := size / 2
+	quarters := make([][]*MapPrimitiveIndex, 4)
+	for _, index := range primitiveIndex {
+		a, b, c := t.CompositeVector[index.PrimitiveBottom], t.CompositeVector[index.PrimitiveTop], t.CompositeVector[index.PrimitiveLeft]
+		quarter := 0
+		if (a.VertX+b.VertX+c.VertX)/3 >= minX+half {
+			quarter++
+		}
+		if (a.VertZ+b.VertZ+c.VertZ)/3 >= minZ+half {
+			quarter += 2
+		}
+		quarters[quarter] = append(quarters[quarter], index)
+	}
+	for quarter, primitives := range quarters {
+		if len(primitives) == 0 {
+			continue
+		}
+		tile.children = append(tile.children, t.buildTile(
+			minX+float64(quarter%2)*half, minZ+float64(quarter/2)*half, half, depth+1, primitives))
+	}
+	return tile
+}
+
+//simplify clusters the vectors of a tile into a grid of TileCells x TileCells cells and
+//replaces every cluster by its vector nearest to the cluster's mean, so latitude, longitude and
+//elevation stay those of a real sample. Vectors on the border of the tile are kept, so the
+//tile meets its neighbours without cracks whatever their level, and so is the outline of the terrain
+func (t *TerrainTree) simplify(tile *terrainTile, primitiveIndex []*MapPrimitiveIndex) []*MapPrimitiveIndex {
+	inside := make(map[int]int)
+	for _, index := range primitiveIndex {
+		inside[index.PrimitiveBottom]++
+		inside[index.PrimitiveTop]++
+		inside[index.PrimitiveLeft]++
+	}
+
+	cellSize := tile.size / float64(t.lod.TileCells)
+	clusters := make(map[[2]int][]int)
+	for i, count := range inside {
+		if count < t.uses[i] || t.outline[i] {
+			continue
+		}
+		vector := t.CompositeVector[i]
+		cell := [2]int{
+			int(math.Floor((vector.VertX - tile.minX) / cellSize)),
+			int(math.Floor((vector.VertZ - tile.minZ) / cellSize)),
+		}
+		clusters[cell] = append(clusters[cell], i)
+	}
+
+	replaced := make(map[int]int)
+	for _, cluster := range clusters {
+		var meanX, meanY, meanZ float64
+		for _, i := range cluster {
+			meanX += t.CompositeVector[i].VertX
+			meanY += t.CompositeVector[i].VertY
+			meanZ += t.CompositeVector[i].VertZ
+		}
+		n := float64(len(cluster))
+		meanX, meanY, meanZ = meanX/n, meanY/n, meanZ/n
+
+		nearest, nearestDistance := cluster[0], math.Inf(1)
+		for _, i := range cluster {
+			vector := t.CompositeVector[i]
+			distance := (vector.VertX-meanX)*(vector.VertX-meanX) +
+				(vector.VertY-meanY)*(vector.VertY-meanY) +
+				(vector.VertZ-meanZ)*(vector.VertZ-meanZ)
+			if distance < nearestDistance {
+				nearest, nearestDistance = i, distance
+			}
+		}
+		for _, i := range cluster {
+			replaced[i] = nearest
+		}
+	}
+
+	vertex := func(i int) int {
+		if j, ok := replaced[i]; ok {
+			return j
+		}
+		return i
+	}
+	//a collapse can fold a primitive over another facing the other way; the pair cancels out,
+	//which keeps every edge inside the tile shared by two primitives
+	canonical := func(a, b, c int) [3]int {
+		key := [3]int{a, b, c}
+		for key[0] > key[1] || key[0] > key[2] {
+			key = [3]int{key[1], key[2], key[0]}
+		}
+		return key
+	}
+	count := make(map[[3]int]int)
+	for _, index := range primitiveIndex {
+		a, b, c := vertex(index.PrimitiveBottom), vertex(index.PrimitiveTop), vertex(index.PrimitiveLeft)
+		if a == b || b == c || c == a {
+			continue
+		}
+		if reverse := canonical(a, c, b); count[reverse] > 0 {
+			count[reverse]--
+		} else {
+			count[canonical(a, b, c)]++
+		}
+	}
+	simplified := []*MapPrimitiveIndex{}
+	for _, index := range primitiveIndex {
+		a, b, c := vertex(index.PrimitiveBottom), vertex(index.PrimitiveTop), vertex(index.PrimitiveLeft)
+		if key := canonical(a, b, c); a != b && b != c && c != a && count[key] > 0 {
+			count[key]--
+			simplified = append(simplified, &MapPrimitiveIndex{
+				PrimitiveBottom: a,
+				PrimitiveTop:    b,
+				PrimitiveLeft:   c,
+			})
+		}
+	}
+	return simplified
+}
+
+//SelectLOD walks down the tree from the root and stops at the first tile the camera is
+//at least Distance tile sizes away from; eye is the camera in the model in metres.
+//A Distance of 0 goes down to the leaves, all at full resolution. A tile out of the view of
+//the camera matrix is left out with everything under it
+func (t *TerrainTree) SelectLOD(eye fauxgl.Vector, cameraPerspective fauxgl.Matrix, maxVert float64) []*MapPrimitiveIndex {
+	x, y, z := eye.X, eye.Y, eye.Z
+	var primitiveIndex []*MapPrimitiveIndex
+	var visit func(tile *terrainTile)
+	visit = func(tile *terrainTile) {
+		if !tile.inView(cameraPerspective, maxVert) {
+			return
+		}
+		dx := math.Max(math.Max(tile.minX-x, x-(tile.minX+tile.size)), 0)
+		dy := math.Max(math.Max(tile.minY-y, y-tile.maxY), 0)
+		dz := math.Max(math.Max(tile.minZ-z, z-(tile.minZ+tile.size)), 0)
+		far := t.lod.Distance > 0 && math.Sqrt(dx*dx+dy*dy+dz*dz) >= t.lod.Distance*tile.size
+		if tile.children == nil || far {
+			primitiveIndex = append(primitiveIndex, tile.primitiveIndex...)
+			return
+		}
+		for _, child := range tile.children {
+			visit(child)
+		}
+	}
+	if t.root != nil {
+		visit(t.root)
+	}
+	return primitiveIndex
+}
+
+//inView tells if any of the box of the tile may be seen; the box is out of view only when all
+//its corners are beyond the same plane of the view volume, in clip space of the normalized model
+func (tile *terrainTile) inView(cameraPerspective fauxgl.Matrix, maxVert float64) bool {
+	var beyond [6]int
+	for corner := 0; corner < 8; corner++ {
+		position := tile.boxMin
+		if corner&1 != 0 {
+			position.X = tile.boxMax.X
+		}
+		if corner&2 != 0 {
+			position.Y = tile.boxMax.Y
+		}
+		if corner&4 != 0 {
+			position.Z = tile.boxMax.Z
+		}
+		clip := cameraPerspective.MulPositionW(position.DivScalar(maxVert))
+		for plane, out := range [6]bool{
+			clip.X < -clip.W, clip.X > clip.W,
+			clip.Y < -clip.W, clip.Y > clip.W,
+			clip.Z < -clip.W, clip.Z > clip.W,
+		} {
+			if out {
+				beyond[plane]++
+			}
+		}
+	}
+	for _, corners := range beyond {
+		if corners == 8 {
+			return false
+		}
+	}
+	return true
+}
+
+//sceneGrid buckets the primitives of the whole model by the cells of a grid over X/Z their
+//footprint covers, to find the primitives under a point
+type sceneGrid struct {
+	compositeVector      []*MapVector
+	primitiveIndex       []*MapPrimitiveIndex
+	minX, minZ, cellSize float64
+	cells                map[[2]int][]int
+}
+
+func newSceneGrid(compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex) *sceneGrid {
+	g := &sceneGrid{
+		compositeVector: compositeVector,
+		primitiveIndex:  primitiveIndex,
+		minX:            math.Inf(1),
+		minZ:            math.Inf(1),
+		cells:           make(map[[2]int][]int),
+	}
+	maxX, maxZ := math.Inf(-1), math.Inf(-1)
+	for _, vector := range compositeVector {
+		g.minX, maxX = math.Min(g.minX, vector.VertX), math.Max(maxX, vector.VertX)
+		g.minZ, maxZ = math.Min(g.minZ, vector.VertZ), math.Max(maxZ, vector.VertZ)
+	}
+	//about four primitives to a cell of a square terrain
+	g.cellSize = math.Max(math.Max(maxX-g.minX, maxZ-g.minZ)/math.Sqrt(float64(len(primitiveIndex))/4+1), 1e-9)
+
+	for i, index := range primitiveIndex {
+		a, b, c := compositeVector[index.PrimitiveBottom], compositeVector[index.PrimitiveTop], compositeVector[index.PrimitiveLeft]
+		low := g.cell(math.Min(math.Min(a.VertX, b.VertX), c.VertX), math.Min(math.Min(a.VertZ, b.VertZ), c.VertZ))
+		high := g.cell(math.Max(math.Max(a.VertX, b.VertX), c.VertX), math.Max(math.Max(a.VertZ, b.VertZ), c.VertZ))
+		for column := low[0]; column <= high[0]; column++ {
+			for row := low[1]; row <= high[1]; row++ {
+				g.cells[[2]int{column, row}] = append(g.cells[[2]int{column, row}], i)
+			}
+		}
+	}
+	return g
+}
+
+func (g *sceneGrid) cell(x, z float64) [2]int {
+	return [2]int{int(math.Floor((x - g.minX) / g.cellSize)), int(math.Floor((z - g.minZ) / g.cellSize))}
+}
+
+//ScenePick maps a point of the terrain, in the normalized model, back to the scene the terrain was
+//thinned out of: the primitive of the whole model under the point, the point dropped straight down
+//onto it and the GCS there. The terrain is made of surviving vectors of the scene and keeps its
+//outline, so the two meet at every vector of the terrain and part in between only as far as the
+//level of detail thins it out; where a mesh has more than one surface over the point the nearest is taken
+func (t *TerrainTree) ScenePick(position fauxgl.Vector, maxVert float64) (int, fauxgl.Vector, fauxgl.Vector, bool) {
+	g := t.scene
+	x, y, z := position.X*maxVert, position.Y*maxVert, position.Z*maxVert
+	//a point a rounding error past the edge of every primitive is taken by the one it is least past
+	picked, nearest, past := -1, math.Inf(1), math.Inf(1)
+	var weights [3]float64
+	for _, i := range g.cells[g.cell(x, z)] {
+		index := g.primitiveIndex[i]
+		a, b, c := g.compositeVector[index.PrimitiveBottom], g.compositeVector[index.PrimitiveTop], g.compositeVector[index.PrimitiveLeft]
+		//weights of the point in the footprint of the primitive; a wall has none
+		determinant := (b.VertZ-c.VertZ)*(a.VertX-c.VertX) + (c.VertX-b.VertX)*(a.VertZ-c.VertZ)
+		if math.Abs(determinant) < 1e-12 {
+			continue
+		}
+		wa := ((b.VertZ-c.VertZ)*(x-c.VertX) + (c.VertX-b.VertX)*(z-c.VertZ)) / determinant
+		wb := ((c.VertZ-a.VertZ)*(x-c.VertX) + (a.VertX-c.VertX)*(z-c.VertZ)) / determinant
+		wc := 1 - wa - wb
+		outside := math.Max(-math.Min(math.Min(wa, wb), wc), 0)
+		if outside > past || outside > 1e-3 {
+			continue
+		}
+		distance := math.Abs(wa*a.VertY + wb*b.VertY + wc*c.VertY - y)
+		if outside < past || distance < nearest {
+			picked, nearest, past, weights = i, distance, outside, [3]float64{wa, wb, wc}
+		}
+	}
+	if picked < 0 {
+		return 0, fauxgl.Vector{}, fauxgl.Vector{}, false
+	}
+
+	if past > 0 {
+		for k := range weights {
+			weights[k] = math.Max(weights[k], 0)
+		}
+		sum := weights[0] + weights[1] + weights[2]
+		weights = [3]float64{weights[0] / sum, weights[1] / sum, weights[2] / sum}
+	}
+	index := g.primitiveIndex[picked]
+	a, b, c := g.compositeVector[index.PrimitiveBottom], g.compositeVector[index.PrimitiveTop], g.compositeVector[index.PrimitiveLeft]
+	y = weights[0]*a.VertY + weights[1]*b.VertY + weights[2]*c.VertY
+	return picked, fauxgl.Vector{X: x / maxVert, Y: y / maxVert, Z: z / maxVert}, fauxgl.Vector{
+		X: weights[0]*a.Latitude + weights[1]*b.Latitude + weights[2]*c.Latitude,
+		Y: weights[0]*a.Elevation + weights[1]*b.Elevation + weights[2]*c.Elevation,
+		Z: weights[0]*a.Longtitude + weights[1]*b.Longtitude + weights[2]*c.Longtitude,
+	}, true
+}
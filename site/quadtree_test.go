@@ -0,0 +1,200 @@
+package site
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nomnom-ray/fauxgl"
+)
+
+//checkCracks fails where the primitives leave a gap: an edge of only one of them that is not on
+//the outline of the rows x cols grid they are of, or an edge of more than two
+func checkCracks(t *testing.T, name string, primitiveIndex []*MapPrimitiveIndex, rows, cols int) {
+	edges := make(map[[2]int]int)
+	for _, index := range primitiveIndex {
+		triangle := []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft}
+		for k, i := range triangle {
+			edge := [2]int{i, triangle[(k+1)%3]}
+			if edge[0] > edge[1] {
+				edge[0], edge[1] = edge[1], edge[0]
+			}
+			edges[edge]++
+		}
+	}
+	for edge, count := range edges {
+		fromRow, fromCol, toRow, toCol := edge[0]/cols, edge[0]%cols, edge[1]/cols, edge[1]%cols
+		outline := fromRow == toRow && (fromRow == 0 || fromRow == rows-1) ||
+			fromCol == toCol && (fromCol == 0 || fromCol == cols-1)
+		if count > 2 || count == 1 && !outline {
+			t.Errorf("%s: the edge from row %d column %d to row %d column %d is of %d primitives",
+				name, fromRow, fromCol, toRow, toCol, count)
+		}
+	}
+}
+
+func TestSelectLOD(t *testing.T) {
+	const rows, cols = 64, 64
+	_, compositeVector, primitiveIndex := testGrid(rows, cols, rolling)
+	original := make(map[*MapPrimitiveIndex]bool)
+	for _, index := range primitiveIndex {
+		original[index] = true
+	}
+	//the identity sees all of the model normalized by a kilometre
+	everything, maxVert := fauxgl.Identity(), 1000.0
+	lod := LODConfig{Distance: 2, TileCells: 4, LeafPrimitives: 256}
+	centroid := func(index *MapPrimitiveIndex) (float64, float64) {
+		a, b, c := compositeVector[index.PrimitiveBottom], compositeVector[index.PrimitiveTop], compositeVector[index.PrimitiveLeft]
+		return (a.VertX + b.VertX + c.VertX) / 3, (a.VertZ + b.VertZ + c.VertZ) / 3
+	}
+
+	//at a distance of 0 every primitive is at full resolution wherever the camera is
+	fullLOD := lod
+	fullLOD.Distance = 0
+	full := newTerrainTree(compositeVector, primitiveIndex, fullLOD).SelectLOD(fauxgl.Vector{X: -5000, Y: -2}, everything, maxVert)
+	if len(full) != len(primitiveIndex) {
+		t.Errorf("full resolution: %d of %d primitives", len(full), len(primitiveIndex))
+	}
+	for _, index := range full {
+		if !original[index] {
+			t.Errorf("full resolution: primitive %+v is simplified", *index)
+		}
+	}
+
+	//a camera 2 metres over the south-east corner sees the ground around it at full resolution and
+	//the north-west corner simplified; from 5 kilometres away all of it is simplified
+	tree := newTerrainTree(compositeVector, primitiveIndex, lod)
+	corner := tree.SelectLOD(fauxgl.Vector{X: 0, Y: -2, Z: 0}, everything, maxVert)
+	far := tree.SelectLOD(fauxgl.Vector{X: -5000, Y: -2, Z: 0}, everything, maxVert)
+	if !(len(far) < len(corner) && len(corner) < len(primitiveIndex)) {
+		t.Errorf("%d primitives from afar, %d from the corner, %d at full resolution; want fewer the farther",
+			len(far), len(corner), len(primitiveIndex))
+	}
+	near, simplified := 0, 0
+	for _, index := range corner {
+		x, z := centroid(index)
+		switch {
+		case x < 6 && z < 6:
+			near++
+			if !original[index] {
+				t.Errorf("corner: primitive %+v at %.1f, %.1f next to the camera is simplified", *index, x, z)
+			}
+		case x > 50 && z > 50:
+			simplified++
+			if original[index] {
+				t.Errorf("corner: primitive %+v at %.1f, %.1f across the grid is at full resolution", *index, x, z)
+			}
+		}
+	}
+	if near == 0 || simplified == 0 {
+		t.Errorf("corner: %d primitives next to the camera, %d across the grid", near, simplified)
+	}
+	for _, index := range far {
+		if original[index] {
+			t.Errorf("far: primitive %+v is at full resolution", *index)
+		}
+	}
+
+	//tiles of different levels meet without cracks
+	checkCracks(t, "corner", corner, rows, cols)
+	checkCracks(t, "far", far, rows, cols)
+	checkCracks(t, "middle", tree.SelectLOD(fauxgl.Vector{X: 31, Y: -2, Z: 20}, everything, maxVert), rows, cols)
+}
+
+func TestSelectLODCulls(t *testing.T) {
+	const rows, cols, maxVert = 64, 64, 100.0
+	_, compositeVector, primitiveIndex := testGrid(rows, cols, rolling)
+	tree := newTerrainTree(compositeVector, primitiveIndex, LODConfig{TileCells: 4, LeafPrimitives: 256})
+
+	//10 metres over the middle of the grid; Y is down
+	eye := fauxgl.Vector{X: 31.5, Y: -10, Z: 31.5}
+	camera := func(direction fauxgl.Vector) fauxgl.Matrix {
+		position := eye.DivScalar(maxVert)
+		return fauxgl.LookAt(position, position.Add(direction), fauxgl.Vector{Y: -1}).Perspective(60, 1, Near, Far)
+	}
+	inside := func(cameraPerspective fauxgl.Matrix, vector *MapVector) bool {
+		clip := cameraPerspective.MulPositionW(fauxgl.Vector{X: vector.VertX, Y: vector.VertY, Z: vector.VertZ}.DivScalar(maxVert))
+		return math.Abs(clip.X) <= clip.W && math.Abs(clip.Y) <= clip.W && math.Abs(clip.Z) <= clip.W
+	}
+
+	//looking north and down, the south of the grid is behind the camera
+	north := camera(fauxgl.Vector{X: 1, Y: 0.5})
+	selected := make(map[*MapPrimitiveIndex]bool)
+	for _, index := range tree.SelectLOD(eye, north, maxVert) {
+		selected[index] = true
+	}
+	if len(selected) == 0 || len(selected) >= len(primitiveIndex) {
+		t.Errorf("north: %d of %d primitives in view", len(selected), len(primitiveIndex))
+	}
+	for _, index := range primitiveIndex {
+		if !selected[index] && (inside(north, compositeVector[index.PrimitiveBottom]) ||
+			inside(north, compositeVector[index.PrimitiveTop]) || inside(north, compositeVector[index.PrimitiveLeft])) {
+			t.Errorf("north: primitive %+v is in view and left out", *index)
+		}
+	}
+	for index := range selected {
+		if a := compositeVector[index.PrimitiveBottom]; a.VertX < 16 && compositeVector[index.PrimitiveLeft].VertX < 16 {
+			t.Errorf("north: primitive %+v south of the camera is in view", *index)
+		}
+	}
+
+	//looking up there is no ground in view at all
+	if up := tree.SelectLOD(eye, camera(fauxgl.Vector{X: 0.1, Y: -1}), maxVert); len(up) != 0 {
+		t.Errorf("up: %d primitives in view", len(up))
+	}
+}
+
+//a point of a decimated terrain is mapped back to the primitive of the scene under it: at the
+//centroid of a primitive of the scene that primitive and its GCS, and on the terrain the same
+//point of the ground straight down
+func TestScenePick(t *testing.T) {
+	const rows, cols = 24, 24
+	_, compositeVector, primitiveIndex := testGrid(rows, cols, func(row, col int) float64 {
+		if row < rows/2 {
+			return 0
+		}
+		return rolling(row, col)
+	})
+	maxVert := 100.0
+	simplifiedVector, simplifiedIndex := simplifyMesh(compositeVector, primitiveIndex, 0.05)
+	if len(simplifiedIndex) >= len(primitiveIndex) {
+		t.Fatalf("decimated to %d of %d primitives; the test needs a thinned out terrain", len(simplifiedIndex), len(primitiveIndex))
+	}
+	terrain := newTerrainTree(simplifiedVector, simplifiedIndex, LODConfig{TileCells: 4, LeafPrimitives: 64})
+	terrain.scene = newSceneGrid(compositeVector, primitiveIndex)
+
+	centroid := func(vectors []*MapVector, index *MapPrimitiveIndex) (fauxgl.Vector, fauxgl.Vector) {
+		a, b, c := vectors[index.PrimitiveBottom], vectors[index.PrimitiveTop], vectors[index.PrimitiveLeft]
+		return fauxgl.Vector{X: a.VertX + b.VertX + c.VertX, Y: a.VertY + b.VertY + c.VertY, Z: a.VertZ + b.VertZ + c.VertZ}.DivScalar(3 * maxVert),
+			fauxgl.Vector{X: a.Latitude + b.Latitude + c.Latitude, Y: a.Elevation + b.Elevation + c.Elevation,
+				Z: a.Longtitude + b.Longtitude + c.Longtitude}.DivScalar(3)
+	}
+	for i, index := range primitiveIndex {
+		position, wantGCS := centroid(compositeVector, index)
+		primitive, picked, gcs, ok := terrain.ScenePick(position, maxVert)
+		if !ok || primitive != i || picked.Sub(position).Length() > 1e-12 || gcs.Sub(wantGCS).Length() > 1e-9 {
+			t.Errorf("the centroid of primitive %d picks %t, primitive %d at %v, want %v", i, ok, primitive, gcs, wantGCS)
+		}
+	}
+
+	for i, index := range simplifiedIndex {
+		position, _ := centroid(simplifiedVector, index)
+		primitive, picked, gcs, ok := terrain.ScenePick(position, maxVert)
+		if !ok {
+			t.Errorf("the centroid of terrain primitive %d picks nothing", i)
+			continue
+		}
+		if math.Abs(picked.X-position.X) > 1e-12 || math.Abs(picked.Z-position.Z) > 1e-12 || math.Abs(picked.Y-position.Y)*maxVert > 0.05 {
+			t.Errorf("the centroid of terrain primitive %d at %v is dropped to %v", i, position, picked)
+		}
+		a, b, c := compositeVector[primitiveIndex[primitive].PrimitiveBottom], compositeVector[primitiveIndex[primitive].PrimitiveTop],
+			compositeVector[primitiveIndex[primitive].PrimitiveLeft]
+		if gcs.Y < math.Min(math.Min(a.Elevation, b.Elevation), c.Elevation)-1e-9 ||
+			gcs.Y > math.Max(math.Max(a.Elevation, b.Elevation), c.Elevation)+1e-9 {
+			t.Errorf("the centroid of terrain primitive %d is at %.3f metres on primitive %d", i, gcs.Y, primitive)
+		}
+	}
+
+	if _, _, _, ok := terrain.ScenePick(fauxgl.Vector{X: -1, Z: -1}, maxVert); ok {
+		t.Error("a point off the terrain picks")
+	}
+}
@@ -0,0 +1,140 @@
+package site
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+//the binary scene file replaces resultNormModel.csv and resultNormModelProperties.csv;
+//a framed file of magic "2DGCSSCN" whose body is, all little endian:
+//	vertex count uint32, primitive count uint32
+//	CRS length uint32 and the CRS text
+//	ModelProperties as 10 float64, bounding box min xyz and max xyz as 6 float64
+//	per vertex VertX, VertY, VertZ, Latitude, Longtitude, Elevation as float64
+//	per primitive PrimitiveBottom, PrimitiveTop, PrimitiveLeft as uint32
+const (
+	sceneMagic   = "2DGCSSCN"
+	sceneVersion = 1
+	sceneCRS     = "WGS84 local ENU in metres around the origin; x north, y down, z west"
+)
+
+var sceneFormat = frameFormat{kind: "scene", magic: sceneMagic, version: sceneVersion}
+
+//SaveScene writes the model through a temporary file in one go
+func SaveScene(path string, properties *ModelProperties,
+	compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex) error {
+
+	minBox := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	maxBox := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, vector := range compositeVector {
+		for k, value := range []float64{vector.VertX, vector.VertY, vector.VertZ} {
+			minBox[k] = math.Min(minBox[k], value)
+			maxBox[k] = math.Max(maxBox[k], value)
+		}
+	}
+
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, []uint32{
+		uint32(len(compositeVector)), uint32(len(primitiveIndex)), uint32(len(sceneCRS))})
+	buffer.WriteString(sceneCRS)
+	binary.Write(&buffer, binary.LittleEndian, []float64{
+		properties.MaxVertX, properties.MaxVertY, properties.MaxVertZ, properties.MaxVert,
+		properties.MinVertX, properties.MinVertY, properties.MinVertZ,
+		properties.OriginLatitude, properties.OriginLongitude, properties.OriginElevation})
+	binary.Write(&buffer, binary.LittleEndian, append(minBox, maxBox...))
+
+	data, body := sceneFormat.newFrame(buffer.Len() + len(compositeVector)*6*8 + len(primitiveIndex)*3*4)
+	offset := copy(body, buffer.Bytes())
+	for _, vector := range compositeVector {
+		for _, value := range []float64{vector.VertX, vector.VertY, vector.VertZ,
+			vector.Latitude, vector.Longtitude, vector.Elevation} {
+			binary.LittleEndian.PutUint64(body[offset:], math.Float64bits(value))
+			offset += 8
+		}
+	}
+	for _, index := range primitiveIndex {
+		for _, value := range []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft} {
+			binary.LittleEndian.PutUint32(body[offset:], uint32(value))
+			offset += 4
+		}
+	}
+	return saveFrame(path, data)
+}
+
+//LoadScene reads the scene file and decodes the model
+func LoadScene(path string) (*ModelProperties, []*MapVector, []*MapPrimitiveIndex, error) {
+	body, err := sceneFormat.load(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(body) < 12 {
+		return nil, nil, nil, fmt.Errorf("scene %s: truncated", path)
+	}
+
+	vertexCount := int(binary.LittleEndian.Uint32(body))
+	primitiveCount := int(binary.LittleEndian.Uint32(body[4:]))
+	crsLength := int(binary.LittleEndian.Uint32(body[8:]))
+	offset := 12 + crsLength
+	if len(body) != offset+16*8+vertexCount*6*8+primitiveCount*3*4 {
+		return nil, nil, nil, fmt.Errorf("scene %s: size does not match its header", path)
+	}
+	if crs := string(body[12:offset]); crs != sceneCRS {
+		return nil, nil, nil, fmt.Errorf("scene %s: unknown CRS %q", path, crs)
+	}
+
+	float := func() float64 {
+		value := math.Float64frombits(binary.LittleEndian.Uint64(body[offset:]))
+		offset += 8
+		return value
+	}
+	properties := &ModelProperties{
+		MaxVertX:        float(),
+		MaxVertY:        float(),
+		MaxVertZ:        float(),
+		MaxVert:         float(),
+		MinVertX:        float(),
+		MinVertY:        float(),
+		MinVertZ:        float(),
+		OriginLatitude:  float(),
+		OriginLongitude: float(),
+		OriginElevation: float(),
+	}
+	properties.frame = NewENUFrame(properties.OriginLatitude, properties.OriginLongitude, properties.OriginElevation)
+	offset += 6 * 8 //bounding box
+
+	vectors := make([]MapVector, vertexCount)
+	compositeVector := make([]*MapVector, vertexCount)
+	for i := range vectors {
+		vectors[i] = MapVector{
+			VertX:      float(),
+			VertY:      float(),
+			VertZ:      float(),
+			Latitude:   float(),
+			Longtitude: float(),
+			Elevation:  float(),
+		}
+		compositeVector[i] = &vectors[i]
+	}
+
+	indices := make([]MapPrimitiveIndex, primitiveCount)
+	primitiveIndex := make([]*MapPrimitiveIndex, primitiveCount)
+	for i := range indices {
+		var triangle [3]int
+		for k := range triangle {
+			triangle[k] = int(binary.LittleEndian.Uint32(body[offset:]))
+			offset += 4
+			if triangle[k] >= vertexCount {
+				return nil, nil, nil, fmt.Errorf("scene %s: primitive %d uses vertex %d of %d", path, i, triangle[k], vertexCount)
+			}
+		}
+		indices[i] = MapPrimitiveIndex{
+			PrimitiveBottom: triangle[0],
+			PrimitiveTop:    triangle[1],
+			PrimitiveLeft:   triangle[2],
+		}
+		primitiveIndex[i] = &indices[i]
+	}
+	return properties, compositeVector, primitiveIndex, nil
+}
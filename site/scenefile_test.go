@@ -0,0 +1,252 @@
+package site
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+//values are the stored fields of the properties, in the order of the scene file
+func (p *ModelProperties) values() [10]float64 {
+	return [10]float64{p.MaxVertX, p.MaxVertY, p.MaxVertZ, p.MaxVert, p.MinVertX, p.MinVertY, p.MinVertZ,
+		p.OriginLatitude, p.OriginLongitude, p.OriginElevation}
+}
+
+//testGrid is a rows x cols grid of samples about a metre apart, rows south to north and columns west
+//to east, at the height over 330 metres height gives them, and its model: localized to the south
+//and east edges and the ground at 330 metres, like BuildScene does, in a frame of its south-west corner.
+//Every cell is two primitives counter-clockwise seen from above, as 2DGCS triangulates a grid
+func testGrid(rows, cols int, height func(row, col int) float64) (*ModelProperties, []*MapVector, []*MapPrimitiveIndex) {
+	const latitude, longitude, elevation = 43.45, -80.49, 330
+	gcs := func(row, col int) (float64, float64) {
+		return latitude + float64(row)*0.000009, longitude + float64(col)*0.0000124
+	}
+	properties := &ModelProperties{
+		OriginLatitude:  latitude,
+		OriginLongitude: longitude,
+		OriginElevation: elevation,
+		MaxVert:         100,
+	}
+	lat, lng := gcs(0, cols-1)
+	_, _, properties.MinVertZ = properties.enu().ToModel(lat, lng, elevation)
+
+	var compositeVector []*MapVector
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			vector := &MapVector{Elevation: elevation + height(row, col)}
+			vector.Latitude, vector.Longtitude = gcs(row, col)
+			properties.Localize(vector)
+			compositeVector = append(compositeVector, vector)
+		}
+	}
+	var primitiveIndex []*MapPrimitiveIndex
+	for row := 0; row < rows-1; row++ {
+		for col := 0; col < cols-1; col++ {
+			southWest := row*cols + col
+			primitiveIndex = append(primitiveIndex,
+				&MapPrimitiveIndex{PrimitiveBottom: southWest, PrimitiveTop: southWest + 1, PrimitiveLeft: southWest + cols},
+				&MapPrimitiveIndex{PrimitiveBottom: southWest + cols, PrimitiveTop: southWest + 1, PrimitiveLeft: southWest + cols + 1})
+		}
+	}
+	return properties, compositeVector, primitiveIndex
+}
+
+//rolling is ground rolling by up to half a metre
+func rolling(row, col int) float64 {
+	return 0.5 * math.Sin(float64(row)/3) * math.Cos(float64(col)/4)
+}
+
+func TestSceneRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	properties, compositeVector, primitiveIndex := testGrid(5, 7, rolling)
+	properties.MaxVertX, properties.MaxVertY, properties.MaxVertZ = 4.2, 0.5, 7.3
+	path := filepath.Join(dir, "grid.scene")
+	if err := SaveScene(path, properties, compositeVector, primitiveIndex); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is left behind: %v", err)
+	}
+
+	loaded, loadedVector, loadedIndex, err := LoadScene(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.values() != properties.values() {
+		t.Errorf("properties %v, saved %v", loaded.values(), properties.values())
+	}
+	if len(loadedVector) != len(compositeVector) || len(loadedIndex) != len(primitiveIndex) {
+		t.Fatalf("%d vectors and %d primitives, saved %d and %d",
+			len(loadedVector), len(loadedIndex), len(compositeVector), len(primitiveIndex))
+	}
+	for i, vector := range compositeVector {
+		if *loadedVector[i] != *vector {
+			t.Errorf("vector %d is %+v, saved %+v", i, *loadedVector[i], *vector)
+		}
+	}
+	for i, index := range primitiveIndex {
+		if *loadedIndex[i] != *index {
+			t.Errorf("primitive %d is %+v, saved %+v", i, *loadedIndex[i], *index)
+		}
+	}
+	//the frame of the origin comes with the properties
+	vector := compositeVector[8]
+	lat, lng, elevation := loaded.GCS(vector.VertX, vector.VertY, vector.VertZ)
+	if math.Abs(lat-vector.Latitude) > 1e-9 || math.Abs(lng-vector.Longtitude) > 1e-9 || math.Abs(elevation-vector.Elevation) > 1e-6 {
+		t.Errorf("vector %+v is at %v, %v, %v in the frame loaded", *vector, lat, lng, elevation)
+	}
+}
+
+func TestSceneDamaged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	properties, compositeVector, primitiveIndex := testGrid(3, 3, rolling)
+	saved := filepath.Join(dir, "saved.scene")
+	if err := SaveScene(saved, properties, compositeVector, primitiveIndex); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//rechecksum keeps a change from being caught as damage
+	rechecksum := func(data []byte) []byte {
+		end := len(data) - 4
+		binary.LittleEndian.PutUint32(data[end:], crc32.ChecksumIEEE(data[:end]))
+		return data
+	}
+
+	for _, test := range []struct {
+		name   string
+		damage func(data []byte) []byte
+		err    string
+	}{
+		{"flipped bit", func(data []byte) []byte {
+			data[frameHeaderSize+40] ^= 0x10
+			return data
+		}, "checksum mismatch"},
+		{"checksum", func(data []byte) []byte {
+			data[len(data)-1]++
+			return data
+		}, "checksum mismatch"},
+		{"truncated", func(data []byte) []byte { return data[:len(data)-9] }, "checksum mismatch"},
+		{"header only", func(data []byte) []byte { return data[:frameHeaderSize] }, "truncated"},
+		{"empty", func(data []byte) []byte { return nil }, "not a scene file"},
+		{"magic", func(data []byte) []byte {
+			copy(data, "2DGCSLUT")
+			return rechecksum(data)
+		}, "not a scene file"},
+		{"version", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[len(sceneMagic):], sceneVersion+1)
+			return rechecksum(data)
+		}, "version 2; this build reads version 1"},
+	} {
+		path := filepath.Join(dir, strings.Replace(test.name, " ", "-", -1)+".scene")
+		if err := ioutil.WriteFile(path, test.damage(append([]byte(nil), data...)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := LoadScene(path); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: loads with error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestLoadModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the vectors of the download, at paths other than those of the download
+	_, compositeVector, primitiveIndex := testGrid(4, 4, rolling)
+	vectorPath, primitivePath := filepath.Join(dir, "vectors.csv"), filepath.Join(dir, "primitives.csv")
+	scenePath := filepath.Join(dir, "site.scene")
+	writeCSV := func(path string, rows interface{}) {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := gocsv.MarshalFile(rows, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCSV(vectorPath, compositeVector)
+	writeCSV(primitivePath, primitiveIndex)
+
+	//without the scene file it is built
+	if !ModelIsStale(vectorPath, scenePath) {
+		t.Error("a missing scene is not stale")
+	}
+	_, built, _, err := LoadModel(vectorPath, primitivePath, scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(scenePath); err != nil {
+		t.Fatalf("the scene is not built: %s", err)
+	}
+	if ModelIsStale(vectorPath, scenePath) {
+		t.Error("the scene just built is stale")
+	}
+
+	//new vectors rebuild it; without the CSVs it is read as it is
+	for _, vector := range compositeVector {
+		vector.Elevation += 10
+	}
+	writeCSV(vectorPath, compositeVector)
+	earlier := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(scenePath, earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+	if !ModelIsStale(vectorPath, scenePath) {
+		t.Error("a scene older than its vectors is not stale")
+	}
+	_, asItIs, _, err := LoadModel("", "", scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asItIs[5].Elevation != built[5].Elevation {
+		t.Errorf("the scene read as it is has an elevation of %v, built with %v", asItIs[5].Elevation, built[5].Elevation)
+	}
+	_, rebuilt, _, err := LoadModel(vectorPath, primitivePath, scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt[5].Elevation != compositeVector[5].Elevation {
+		t.Errorf("the rebuilt scene has an elevation of %v, the vectors %v", rebuilt[5].Elevation, compositeVector[5].Elevation)
+	}
+	if ModelIsStale(vectorPath, scenePath) {
+		t.Error("the rebuilt scene is stale")
+	}
+
+	//a scene of another version is rebuilt
+	data, err := ioutil.ReadFile(scenePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[len(sceneMagic):], sceneVersion+1)
+	if err := ioutil.WriteFile(scenePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !ModelIsStale(vectorPath, scenePath) {
+		t.Error("a scene of another version is not stale")
+	}
+}
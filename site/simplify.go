@@ -0,0 +1,292 @@
+package site
+
+import (
+	"container/heap"
+	"math"
+)
+
+//quadric is the symmetric 4x4 matrix of the quadric error metric, upper triangle row by row
+type quadric [10]float64
+
+//verticalQuadric measures the squared vertical distance to the plane of a primitive, so the
+//error stays in metres of elevation however steep the primitive is; a wall would have no
+//vertical distance at all, so the slope counts as no steeper than 1 in 10
+func verticalQuadric(a, b, c *MapVector) quadric {
+	ux, uy, uz := b.VertX-a.VertX, b.VertY-a.VertY, b.VertZ-a.VertZ
+	vx, vy, vz := c.VertX-a.VertX, c.VertY-a.VertY, c.VertZ-a.VertZ
+	nx, ny, nz := uy*vz-uz*vy, uz*vx-ux*vz, ux*vy-uy*vx
+	length := math.Sqrt(nx*nx + ny*ny + nz*nz)
+	if length == 0 {
+		return quadric{}
+	}
+	nx, ny, nz = nx/length, ny/length, nz/length
+	d := -(nx*a.VertX + ny*a.VertY + nz*a.VertZ)
+	scale := 1 / math.Max(math.Abs(ny), 0.1)
+	nx, ny, nz, d = nx*scale, ny*scale, nz*scale, d*scale
+	return quadric{
+		nx * nx, nx * ny, nx * nz, nx * d,
+		ny * ny, ny * nz, ny * d,
+		nz * nz, nz * d,
+		d * d,
+	}
+}
+
+func (q *quadric) add(o quadric) {
+	for i := range q {
+		q[i] += o[i]
+	}
+}
+
+func (q quadric) error(v *MapVector) float64 {
+	x, y, z := v.VertX, v.VertY, v.VertZ
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z +
+		q[9]
+}
+
+//edgeCollapse moves vector from onto vector to; stamps tell if either changed since it was queued
+type edgeCollapse struct {
+	from, to           int
+	cost, length       float64
+	fromStamp, toStamp int
+}
+
+type collapseQueue []edgeCollapse
+
+func (q collapseQueue) Len() int            { return len(q) }
+func (q collapseQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *collapseQueue) Push(x interface{}) { *q = append(*q, x.(edgeCollapse)) }
+
+//Less pops the cheapest collapse first: the lowest cost, then of equal costs the shorter edge,
+//so flat ground is decimated evenly, then the lower from and to vectors, so it is decimated
+//the same every run
+func (q collapseQueue) Less(i, j int) bool {
+	if q[i].cost != q[j].cost {
+		return q[i].cost < q[j].cost
+	}
+	if q[i].length != q[j].length {
+		return q[i].length < q[j].length
+	}
+	if q[i].from != q[j].from {
+		return q[i].from < q[j].from
+	}
+	return q[i].to < q[j].to
+}
+
+func (q *collapseQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+//neighbours a vector may have after a collapse
+const maxValence = 12
+
+//decimation is the mesh while simplifyMesh collapses its edges
+type decimation struct {
+	compositeVector []*MapVector
+	triangles       [][3]int
+	around          [][]int //primitives using each vector
+	marks           []int   //scratch marks of vectors, valid when equal to mark
+	mark            int
+}
+
+//neighbours lists the vectors sharing a primitive with v, each once
+func (d *decimation) neighbours(v int) []int {
+	d.mark++
+	var result []int
+	for _, t := range d.around[v] {
+		for _, w := range d.triangles[t] {
+			if w != v && d.marks[w] != d.mark {
+				d.marks[w] = d.mark
+				result = append(result, w)
+			}
+		}
+	}
+	return result
+}
+
+//canCollapse tells if moving from onto to keeps the surface a manifold facing the same way:
+//no primitive may turn over, and the two may only share the neighbours opposite the edge.
+//The vector left may not end up with more than maxValence neighbours, which keeps the
+//primitives from turning into slivers
+func (d *decimation) canCollapse(from, to int) bool {
+	opposite := 0
+	for _, t := range d.around[from] {
+		triangle := d.triangles[t]
+		if triangle[0] == to || triangle[1] == to || triangle[2] == to {
+			opposite++
+			continue
+		}
+		before := primitiveNormal(d.compositeVector, triangle)
+		for k := range triangle {
+			if triangle[k] == from {
+				triangle[k] = to
+			}
+		}
+		after := primitiveNormal(d.compositeVector, triangle)
+		if before[0]*after[0]+before[1]*after[1]+before[2]*after[2] <= 0 {
+			return false
+		}
+	}
+	if opposite == 0 {
+		return false
+	}
+
+	fromNeighbours := d.neighbours(from)
+	toNeighbours := d.neighbours(to)
+	shared := 0
+	for _, w := range fromNeighbours {
+		if d.marks[w] == d.mark {
+			shared++
+		}
+	}
+	return shared == opposite && len(fromNeighbours)+len(toNeighbours)-shared-2 <= maxValence
+}
+
+//simplifyMesh decimates the model by collapsing edges, cheapest quadric error first, while the
+//vertical error stays within maxError metres. A collapse keeps one of its two vectors as it is,
+//so every vector left is an original sample with its latitude, longitude and elevation; the
+//outline of the model is kept, and collapses that would fold a primitive over are skipped
+func simplifyMesh(compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex,
+	maxError float64) ([]*MapVector, []*MapPrimitiveIndex) {
+
+	d := &decimation{
+		compositeVector: compositeVector,
+		triangles:       make([][3]int, len(primitiveIndex)),
+		around:          make([][]int, len(compositeVector)),
+		marks:           make([]int, len(compositeVector)),
+	}
+	triangles, around := d.triangles, d.around
+	removed := make([]bool, len(primitiveIndex))
+	quadrics := make([]quadric, len(compositeVector))
+	edges := make(map[[2]int]int)
+	for i, index := range primitiveIndex {
+		triangles[i] = [3]int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft}
+		q := verticalQuadric(compositeVector[triangles[i][0]], compositeVector[triangles[i][1]], compositeVector[triangles[i][2]])
+		for k, v := range triangles[i] {
+			around[v] = append(around[v], i)
+			quadrics[v].add(q)
+			edge := [2]int{v, triangles[i][(k+1)%3]}
+			if edge[0] > edge[1] {
+				edge[0], edge[1] = edge[1], edge[0]
+			}
+			edges[edge]++
+		}
+	}
+	//an edge of only one primitive is on the outline, which stays where it is
+	outline := make([]bool, len(compositeVector))
+	for edge, count := range edges {
+		if count == 1 {
+			outline[edge[0]], outline[edge[1]] = true, true
+		}
+	}
+
+	gone := make([]bool, len(compositeVector))
+	stamps := make([]int, len(compositeVector))
+	queue := &collapseQueue{}
+	push := func(from, to int) {
+		if outline[from] {
+			return
+		}
+		q := quadrics[from]
+		q.add(quadrics[to])
+		a, b := compositeVector[from], compositeVector[to]
+		heap.Push(queue, edgeCollapse{
+			from:      from,
+			to:        to,
+			cost:      q.error(b),
+			length:    (a.VertX-b.VertX)*(a.VertX-b.VertX) + (a.VertY-b.VertY)*(a.VertY-b.VertY) + (a.VertZ-b.VertZ)*(a.VertZ-b.VertZ),
+			fromStamp: stamps[from],
+			toStamp:   stamps[to],
+		})
+	}
+	for edge := range edges {
+		push(edge[0], edge[1])
+		push(edge[1], edge[0])
+	}
+
+	limit := maxError * maxError
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(edgeCollapse)
+		if c.cost > limit {
+			break
+		}
+		if gone[c.from] || gone[c.to] || c.fromStamp != stamps[c.from] || c.toStamp != stamps[c.to] {
+			continue
+		}
+		if !d.canCollapse(c.from, c.to) {
+			continue
+		}
+
+		//the primitives on the edge go, the rest of from's move over to to
+		for _, t := range around[c.from] {
+			triangle := &triangles[t]
+			if triangle[0] == c.to || triangle[1] == c.to || triangle[2] == c.to {
+				removed[t] = true
+				for _, v := range triangle {
+					if v != c.from {
+						around[v] = withoutPrimitive(around[v], t)
+					}
+				}
+				continue
+			}
+			for k := range triangle {
+				if triangle[k] == c.from {
+					triangle[k] = c.to
+				}
+			}
+			around[c.to] = append(around[c.to], t)
+		}
+		around[c.from] = nil
+		gone[c.from] = true
+		quadrics[c.to].add(quadrics[c.from])
+
+		stamps[c.to]++
+		for _, w := range d.neighbours(c.to) {
+			push(c.to, w)
+			push(w, c.to)
+		}
+	}
+
+	//only the vectors still used are kept, in their order
+	renumber := make([]int, len(compositeVector))
+	simplifiedVector := []*MapVector{}
+	for i, vector := range compositeVector {
+		renumber[i] = -1
+		if !gone[i] && len(around[i]) > 0 {
+			renumber[i] = len(simplifiedVector)
+			simplifiedVector = append(simplifiedVector, vector)
+		}
+	}
+	simplifiedIndex := []*MapPrimitiveIndex{}
+	for t, triangle := range triangles {
+		if removed[t] {
+			continue
+		}
+		simplifiedIndex = append(simplifiedIndex, &MapPrimitiveIndex{
+			PrimitiveBottom: renumber[triangle[0]],
+			PrimitiveTop:    renumber[triangle[1]],
+			PrimitiveLeft:   renumber[triangle[2]],
+		})
+	}
+	return simplifiedVector, simplifiedIndex
+}
+
+func primitiveNormal(compositeVector []*MapVector, triangle [3]int) [3]float64 {
+	a, b, c := compositeVector[triangle[0]], compositeVector[triangle[1]], compositeVector[triangle[2]]
+	ux, uy, uz := b.VertX-a.VertX, b.VertY-a.VertY, b.VertZ-a.VertZ
+	vx, vy, vz := c.VertX-a.VertX, c.VertY-a.VertY, c.VertZ-a.VertZ
+	return [3]float64{uy*vz - uz*vy, uz*vx - ux*vz, ux*vy - uy*vx}
+}
+
+func withoutPrimitive(primitives []int, t int) []int {
+	for i, p := range primitives {
+		if p == t {
+			return append(primitives[:i], primitives[i+1:]...)
+		}
+	}
+	return primitives
+}
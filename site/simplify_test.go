@@ -0,0 +1,119 @@
+package site
+
+import (
+	"math"
+	"testing"
+)
+
+//surfaceY is the Y of the surface of the primitives straight above or below x, z; false off the surface
+func surfaceY(compositeVector []*MapVector, primitiveIndex []*MapPrimitiveIndex, x, z float64) (float64, bool) {
+	for _, index := range primitiveIndex {
+		a, b, c := compositeVector[index.PrimitiveBottom], compositeVector[index.PrimitiveTop], compositeVector[index.PrimitiveLeft]
+		area := (b.VertX-a.VertX)*(c.VertZ-a.VertZ) - (c.VertX-a.VertX)*(b.VertZ-a.VertZ)
+		wb := ((x-a.VertX)*(c.VertZ-a.VertZ) - (c.VertX-a.VertX)*(z-a.VertZ)) / area
+		wc := ((b.VertX-a.VertX)*(z-a.VertZ) - (x-a.VertX)*(b.VertZ-a.VertZ)) / area
+		if wb < -1e-9 || wc < -1e-9 || wb+wc > 1+1e-9 {
+			continue
+		}
+		return a.VertY + wb*(b.VertY-a.VertY) + wc*(c.VertY-a.VertY), true
+	}
+	return 0, false
+}
+
+func TestSimplifyMesh(t *testing.T) {
+	const rows, cols, maxError = 24, 20, 0.05
+	for _, test := range []struct {
+		name   string
+		height func(row, col int) float64
+	}{
+		{"flat", func(row, col int) float64 { return 0 }},
+		{"ramp", func(row, col int) float64 { return 0.2 * float64(row) }},
+		{"ridge", func(row, col int) float64 { return 3 - 0.5*math.Abs(float64(col)-9.5) }},
+		{"rolling", func(row, col int) float64 {
+			return 0.3 * math.Sin(float64(row)/4) * math.Cos(float64(col)/5)
+		}},
+	} {
+		_, compositeVector, primitiveIndex := testGrid(rows, cols, test.height)
+		original := make(map[*MapVector]MapVector)
+		for _, vector := range compositeVector {
+			original[vector] = *vector
+		}
+		outline := make(map[*MapVector]bool)
+		for i, vector := range compositeVector {
+			if row, col := i/cols, i%cols; row == 0 || col == 0 || row == rows-1 || col == cols-1 {
+				outline[vector] = true
+			}
+		}
+
+		simplifiedVector, simplifiedIndex := simplifyMesh(compositeVector, primitiveIndex, maxError)
+		if len(simplifiedIndex) >= len(primitiveIndex) {
+			t.Errorf("%s: %d of %d primitives left", test.name, len(simplifiedIndex), len(primitiveIndex))
+		}
+
+		//the vectors left are the samples as they were, all in use, and the outline is kept
+		used := make([]int, len(simplifiedVector))
+		for _, index := range simplifiedIndex {
+			triangle := []int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft}
+			for _, i := range triangle {
+				if i < 0 || i >= len(simplifiedVector) {
+					t.Fatalf("%s: primitive %v of %d vectors", test.name, triangle, len(simplifiedVector))
+				}
+				used[i]++
+			}
+			if triangle[0] == triangle[1] || triangle[1] == triangle[2] || triangle[2] == triangle[0] {
+				t.Errorf("%s: degenerate primitive %v", test.name, triangle)
+			}
+		}
+		kept := make(map[*MapVector]bool)
+		for i, vector := range simplifiedVector {
+			if used[i] == 0 {
+				t.Errorf("%s: vector %d is in no primitive", test.name, i)
+			}
+			sample, ok := original[vector]
+			if !ok {
+				t.Fatalf("%s: vector %d is not a sample", test.name, i)
+			}
+			if *vector != sample {
+				t.Errorf("%s: vector %d is %+v, the sample was %+v", test.name, i, *vector, sample)
+			}
+			if kept[vector] {
+				t.Errorf("%s: sample %+v is kept twice", test.name, sample)
+			}
+			kept[vector] = true
+		}
+		for vector := range outline {
+			if !kept[vector] {
+				t.Errorf("%s: outline vector at %.2f, %.2f is gone", test.name, vector.VertX, vector.VertZ)
+			}
+		}
+
+		//every primitive still faces up, and together they cover the ground once
+		var area, simplifiedArea float64
+		for _, index := range primitiveIndex {
+			area += primitiveNormal(compositeVector, [3]int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft})[1]
+		}
+		for _, index := range simplifiedIndex {
+			normal := primitiveNormal(simplifiedVector, [3]int{index.PrimitiveBottom, index.PrimitiveTop, index.PrimitiveLeft})
+			if normal[1]*area <= 0 {
+				t.Errorf("%s: primitive %+v is turned over", test.name, *index)
+			}
+			simplifiedArea += normal[1]
+		}
+		if math.Abs(simplifiedArea-area) > 1e-6*math.Abs(area) {
+			t.Errorf("%s: the primitives cover %.3f square metres of %.3f", test.name, math.Abs(simplifiedArea)/2, math.Abs(area)/2)
+		}
+
+		//no sample is farther from the decimated surface than the error allows
+		for _, vector := range compositeVector {
+			y, ok := surfaceY(simplifiedVector, simplifiedIndex, vector.VertX, vector.VertZ)
+			if !ok {
+				t.Errorf("%s: no surface at %.2f, %.2f", test.name, vector.VertX, vector.VertZ)
+				continue
+			}
+			if math.Abs(y-vector.VertY) > maxError {
+				t.Errorf("%s: the sample at %.2f, %.2f is %.3f metres off the surface", test.name,
+					vector.VertX, vector.VertZ, math.Abs(y-vector.VertY))
+			}
+		}
+	}
+}